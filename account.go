@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	// ErrFinesOwed returned when a patron who owes too much borrows
+	ErrFinesOwed = errors.New("patron owes more in fines than may be owed while borrowing")
+
+	// ErrOverpayment returned when a payment or waiver is more than the
+	// patron owes
+	ErrOverpayment = errors.New("amount is more than the patron owes")
+
+	// ErrPatronOwes returned when deleting a patron with a balance
+	ErrPatronOwes = errors.New("patron still has a balance to settle")
+
+	// ErrPatronHasLedger returned when deleting a patron whose ledger has
+	// entries, which are kept once settled
+	ErrPatronHasLedger = errors.New("patron has a ledger that is kept")
+)
+
+// EntryKind what an account entry does to the balance
+type EntryKind string
+
+// valid entry kinds, a charge adds to the balance and the others settle it
+const (
+	EntryCharge  EntryKind = "charge"
+	EntryPayment EntryKind = "payment"
+	EntryWaiver  EntryKind = "waiver"
+)
+
+// AccountEntry a line of a patron's ledger. Entries are only ever added,
+// and amounts are in cents.
+type AccountEntry struct {
+	ID        int       `json:"id"`
+	PatronID  int       `json:"patronId" db:"patron_id"`
+	Kind      EntryKind `json:"kind"`
+	Amount    int       `json:"amount"`
+	LoanID    *int      `json:"loanId,omitempty" db:"loan_id"`
+	Note      string    `json:"note,omitempty"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+}
+
+const accountEntryColumns = "id, patron_id, kind, amount, loan_id, note, created_at"
+
+// signed the entry's effect on the balance
+func (e AccountEntry) signed() int {
+	if e.Kind == EntryCharge {
+		return e.Amount
+	}
+
+	return -e.Amount
+}
+
+// Account a patron's ledger, the latest entry first, with the balance it
+// comes to. Overdue loans that haven't been charged yet are listed with
+// what they would cost if returned now.
+type Account struct {
+	PatronID int            `json:"patronId"`
+	Balance  int            `json:"balance"`
+	Accruing int            `json:"accruing"`
+	Overdue  []OverdueLoan  `json:"overdue"`
+	Entries  []AccountEntry `json:"entries"`
+}
+
+// balanceQuery the balance of the patron in $1
+const balanceQuery = "SELECT COALESCE(SUM(CASE kind WHEN 'charge' THEN amount ELSE -amount END), 0) FROM account_entries WHERE patron_id=$1"
+
+// chargeOverdue charges the patron of a loan just returned its fine, if
+// it was returned late enough to owe one
+func chargeOverdue(tx *sqlx.Tx, loan Loan, fines FineSchedule) error {
+	if loan.PatronID == nil {
+		return nil
+	}
+	days, fine := fines.Fine(loan, *loan.ReturnedAt)
+	if fine == 0 {
+		return nil
+	}
+
+	_, err := tx.Exec("INSERT INTO account_entries (patron_id, kind, amount, loan_id, note) VALUES ($1, $2, $3, $4, $5)",
+		*loan.PatronID, EntryCharge, fine, loan.ID, overdueNote(days))
+	return err
+}
+
+// overdueNote describes the charge for a loan returned days late
+func overdueNote(days int) string {
+	if days == 1 {
+		return "Returned 1 day late"
+	}
+
+	return fmt.Sprintf("Returned %d days late", days)
+}
+
+// entriesAtBranch SQL condition that an entry charges for a loan of a copy
+// shelved at a branch, always true when branchID is 0
+func entriesAtBranch(branchID int) string {
+	if branchID == 0 {
+		return "TRUE"
+	}
+
+	return "loan_id IN (SELECT id FROM loans WHERE " + atBranch("item_id", branchID) + ")"
+}
+
+// GetAccount returns p's ledger and balance. With branchID set the ledger
+// only lists charges for loans of copies shelved there, while the balance
+// is still all p owes.
+func GetAccount(db *sqlx.DB, p *Patron, branchID int) (Account, error) {
+	tx, err := beginRead(db)
+	if err != nil {
+		return Account{}, err
+	}
+	defer tx.Rollback()
+
+	patron := Patron{}
+	if err := tx.Get(&patron, "SELECT "+patronColumns+" FROM patrons WHERE id=$1", p.ID); err != nil {
+		return Account{}, err
+	}
+
+	account := Account{PatronID: p.ID, Entries: []AccountEntry{}}
+	if err := tx.Get(&account.Balance, balanceQuery, p.ID); err != nil {
+		return Account{}, err
+	}
+	if err := tx.Select(&account.Entries, "SELECT "+accountEntryColumns+" FROM account_entries WHERE patron_id=$1 AND "+entriesAtBranch(branchID)+" ORDER BY created_at DESC, id DESC", p.ID); err != nil {
+		return Account{}, err
+	}
+
+	return account, nil
+}
+
+// AddEntry settles part of the patron's balance with a payment or waiver,
+// refusing to take the balance below nothing
+func (e *AccountEntry) AddEntry(db *sqlx.DB) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var locked int
+	if err := tx.Get(&locked, "SELECT id FROM patrons WHERE id=$1 FOR NO KEY UPDATE", e.PatronID); err != nil {
+		return err
+	}
+
+	var balance int
+	if err := tx.Get(&balance, balanceQuery, e.PatronID); err != nil {
+		return err
+	}
+	if e.Amount > balance {
+		return ErrOverpayment
+	}
+
+	entry := AccountEntry{}
+	err = tx.Get(&entry, "INSERT INTO account_entries (patron_id, kind, amount, note) VALUES ($1, $2, $3, $4) RETURNING "+accountEntryColumns,
+		e.PatronID, e.Kind, e.Amount, e.Note)
+	if err != nil {
+		return err
+	}
+
+	*e = entry
+	return tx.Commit()
+}
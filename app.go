@@ -3,31 +3,56 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
 )
 
 // App main entry for program
 type App struct {
 	Router *mux.Router
-	DB     *sqlx.DB
+	Store  Store
+
+	// MaxPageSize caps the count parameter on list endpoints
+	MaxPageSize int
+
+	// AutoMigrate brings the store schema up to date during Initialize
+	AutoMigrate bool
+
+	// LoanPeriod how long a checkout lends a book for, DefaultLoanPeriod
+	// when unset
+	LoanPeriod time.Duration
+
+	// PickupPeriod how long a returned book waits for the hold it goes to,
+	// DefaultPickupPeriod when unset
+	PickupPeriod time.Duration
+
+	// Fines what overdue loans cost, DefaultFineSchedule when unset
+	Fines FineSchedule
+
+	// MaxBalance cents a patron may owe and still borrow, counting the
+	// fines their overdue loans are accruing, DefaultMaxBalance when nil
+	MaxBalance *int
 }
 
-// Initialize database and routes
-func (a *App) Initialize(dataSourceName string) {
-	var err error
+// Initialize store and routes
+func (a *App) Initialize(store Store) {
+	a.Store = store
 
-	a.DB, err = sqlx.Connect("postgres", dataSourceName)
-	if err != nil {
-		log.Fatal(err)
+	if m, ok := store.(Migrater); ok && a.AutoMigrate {
+		if err := m.Migrate(); err != nil {
+			log.Fatal(err)
+		}
 	}
 
 	a.Router = mux.NewRouter()
+	a.Router.Use(RequestIDMiddleware)
 	a.InitializeRoutes()
 }
 
@@ -43,13 +68,48 @@ func (a *App) InitializeRoutes() {
 
 	a.Router.HandleFunc("/book/{id:[0-9]+}", a.GetBook).Methods("GET")
 	a.Router.HandleFunc("/book/{id:[0-9]+}", a.UpdateBook).Methods("PUT")
+	a.Router.HandleFunc("/book/{id:[0-9]+}", a.PatchBook).Methods("PATCH")
 	a.Router.HandleFunc("/book/{id:[0-9]+}", a.DeleteBook).Methods("DELETE")
 
+	a.Router.HandleFunc("/book/{id:[0-9]+}/contributors", a.GetContributors).Methods("GET")
+	a.Router.HandleFunc("/book/{id:[0-9]+}/contributors", a.SetContributors).Methods("PUT")
+
+	a.Router.HandleFunc("/book/{id:[0-9]+}/editions", a.GetEditions).Methods("GET")
+	a.Router.HandleFunc("/book/{id:[0-9]+}/editions", a.CreateEdition).Methods("POST")
+
+	a.Router.HandleFunc("/book/{id:[0-9]+}/editions/{edition_id:[0-9]+}", a.GetEdition).Methods("GET")
+	a.Router.HandleFunc("/book/{id:[0-9]+}/editions/{edition_id:[0-9]+}", a.UpdateEdition).Methods("PUT")
+	a.Router.HandleFunc("/book/{id:[0-9]+}/editions/{edition_id:[0-9]+}", a.DeleteEdition).Methods("DELETE")
+
+	a.Router.HandleFunc("/isbn/{isbn}", a.GetEditionByISBN).Methods("GET")
+
+	a.Router.HandleFunc("/book/{id:[0-9]+}/items", a.GetItems).Methods("GET")
+	a.Router.HandleFunc("/book/{id:[0-9]+}/items", a.CreateItem).Methods("POST")
+
+	a.Router.HandleFunc("/book/{id:[0-9]+}/items/{item_id:[0-9]+}", a.GetItem).Methods("GET")
+	a.Router.HandleFunc("/book/{id:[0-9]+}/items/{item_id:[0-9]+}", a.UpdateItem).Methods("PUT")
+	a.Router.HandleFunc("/book/{id:[0-9]+}/items/{item_id:[0-9]+}", a.DeleteItem).Methods("DELETE")
+
+	a.Router.HandleFunc("/barcode/{barcode}", a.GetItemByBarcode).Methods("GET")
+
+	a.Router.HandleFunc("/book/{id:[0-9]+}/checkout", a.Checkout).Methods("POST")
+	a.Router.HandleFunc("/book/{id:[0-9]+}/checkin", a.Checkin).Methods("POST")
+	a.Router.HandleFunc("/book/{id:[0-9]+}/loans", a.GetLoans).Methods("GET")
+
+	a.Router.HandleFunc("/book/{id:[0-9]+}/holds", a.GetHolds).Methods("GET")
+	a.Router.HandleFunc("/book/{id:[0-9]+}/holds", a.PlaceHold).Methods("POST")
+	a.Router.HandleFunc("/book/{id:[0-9]+}/holds/{hold_id:[0-9]+}", a.GetHold).Methods("GET")
+	a.Router.HandleFunc("/book/{id:[0-9]+}/holds/{hold_id:[0-9]+}", a.CancelHold).Methods("DELETE")
+
+	a.Router.HandleFunc("/search", a.Search).Methods("GET")
+	a.Router.HandleFunc("/suggest", a.Suggest).Methods("GET")
+
 	a.Router.HandleFunc("/authors", a.GetAuthors).Methods("GET")
 	a.Router.HandleFunc("/author", a.CreateAuthor).Methods("POST")
 
 	a.Router.HandleFunc("/author/{id:[0-9]+}", a.GetAuthor).Methods("GET")
 	a.Router.HandleFunc("/author/{id:[0-9]+}", a.UpdateAuthor).Methods("PUT")
+	a.Router.HandleFunc("/author/{id:[0-9]+}", a.PatchAuthor).Methods("PATCH")
 	a.Router.HandleFunc("/author/{id:[0-9]+}", a.DeleteAuthor).Methods("DELETE")
 
 	a.Router.HandleFunc("/publishers", a.GetPublishers).Methods("GET")
@@ -57,12 +117,41 @@ func (a *App) InitializeRoutes() {
 
 	a.Router.HandleFunc("/publisher/{id:[0-9]+}", a.GetPublisher).Methods("GET")
 	a.Router.HandleFunc("/publisher/{id:[0-9]+}", a.UpdatePublisher).Methods("PUT")
+	a.Router.HandleFunc("/publisher/{id:[0-9]+}", a.PatchPublisher).Methods("PATCH")
 	a.Router.HandleFunc("/publisher/{id:[0-9]+}", a.DeletePublisher).Methods("DELETE")
+
+	a.Router.HandleFunc("/patrons", a.GetPatrons).Methods("GET")
+	a.Router.HandleFunc("/patron", a.CreatePatron).Methods("POST")
+
+	a.Router.HandleFunc("/patron/{id:[0-9]+}", a.GetPatron).Methods("GET")
+	a.Router.HandleFunc("/patron/{id:[0-9]+}", a.UpdatePatron).Methods("PUT")
+	a.Router.HandleFunc("/patron/{id:[0-9]+}", a.PatchPatron).Methods("PATCH")
+	a.Router.HandleFunc("/patron/{id:[0-9]+}", a.DeletePatron).Methods("DELETE")
+	a.Router.HandleFunc("/patron/{id:[0-9]+}/loans", a.GetPatronLoans).Methods("GET")
+	a.Router.HandleFunc("/patron/{id:[0-9]+}/holds", a.GetPatronHolds).Methods("GET")
+	a.Router.HandleFunc("/patron/{id:[0-9]+}/account", a.GetAccount).Methods("GET")
+	a.Router.HandleFunc("/patron/{id:[0-9]+}/payments", a.AddPayment).Methods("POST")
+	a.Router.HandleFunc("/patron/{id:[0-9]+}/waivers", a.AddWaiver).Methods("POST")
+
+	a.Router.HandleFunc("/branches", a.GetBranches).Methods("GET")
+	a.Router.HandleFunc("/branch", a.CreateBranch).Methods("POST")
+
+	a.Router.HandleFunc("/branch/{id:[0-9]+}", a.GetBranch).Methods("GET")
+	a.Router.HandleFunc("/branch/{id:[0-9]+}", a.UpdateBranch).Methods("PUT")
+	a.Router.HandleFunc("/branch/{id:[0-9]+}", a.DeleteBranch).Methods("DELETE")
+
+	a.Router.HandleFunc("/transfers", a.GetTransfers).Methods("GET")
+	a.Router.HandleFunc("/transfer", a.RequestTransfer).Methods("POST")
+
+	a.Router.HandleFunc("/transfer/{id:[0-9]+}", a.GetTransfer).Methods("GET")
+	a.Router.HandleFunc("/transfer/{id:[0-9]+}/dispatch", a.DispatchTransfer).Methods("POST")
+	a.Router.HandleFunc("/transfer/{id:[0-9]+}/receive", a.ReceiveTransfer).Methods("POST")
+	a.Router.HandleFunc("/transfer/{id:[0-9]+}/cancel", a.CancelTransfer).Methods("POST")
 }
 
-// RespondWithError json error response
-func RespondWithError(w http.ResponseWriter, code int, message string) {
-	RespondWithJSON(w, code, map[string]string{"error": message})
+// RespondWithError problem+json response for a plain HTTP status
+func RespondWithError(w http.ResponseWriter, r *http.Request, code int, message string) {
+	RespondWithProblem(w, r, NewProblem(code, message))
 }
 
 // RespondWithJSON normal json response
@@ -80,43 +169,82 @@ func (a *App) GetBook(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(params["id"])
 
 	if err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid book ID")
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid book ID")
+		return
+	}
+
+	expand, err := ParseExpand(r.FormValue("expand"), bookRelations)
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	fields, err := ParseFields(r.FormValue("fields"), bookSchema)
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
+	fields.Expand(expand, bookRelations)
 
 	b := Book{ID: id}
-	if err := b.GetBook(a.DB); err != nil {
+	if err := a.Store.GetBook(&b, expand, fields.Columns(nil, bookFields, bookDerivedFields, bookRelationColumns)); err != nil {
 		switch err {
 		case sql.ErrNoRows:
-			RespondWithError(w, http.StatusNotFound, "Book not found")
+			RespondWithError(w, r, http.StatusNotFound, "Book not found")
 		default:
-			RespondWithError(w, http.StatusInternalServerError, err.Error())
+			RespondWithProblem(w, r, err)
 		}
 		return
 	}
 
-	RespondWithJSON(w, http.StatusOK, b)
+	if NotModifiedTag(w, r, b.ETag(expand, fields)) {
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, fields.Select(b))
 }
 
 // GetBooks all books
 func (a *App) GetBooks(w http.ResponseWriter, r *http.Request) {
-	count, _ := strconv.Atoi(r.FormValue("count"))
-	start, _ := strconv.Atoi(r.FormValue("start"))
-
-	if count > 10 || count < 1 {
-		count = 10
+	opts, err := a.listOptions(r, bookSortColumns, bookFilters)
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if opts.Expand, err = ParseExpand(r.FormValue("expand"), bookRelations); err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	fields, err := ParseFields(r.FormValue("fields"), bookSchema)
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	fields.Expand(opts.Expand, bookRelations)
+	opts.Columns = fields.Columns(opts.Sort, bookFields, bookDerivedFields, bookRelationColumns)
+	if opts.Facets, err = ParseFacets(r.FormValue("facets")); err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
 	}
-	if start < 0 {
-		start = 0
+	if opts.Branch, err = ParseBranch(r.FormValue("branch_id")); err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
 	}
 
-	books, err := GetBooks(a.DB, start, count)
+	books, total, facets, err := a.Store.GetBooks(opts.lookahead())
 	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		RespondWithProblem(w, r, err)
 		return
 	}
 
-	RespondWithJSON(w, http.StatusOK, books)
+	next := ""
+	if len(books) > opts.Count {
+		books = books[:opts.Count]
+		if opts.Match == nil {
+			next = EncodeCursor(opts.Sort, books[len(books)-1])
+		}
+	}
+
+	RespondWithPage(w, r, fields.Select(books), opts, total, next, facets)
 }
 
 // CreateBook new book
@@ -125,16 +253,27 @@ func (a *App) CreateBook(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 
 	if err := decoder.Decode(&book); err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
 	defer r.Body.Close()
 
-	if err := book.CreateBook(a.DB); err != nil {
-		RespondWithError(w, http.StatusInternalServerError, err.Error())
+	if errs := book.Validate(); len(errs) > 0 {
+		RespondWithProblem(w, r, errs)
+		return
+	}
+
+	if err := a.checkBookRelations(&book); err != nil {
+		RespondWithProblem(w, r, err)
 		return
 	}
 
+	if err := a.Store.CreateBook(&book); err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", ETag(book.Version))
 	RespondWithJSON(w, http.StatusCreated, book)
 }
 
@@ -143,257 +282,2350 @@ func (a *App) UpdateBook(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	id, err := strconv.Atoi(params["id"])
 	if err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid book ID")
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid book ID")
+		return
+	}
+
+	version, err := IfMatchVersion(r, a.bookVersion(id))
+	if err != nil {
+		RespondWithProblem(w, r, err)
 		return
 	}
 
 	var book Book
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&book); err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid resquest payload")
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid resquest payload")
 		return
 	}
 	defer r.Body.Close()
 	book.ID = id
+	book.Version = version
+
+	if errs := book.Validate(); len(errs) > 0 {
+		RespondWithProblem(w, r, errs)
+		return
+	}
+
+	if err := a.checkBookRelations(&book); err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
 
-	if err := book.UpdateBook(a.DB); err != nil {
-		RespondWithError(w, http.StatusInternalServerError, err.Error())
+	if err := a.Store.UpdateBook(&book); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Book not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
 		return
 	}
 
+	w.Header().Set("ETag", ETag(book.Version))
 	RespondWithJSON(w, http.StatusOK, book)
 }
 
-// DeleteBook remove book from db
-func (a *App) DeleteBook(w http.ResponseWriter, r *http.Request) {
+// PatchBook apply a merge patch or JSON patch to a book
+func (a *App) PatchBook(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	id, err := strconv.Atoi(params["id"])
 	if err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid Book ID")
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid book ID")
 		return
 	}
 
-	book := Book{ID: id}
-	if err := book.DeleteBook(a.DB); err != nil {
-		RespondWithError(w, http.StatusInternalServerError, err.Error())
+	version, err := IfMatchVersion(r, a.bookVersion(id))
+	if err != nil {
+		RespondWithProblem(w, r, err)
 		return
 	}
 
-	RespondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
-}
+	current := Book{ID: id}
+	if err := a.Store.GetBook(&current, Expand{}, nil); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Book not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
 
-// GetAuthor return a single author
-func (a *App) GetAuthor(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	id, err := strconv.Atoi(params["id"])
+	if version != 0 && version != current.Version {
+		RespondWithProblem(w, r, ErrVersionMismatch)
+		return
+	}
 
+	var patched Book
+	columns, err := applyPatch(r, current, &patched, bookFields)
 	if err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid author ID")
+		RespondWithProblem(w, r, err)
+		return
+	}
+	defer r.Body.Close()
+	patched.ID = id
+	patched.Version = current.Version
+
+	if errs := patched.Validate(); len(errs) > 0 {
+		RespondWithProblem(w, r, errs)
 		return
 	}
 
-	author := Author{ID: id}
-	if err := author.GetAuthor(a.DB); err != nil {
+	if err := a.checkBookRelations(&patched); err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	if err := a.Store.PatchBook(&patched, columns); err != nil {
 		switch err {
 		case sql.ErrNoRows:
-			RespondWithError(w, http.StatusNotFound, "Author not found")
+			RespondWithError(w, r, http.StatusNotFound, "Book not found")
+		case ErrVersionMismatch:
+			if version == 0 {
+				RespondWithError(w, r, http.StatusConflict, "Book was modified while being patched, retry the request")
+				return
+			}
+			RespondWithProblem(w, r, err)
 		default:
-			RespondWithError(w, http.StatusInternalServerError, err.Error())
+			RespondWithProblem(w, r, err)
 		}
 		return
 	}
 
-	RespondWithJSON(w, http.StatusOK, author)
+	w.Header().Set("ETag", ETag(patched.Version))
+	RespondWithJSON(w, http.StatusOK, patched)
 }
 
-// GetAuthors all authors
-func (a *App) GetAuthors(w http.ResponseWriter, r *http.Request) {
-	count, _ := strconv.Atoi(r.FormValue("count"))
-	start, _ := strconv.Atoi(r.FormValue("start"))
-
-	if count > 10 || count < 1 {
-		count = 10
-	}
-	if start < 0 {
-		start = 0
+// DeleteBook remove book from db
+func (a *App) DeleteBook(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid Book ID")
+		return
 	}
 
-	authors, err := GetAuthors(a.DB, start, count)
+	version, err := IfMatchVersion(r, a.bookVersion(id))
 	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	book := Book{ID: id, Version: version}
+	if err := a.Store.DeleteBook(&book); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Book not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
 		return
 	}
 
-	RespondWithJSON(w, http.StatusOK, authors)
+	RespondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
 }
 
-// CreateAuthor a new author
-func (a *App) CreateAuthor(w http.ResponseWriter, r *http.Request) {
-	var author Author
-	decoder := json.NewDecoder(r.Body)
+// GetContributors authors credited on a book
+func (a *App) GetContributors(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid book ID")
+		return
+	}
 
-	if err := decoder.Decode(&author); err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+	expand, err := ParseExpand(r.FormValue("expand"), contributorRelations)
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	book := Book{ID: id}
+	contributors, err := a.Store.GetContributors(&book, expand)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Book not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
 		return
 	}
-	defer r.Body.Close()
 
-	if err := author.CreateAuthor(a.DB); err != nil {
-		RespondWithError(w, http.StatusInternalServerError, err.Error())
+	if NotModifiedTag(w, r, contributorsTag(book.Version, contributors)) {
 		return
 	}
 
-	RespondWithJSON(w, http.StatusCreated, author)
+	RespondWithJSON(w, http.StatusOK, contributors)
 }
 
-// UpdateAuthor update author attributes
-func (a *App) UpdateAuthor(w http.ResponseWriter, r *http.Request) {
+// SetContributors replace the authors credited on a book, in the order given
+func (a *App) SetContributors(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	id, err := strconv.Atoi(params["id"])
 	if err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid author ID")
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid book ID")
 		return
 	}
 
-	var author Author
+	version, err := IfMatchVersion(r, a.bookVersion(id))
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	var contributors []Contributor
 	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&author); err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid resquest payload")
+	if err := decoder.Decode(&contributors); err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
 	defer r.Body.Close()
-	author.ID = id
 
-	if err := author.UpdateAuthor(a.DB); err != nil {
-		RespondWithError(w, http.StatusInternalServerError, err.Error())
+	if errs := ValidateContributors(contributors); len(errs) > 0 {
+		RespondWithProblem(w, r, errs)
 		return
 	}
 
-	RespondWithJSON(w, http.StatusOK, author)
+	errs := ValidationErrors{}
+	for i, c := range contributors {
+		switch err := a.Store.GetAuthor(&Author{ID: c.AuthorID}, nil); err {
+		case nil:
+		case sql.ErrNoRows:
+			errs.add(fmt.Sprintf("[%d].authorId", i), CodeInvalid, fmt.Sprintf("author %d not found", c.AuthorID))
+		default:
+			RespondWithProblem(w, r, err)
+			return
+		}
+	}
+	if len(errs) > 0 {
+		RespondWithProblem(w, r, errs)
+		return
+	}
+
+	book := Book{ID: id, Version: version}
+	stored, err := a.Store.SetContributors(&book, contributors)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Book not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("ETag", ETag(book.Version))
+	RespondWithJSON(w, http.StatusOK, stored)
 }
 
-// DeleteAuthor remove author
-func (a *App) DeleteAuthor(w http.ResponseWriter, r *http.Request) {
+// checkBookRelations confirms the author and publisher a book refers to exist
+func (a *App) checkBookRelations(b *Book) error {
+	if b.AuthorID != nil {
+		switch err := a.Store.GetAuthor(&Author{ID: *b.AuthorID}, nil); err {
+		case nil:
+		case sql.ErrNoRows:
+			return NewProblem(http.StatusUnprocessableEntity, "Author not found")
+		default:
+			return err
+		}
+	}
+
+	return a.checkPublisher(b.PublisherID)
+}
+
+// checkPublisher confirms the publisher a book or edition refers to exists
+func (a *App) checkPublisher(id *int) error {
+	if id == nil {
+		return nil
+	}
+
+	switch err := a.Store.GetPublisher(&Publisher{ID: *id}, nil); err {
+	case nil:
+		return nil
+	case sql.ErrNoRows:
+		return NewProblem(http.StatusUnprocessableEntity, "Publisher not found")
+	default:
+		return err
+	}
+}
+
+// bookVersion looks up a book's current version for IfMatchVersion
+func (a *App) bookVersion(id int) func() (int, error) {
+	return func() (int, error) {
+		b := Book{ID: id}
+		err := a.Store.GetBook(&b, Expand{}, nil)
+		return b.Version, err
+	}
+}
+
+// editionVersion looks up an edition's current version for IfMatchVersion
+func (a *App) editionVersion(bookID, id int) func() (int, error) {
+	return func() (int, error) {
+		e := Edition{ID: id, BookID: bookID}
+		err := a.Store.GetEdition(&e)
+		return e.Version, err
+	}
+}
+
+// itemVersion looks up a copy's current version for IfMatchVersion
+func (a *App) itemVersion(bookID, id int) func() (int, error) {
+	return func() (int, error) {
+		i := Item{ID: id, BookID: bookID}
+		err := a.Store.GetItem(&i)
+		return i.Version, err
+	}
+}
+
+// authorVersion looks up an author's current version for IfMatchVersion
+func (a *App) authorVersion(id int) func() (int, error) {
+	return func() (int, error) {
+		author := Author{ID: id}
+		err := a.Store.GetAuthor(&author, nil)
+		return author.Version, err
+	}
+}
+
+// publisherVersion looks up a publisher's current version for IfMatchVersion
+func (a *App) publisherVersion(id int) func() (int, error) {
+	return func() (int, error) {
+		p := Publisher{ID: id}
+		err := a.Store.GetPublisher(&p, nil)
+		return p.Version, err
+	}
+}
+
+// patronVersion looks up a patron's current version for IfMatchVersion
+func (a *App) patronVersion(id int) func() (int, error) {
+	return func() (int, error) {
+		p := Patron{ID: id}
+		err := a.Store.GetPatron(&p)
+		return p.Version, err
+	}
+}
+
+// branchVersion looks up a branch's current version for IfMatchVersion
+func (a *App) branchVersion(id int) func() (int, error) {
+	return func() (int, error) {
+		b := Branch{ID: id}
+		err := a.Store.GetBranch(&b)
+		return b.Version, err
+	}
+}
+
+// transferVersion looks up a transfer's current version for IfMatchVersion
+func (a *App) transferVersion(id int) func() (int, error) {
+	return func() (int, error) {
+		t := Transfer{ID: id}
+		err := a.Store.GetTransfer(&t)
+		return t.Version, err
+	}
+}
+
+// editionIDs reads the book and edition ids of an edition route
+func editionIDs(r *http.Request) (int, int, error) {
 	params := mux.Vars(r)
-	id, err := strconv.Atoi(params["id"])
+	bookID, err := strconv.Atoi(params["id"])
 	if err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid author ID")
-		return
+		return 0, 0, NewProblem(http.StatusBadRequest, "Invalid book ID")
 	}
 
-	author := Author{ID: id}
-	if err := author.DeleteAuthor(a.DB); err != nil {
-		RespondWithError(w, http.StatusInternalServerError, err.Error())
-		return
+	id, err := strconv.Atoi(params["edition_id"])
+	if err != nil {
+		return 0, 0, NewProblem(http.StatusBadRequest, "Invalid edition ID")
 	}
 
-	RespondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+	return bookID, id, nil
 }
 
-// GetPublisher a single publisher
-func (a *App) GetPublisher(w http.ResponseWriter, r *http.Request) {
+// GetEditions every edition of a book
+func (a *App) GetEditions(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	id, err := strconv.Atoi(params["id"])
-
 	if err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid publisher ID")
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid book ID")
 		return
 	}
 
-	p := Publisher{ID: id}
-	if err := p.GetPublisher(a.DB); err != nil {
+	editions, err := a.Store.GetEditions(&Book{ID: id})
+	if err != nil {
 		switch err {
 		case sql.ErrNoRows:
-			RespondWithError(w, http.StatusNotFound, "Publisher not found")
+			RespondWithError(w, r, http.StatusNotFound, "Book not found")
 		default:
-			RespondWithError(w, http.StatusInternalServerError, err.Error())
+			RespondWithProblem(w, r, err)
 		}
 		return
 	}
 
-	RespondWithJSON(w, http.StatusOK, p)
+	RespondWithJSON(w, http.StatusOK, editions)
 }
 
-// GetPublishers all publishers
-func (a *App) GetPublishers(w http.ResponseWriter, r *http.Request) {
-	count, _ := strconv.Atoi(r.FormValue("count"))
-	start, _ := strconv.Atoi(r.FormValue("start"))
-
-	if count > 10 || count < 1 {
-		count = 10
+// GetEdition a single edition of a book
+func (a *App) GetEdition(w http.ResponseWriter, r *http.Request) {
+	bookID, id, err := editionIDs(r)
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
 	}
-	if start < 0 {
-		start = 0
+
+	e := Edition{ID: id, BookID: bookID}
+	if err := a.Store.GetEdition(&e); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Edition not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
 	}
 
-	publishers, err := GetPublishers(a.DB, start, count)
-	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, err.Error())
+	if NotModified(w, r, e.Version) {
 		return
 	}
 
-	RespondWithJSON(w, http.StatusOK, publishers)
+	RespondWithJSON(w, http.StatusOK, e)
 }
 
-// CreatePublisher a new publisher
-func (a *App) CreatePublisher(w http.ResponseWriter, r *http.Request) {
-	var publisher Publisher
-	decoder := json.NewDecoder(r.Body)
+// GetEditionByISBN the edition with an ISBN-10 or ISBN-13, along with its book
+func (a *App) GetEditionByISBN(w http.ResponseWriter, r *http.Request) {
+	isbn, err := NormalizeISBN(mux.Vars(r)["isbn"])
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid ISBN")
+		return
+	}
 
-	if err := decoder.Decode(&publisher); err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+	e := Edition{ISBN13: isbn}
+	if err := a.Store.GetEditionByISBN(&e); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Edition not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
 		return
 	}
-	defer r.Body.Close()
 
-	if err := publisher.CreatePublisher(a.DB); err != nil {
-		RespondWithError(w, http.StatusInternalServerError, err.Error())
+	book := Book{ID: e.BookID}
+	if err := a.Store.GetBook(&book, Expand{}, nil); err != nil {
+		RespondWithProblem(w, r, err)
 		return
 	}
+	e.Book = &book
 
-	RespondWithJSON(w, http.StatusCreated, publisher)
+	if NotModified(w, r, e.Version) {
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, e)
 }
 
-// UpdatePublisher update publisher attributes
-func (a *App) UpdatePublisher(w http.ResponseWriter, r *http.Request) {
+// CreateEdition new edition of a book
+func (a *App) CreateEdition(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
-	id, err := strconv.Atoi(params["id"])
+	bookID, err := strconv.Atoi(params["id"])
 	if err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid publisher ID")
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid book ID")
 		return
 	}
 
-	var publisher Publisher
+	var e Edition
 	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&publisher); err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid resquest payload")
+	if err := decoder.Decode(&e); err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
 	defer r.Body.Close()
-	publisher.ID = id
+	e.BookID = bookID
 
-	if err := publisher.UpdatePublisher(a.DB); err != nil {
-		RespondWithError(w, http.StatusInternalServerError, err.Error())
+	switch err := a.Store.GetBook(&Book{ID: bookID}, Expand{}, nil); err {
+	case nil:
+	case sql.ErrNoRows:
+		RespondWithError(w, r, http.StatusNotFound, "Book not found")
+		return
+	default:
+		RespondWithProblem(w, r, err)
 		return
 	}
 
-	RespondWithJSON(w, http.StatusOK, publisher)
-}
+	if errs := e.Validate(); len(errs) > 0 {
+		RespondWithProblem(w, r, errs)
+		return
+	}
+	e.NormalizeISBN()
 
-// DeletePublisher remove publisher
-func (a *App) DeletePublisher(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	id, err := strconv.Atoi(params["id"])
-	if err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid publisher ID")
+	if err := a.checkPublisher(e.PublisherID); err != nil {
+		RespondWithProblem(w, r, err)
 		return
 	}
 
-	publisher := Publisher{ID: id}
-	if err := publisher.DeletePublisher(a.DB); err != nil {
-		RespondWithError(w, http.StatusInternalServerError, err.Error())
+	if err := a.Store.CreateEdition(&e); err != nil {
+		RespondWithProblem(w, r, err)
 		return
 	}
 
-	RespondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+	w.Header().Set("ETag", ETag(e.Version))
+	RespondWithJSON(w, http.StatusCreated, e)
+}
+
+// UpdateEdition replace an edition of a book
+func (a *App) UpdateEdition(w http.ResponseWriter, r *http.Request) {
+	bookID, id, err := editionIDs(r)
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	version, err := IfMatchVersion(r, a.editionVersion(bookID, id))
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	var e Edition
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&e); err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+	e.ID, e.BookID, e.Version = id, bookID, version
+
+	if errs := e.Validate(); len(errs) > 0 {
+		RespondWithProblem(w, r, errs)
+		return
+	}
+	e.NormalizeISBN()
+
+	if err := a.checkPublisher(e.PublisherID); err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	if err := a.Store.UpdateEdition(&e); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Edition not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("ETag", ETag(e.Version))
+	RespondWithJSON(w, http.StatusOK, e)
+}
+
+// DeleteEdition remove an edition of a book
+func (a *App) DeleteEdition(w http.ResponseWriter, r *http.Request) {
+	bookID, id, err := editionIDs(r)
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	version, err := IfMatchVersion(r, a.editionVersion(bookID, id))
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	e := Edition{ID: id, BookID: bookID, Version: version}
+	if err := a.Store.DeleteEdition(&e); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Edition not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+// itemIDs reads the book and item ids of an item route
+func itemIDs(r *http.Request) (int, int, error) {
+	params := mux.Vars(r)
+	bookID, err := strconv.Atoi(params["id"])
+	if err != nil {
+		return 0, 0, NewProblem(http.StatusBadRequest, "Invalid book ID")
+	}
+
+	id, err := strconv.Atoi(params["item_id"])
+	if err != nil {
+		return 0, 0, NewProblem(http.StatusBadRequest, "Invalid item ID")
+	}
+
+	return bookID, id, nil
+}
+
+// GetItems every copy of a book
+func (a *App) GetItems(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid book ID")
+		return
+	}
+
+	items, err := a.Store.GetItems(&Book{ID: id})
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Book not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, items)
+}
+
+// GetItem a single copy of a book
+func (a *App) GetItem(w http.ResponseWriter, r *http.Request) {
+	bookID, id, err := itemIDs(r)
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	i := Item{ID: id, BookID: bookID}
+	if err := a.Store.GetItem(&i); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Item not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	if NotModified(w, r, i.Version) {
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, i)
+}
+
+// GetItemByBarcode the copy with a barcode, along with its book
+func (a *App) GetItemByBarcode(w http.ResponseWriter, r *http.Request) {
+	i := Item{Barcode: mux.Vars(r)["barcode"]}
+	if err := a.Store.GetItemByBarcode(&i); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Item not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	book := Book{ID: i.BookID}
+	if err := a.Store.GetBook(&book, Expand{}, nil); err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+	i.Book = &book
+
+	if NotModified(w, r, i.Version) {
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, i)
+}
+
+// CreateItem new copy of a book
+func (a *App) CreateItem(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	bookID, err := strconv.Atoi(params["id"])
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid book ID")
+		return
+	}
+
+	var i Item
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&i); err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+	i.BookID = bookID
+
+	switch err := a.Store.GetBook(&Book{ID: bookID}, Expand{}, nil); err {
+	case nil:
+	case sql.ErrNoRows:
+		RespondWithError(w, r, http.StatusNotFound, "Book not found")
+		return
+	default:
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	i.Defaults()
+	errs := i.Validate()
+	if i.BranchID == 0 {
+		errs.add("branchId", CodeRequired, "branchId is required")
+	}
+	if len(errs) > 0 {
+		RespondWithProblem(w, r, errs)
+		return
+	}
+	if _, err := a.branch(i.BranchID); err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	if err := a.Store.CreateItem(&i); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Book not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("ETag", ETag(i.Version))
+	RespondWithJSON(w, http.StatusCreated, i)
+}
+
+// UpdateItem replace a copy of a book, its status follows its loans and
+// holds and its branch its transfers whatever the payload says
+func (a *App) UpdateItem(w http.ResponseWriter, r *http.Request) {
+	bookID, id, err := itemIDs(r)
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	version, err := IfMatchVersion(r, a.itemVersion(bookID, id))
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	var i Item
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&i); err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+	i.ID, i.BookID, i.Version = id, bookID, version
+
+	i.Defaults()
+	if errs := i.Validate(); len(errs) > 0 {
+		RespondWithProblem(w, r, errs)
+		return
+	}
+
+	if err := a.Store.UpdateItem(&i); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Item not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("ETag", ETag(i.Version))
+	RespondWithJSON(w, http.StatusOK, i)
+}
+
+// DeleteItem remove a copy of a book that is on the shelf
+func (a *App) DeleteItem(w http.ResponseWriter, r *http.Request) {
+	bookID, id, err := itemIDs(r)
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	version, err := IfMatchVersion(r, a.itemVersion(bookID, id))
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	i := Item{ID: id, BookID: bookID, Version: version}
+	if err := a.Store.DeleteItem(&i); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Item not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+// Checkout lends a copy of a book to the patron in the payload for the
+// loan period, the copy in the payload when there is one
+func (a *App) Checkout(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid book ID")
+		return
+	}
+
+	payload, err := readCirculation(r, false)
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+	patron, err := a.payloadPatron(payload)
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+	item, err := a.payloadItem(id, payload)
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	period := a.LoanPeriod
+	if period <= 0 {
+		period = DefaultLoanPeriod
+	}
+
+	maxBalance := DefaultMaxBalance
+	if a.MaxBalance != nil {
+		maxBalance = *a.MaxBalance
+	}
+
+	b := Book{ID: id}
+	loan, err := a.Store.Checkout(&b, &item, &patron, period, a.fines(), maxBalance)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Book not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	RespondWithJSON(w, http.StatusCreated, loan)
+}
+
+// circulation the payload of a checkout, checkin or hold, such as
+// {"patronId": n, "itemId": n} or {"patronId": n, "pickupBranchId": n}
+type circulation struct {
+	PatronID       int `json:"patronId"`
+	ItemID         int `json:"itemId"`
+	PickupBranchID int `json:"pickupBranchId"`
+}
+
+// readCirculation decodes the request's circulation payload, where an
+// empty body reads as an empty payload when the payload is optional
+func readCirculation(r *http.Request, optional bool) (circulation, error) {
+	if r.Body == nil {
+		r.Body = http.NoBody
+	}
+
+	var payload circulation
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&payload)
+	defer r.Body.Close()
+	if err == io.EOF && optional {
+		return circulation{}, nil
+	}
+	if err != nil {
+		return circulation{}, NewProblem(http.StatusBadRequest, "Invalid request payload")
+	}
+
+	return payload, nil
+}
+
+// payloadPatron the patron a checkout or hold is for
+func (a *App) payloadPatron(payload circulation) (Patron, error) {
+	if payload.PatronID == 0 {
+		return Patron{}, ValidationErrors{{Field: "patronId", Code: CodeRequired, Message: "patronId is required"}}
+	}
+	patron := Patron{ID: payload.PatronID}
+	switch err := a.Store.GetPatron(&patron); err {
+	case nil:
+		return patron, nil
+	case sql.ErrNoRows:
+		return Patron{}, NewProblem(http.StatusUnprocessableEntity, "Patron not found")
+	default:
+		return Patron{}, err
+	}
+}
+
+// branch the branch a payload names, with no ID when it names none
+func (a *App) branch(id int) (Branch, error) {
+	if id == 0 {
+		return Branch{}, nil
+	}
+	branch := Branch{ID: id}
+	switch err := a.Store.GetBranch(&branch); err {
+	case nil:
+		return branch, nil
+	case sql.ErrNoRows:
+		return Branch{}, NewProblem(http.StatusUnprocessableEntity, "Branch not found")
+	default:
+		return Branch{}, err
+	}
+}
+
+// payloadItem the copy of book bookID a checkout or checkin names, with
+// no ID when it names none
+func (a *App) payloadItem(bookID int, payload circulation) (Item, error) {
+	if payload.ItemID == 0 {
+		return Item{}, nil
+	}
+	item := Item{ID: payload.ItemID, BookID: bookID}
+	switch err := a.Store.GetItem(&item); err {
+	case nil:
+		return item, nil
+	case sql.ErrNoRows:
+		return Item{}, NewProblem(http.StatusUnprocessableEntity, "Item not found")
+	default:
+		return Item{}, err
+	}
+}
+
+// fines the fine schedule in force
+func (a *App) fines() FineSchedule {
+	if a.Fines == nil {
+		return DefaultFineSchedule
+	}
+
+	return a.Fines
+}
+
+// Checkin takes a copy of a book back, closing its loan and charging any
+// fine. The payload names the copy, which may be left out while only one
+// is out.
+func (a *App) Checkin(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid book ID")
+		return
+	}
+
+	payload, err := readCirculation(r, true)
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+	item, err := a.payloadItem(id, payload)
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	b := Book{ID: id}
+	loan, err := a.Store.Checkin(&b, &item, a.fines())
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Book not found")
+		case ErrWhichCopy:
+			RespondWithProblem(w, r, ValidationErrors{{Field: "itemId", Code: CodeRequired, Message: "itemId is required while several copies are out"}})
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, loan)
+}
+
+// GetLoans a book's loans, the latest first
+func (a *App) GetLoans(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid book ID")
+		return
+	}
+
+	branchID, err := ParseBranch(r.FormValue("branch_id"))
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	loans, err := a.Store.GetLoans(&Book{ID: id}, branchID)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Book not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, loans)
+}
+
+// holdIDs reads the book and hold ids of a hold route
+func holdIDs(r *http.Request) (int, int, error) {
+	params := mux.Vars(r)
+	bookID, err := strconv.Atoi(params["id"])
+	if err != nil {
+		return 0, 0, NewProblem(http.StatusBadRequest, "Invalid book ID")
+	}
+
+	id, err := strconv.Atoi(params["hold_id"])
+	if err != nil {
+		return 0, 0, NewProblem(http.StatusBadRequest, "Invalid hold ID")
+	}
+
+	return bookID, id, nil
+}
+
+// PlaceHold puts the patron in the payload in line for a book that is out,
+// to be picked up at the payload's pickupBranchId or at any branch
+func (a *App) PlaceHold(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid book ID")
+		return
+	}
+
+	payload, err := readCirculation(r, false)
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+	patron, err := a.payloadPatron(payload)
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+	branch, err := a.branch(payload.PickupBranchID)
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	pickup := a.PickupPeriod
+	if pickup <= 0 {
+		pickup = DefaultPickupPeriod
+	}
+
+	hold, err := a.Store.PlaceHold(&Book{ID: id}, &patron, &branch, pickup)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Book not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	RespondWithJSON(w, http.StatusCreated, hold)
+}
+
+// GetHolds a book's queue, the hold it is waiting on the shelf for first
+func (a *App) GetHolds(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid book ID")
+		return
+	}
+
+	branchID, err := ParseBranch(r.FormValue("branch_id"))
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	holds, err := a.Store.GetHolds(&Book{ID: id}, branchID)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Book not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, holds)
+}
+
+// GetHold a single hold with its position in the queue
+func (a *App) GetHold(w http.ResponseWriter, r *http.Request) {
+	bookID, id, err := holdIDs(r)
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	h := Hold{ID: id, BookID: bookID}
+	if err := a.Store.GetHold(&h); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Hold not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, h)
+}
+
+// CancelHold take a hold out of its queue
+func (a *App) CancelHold(w http.ResponseWriter, r *http.Request) {
+	bookID, id, err := holdIDs(r)
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	h := Hold{ID: id, BookID: bookID}
+	if err := a.Store.CancelHold(&h); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Hold not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, h)
+}
+
+// Search ranked books, authors and publishers matching q
+func (a *App) Search(w http.ResponseWriter, r *http.Request) {
+	text := strings.TrimSpace(r.FormValue("q"))
+	if text == "" {
+		RespondWithError(w, r, http.StatusBadRequest, "q is required")
+		return
+	}
+
+	types, err := ParseSearchTypes(r.FormValue("type"))
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	opts, err := a.listOptions(r, nil, nil)
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if opts.Cursor != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Search results are paged with start, not cursor")
+		return
+	}
+	if opts.Facets, err = ParseFacets(r.FormValue("facets")); err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	results, total, facets, err := a.Store.Search(text, types, opts)
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	RespondWithPage(w, r, results, opts, total, "", facets)
+}
+
+// Suggest titles, authors and publishers starting with q, up to count of
+// them
+func (a *App) Suggest(w http.ResponseWriter, r *http.Request) {
+	prefix := strings.TrimSpace(r.FormValue("q"))
+	if prefix == "" {
+		RespondWithError(w, r, http.StatusBadRequest, "q is required")
+		return
+	}
+
+	types, err := ParseSuggestTypes(r.FormValue("type"))
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	max := a.MaxPageSize
+	if max < 1 {
+		max = DefaultMaxPageSize
+	}
+	count, _ := strconv.Atoi(r.FormValue("count"))
+	if count > max || count < 1 {
+		count = max
+	}
+
+	suggestions, err := a.Store.Suggest(prefix, types, count)
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, suggestions)
+}
+
+// GetAuthor return a single author
+func (a *App) GetAuthor(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid author ID")
+		return
+	}
+	fields, err := ParseFields(r.FormValue("fields"), authorSchema)
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	author := Author{ID: id}
+	if err := a.Store.GetAuthor(&author, fields.Columns(nil, authorFields)); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Author not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	if NotModifiedTag(w, r, VariantTag(author.Version, fields.String())) {
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, fields.Select(author))
+}
+
+// GetAuthors all authors
+func (a *App) GetAuthors(w http.ResponseWriter, r *http.Request) {
+	opts, err := a.listOptions(r, authorSortColumns, authorFilters)
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	fields, err := ParseFields(r.FormValue("fields"), authorSchema)
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	opts.Columns = fields.Columns(opts.Sort, authorFields)
+
+	authors, total, err := a.Store.GetAuthors(opts.lookahead())
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	next := ""
+	if len(authors) > opts.Count {
+		authors = authors[:opts.Count]
+		if opts.Match == nil {
+			next = EncodeCursor(opts.Sort, authors[len(authors)-1])
+		}
+	}
+
+	RespondWithPage(w, r, fields.Select(authors), opts, total, next, nil)
+}
+
+// CreateAuthor a new author
+func (a *App) CreateAuthor(w http.ResponseWriter, r *http.Request) {
+	var author Author
+	decoder := json.NewDecoder(r.Body)
+
+	if err := decoder.Decode(&author); err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if errs := author.Validate(); len(errs) > 0 {
+		RespondWithProblem(w, r, errs)
+		return
+	}
+
+	if err := a.Store.CreateAuthor(&author); err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", ETag(author.Version))
+	RespondWithJSON(w, http.StatusCreated, author)
+}
+
+// UpdateAuthor update author attributes
+func (a *App) UpdateAuthor(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid author ID")
+		return
+	}
+
+	version, err := IfMatchVersion(r, a.authorVersion(id))
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	var author Author
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&author); err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid resquest payload")
+		return
+	}
+	defer r.Body.Close()
+	author.ID = id
+	author.Version = version
+
+	if errs := author.Validate(); len(errs) > 0 {
+		RespondWithProblem(w, r, errs)
+		return
+	}
+
+	if err := a.Store.UpdateAuthor(&author); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Author not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("ETag", ETag(author.Version))
+	RespondWithJSON(w, http.StatusOK, author)
+}
+
+// PatchAuthor apply a merge patch or JSON patch to a author
+func (a *App) PatchAuthor(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid author ID")
+		return
+	}
+
+	version, err := IfMatchVersion(r, a.authorVersion(id))
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	current := Author{ID: id}
+	if err := a.Store.GetAuthor(&current, nil); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Author not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	if version != 0 && version != current.Version {
+		RespondWithProblem(w, r, ErrVersionMismatch)
+		return
+	}
+
+	var patched Author
+	columns, err := applyPatch(r, current, &patched, authorFields)
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+	defer r.Body.Close()
+	patched.ID = id
+	patched.Version = current.Version
+
+	if errs := patched.Validate(); len(errs) > 0 {
+		RespondWithProblem(w, r, errs)
+		return
+	}
+
+	if err := a.Store.PatchAuthor(&patched, columns); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Author not found")
+		case ErrVersionMismatch:
+			if version == 0 {
+				RespondWithError(w, r, http.StatusConflict, "Author was modified while being patched, retry the request")
+				return
+			}
+			RespondWithProblem(w, r, err)
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("ETag", ETag(patched.Version))
+	RespondWithJSON(w, http.StatusOK, patched)
+}
+
+// DeleteAuthor remove author
+func (a *App) DeleteAuthor(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid author ID")
+		return
+	}
+
+	version, err := IfMatchVersion(r, a.authorVersion(id))
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	author := Author{ID: id, Version: version}
+	cascade, err := ParseCascade(r.FormValue("cascade"))
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := a.Store.DeleteAuthor(&author, cascade); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Author not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+// GetPublisher a single publisher
+func (a *App) GetPublisher(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid publisher ID")
+		return
+	}
+	fields, err := ParseFields(r.FormValue("fields"), publisherSchema)
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	p := Publisher{ID: id}
+	if err := a.Store.GetPublisher(&p, fields.Columns(nil, publisherFields)); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Publisher not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	if NotModifiedTag(w, r, VariantTag(p.Version, fields.String())) {
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, fields.Select(p))
+}
+
+// GetPublishers all publishers
+func (a *App) GetPublishers(w http.ResponseWriter, r *http.Request) {
+	opts, err := a.listOptions(r, publisherSortColumns, publisherFilters)
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	fields, err := ParseFields(r.FormValue("fields"), publisherSchema)
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	opts.Columns = fields.Columns(opts.Sort, publisherFields)
+
+	publishers, total, err := a.Store.GetPublishers(opts.lookahead())
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	next := ""
+	if len(publishers) > opts.Count {
+		publishers = publishers[:opts.Count]
+		if opts.Match == nil {
+			next = EncodeCursor(opts.Sort, publishers[len(publishers)-1])
+		}
+	}
+
+	RespondWithPage(w, r, fields.Select(publishers), opts, total, next, nil)
+}
+
+// CreatePublisher a new publisher
+func (a *App) CreatePublisher(w http.ResponseWriter, r *http.Request) {
+	var publisher Publisher
+	decoder := json.NewDecoder(r.Body)
+
+	if err := decoder.Decode(&publisher); err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if errs := publisher.Validate(); len(errs) > 0 {
+		RespondWithProblem(w, r, errs)
+		return
+	}
+
+	if err := a.Store.CreatePublisher(&publisher); err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", ETag(publisher.Version))
+	RespondWithJSON(w, http.StatusCreated, publisher)
+}
+
+// UpdatePublisher update publisher attributes
+func (a *App) UpdatePublisher(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid publisher ID")
+		return
+	}
+
+	version, err := IfMatchVersion(r, a.publisherVersion(id))
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	var publisher Publisher
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&publisher); err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid resquest payload")
+		return
+	}
+	defer r.Body.Close()
+	publisher.ID = id
+	publisher.Version = version
+
+	if errs := publisher.Validate(); len(errs) > 0 {
+		RespondWithProblem(w, r, errs)
+		return
+	}
+
+	if err := a.Store.UpdatePublisher(&publisher); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Publisher not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("ETag", ETag(publisher.Version))
+	RespondWithJSON(w, http.StatusOK, publisher)
+}
+
+// PatchPublisher apply a merge patch or JSON patch to a publisher
+func (a *App) PatchPublisher(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid publisher ID")
+		return
+	}
+
+	version, err := IfMatchVersion(r, a.publisherVersion(id))
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	current := Publisher{ID: id}
+	if err := a.Store.GetPublisher(&current, nil); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Publisher not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	if version != 0 && version != current.Version {
+		RespondWithProblem(w, r, ErrVersionMismatch)
+		return
+	}
+
+	var patched Publisher
+	columns, err := applyPatch(r, current, &patched, publisherFields)
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+	defer r.Body.Close()
+	patched.ID = id
+	patched.Version = current.Version
+
+	if errs := patched.Validate(); len(errs) > 0 {
+		RespondWithProblem(w, r, errs)
+		return
+	}
+
+	if err := a.Store.PatchPublisher(&patched, columns); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Publisher not found")
+		case ErrVersionMismatch:
+			if version == 0 {
+				RespondWithError(w, r, http.StatusConflict, "Publisher was modified while being patched, retry the request")
+				return
+			}
+			RespondWithProblem(w, r, err)
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("ETag", ETag(patched.Version))
+	RespondWithJSON(w, http.StatusOK, patched)
+}
+
+// DeletePublisher remove publisher
+func (a *App) DeletePublisher(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid publisher ID")
+		return
+	}
+
+	version, err := IfMatchVersion(r, a.publisherVersion(id))
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	publisher := Publisher{ID: id, Version: version}
+	cascade, err := ParseCascade(r.FormValue("cascade"))
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := a.Store.DeletePublisher(&publisher, cascade); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Publisher not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+// GetPatron a single patron
+func (a *App) GetPatron(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid patron ID")
+		return
+	}
+
+	p := Patron{ID: id}
+	if err := a.Store.GetPatron(&p); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Patron not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	if NotModified(w, r, p.Version) {
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, p)
+}
+
+// GetPatrons all patrons
+func (a *App) GetPatrons(w http.ResponseWriter, r *http.Request) {
+	opts, err := a.listOptions(r, patronSortColumns, patronFilters)
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if opts.Match != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Patrons can't be listed by match, filter by card_number instead")
+		return
+	}
+
+	patrons, total, err := a.Store.GetPatrons(opts.lookahead())
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	next := ""
+	if len(patrons) > opts.Count {
+		patrons = patrons[:opts.Count]
+		next = EncodeCursor(opts.Sort, patrons[len(patrons)-1])
+	}
+
+	RespondWithPage(w, r, patrons, opts, total, next, nil)
+}
+
+// CreatePatron a new patron, active for a year with the default borrowing
+// limit unless the payload says otherwise
+func (a *App) CreatePatron(w http.ResponseWriter, r *http.Request) {
+	var p Patron
+	decoder := json.NewDecoder(r.Body)
+
+	if err := decoder.Decode(&p); err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+	p.Defaults(time.Now())
+
+	if errs := p.Validate(); len(errs) > 0 {
+		RespondWithProblem(w, r, errs)
+		return
+	}
+
+	if err := a.Store.CreatePatron(&p); err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", ETag(p.Version))
+	RespondWithJSON(w, http.StatusCreated, p)
+}
+
+// UpdatePatron update patron attributes
+func (a *App) UpdatePatron(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid patron ID")
+		return
+	}
+
+	version, err := IfMatchVersion(r, a.patronVersion(id))
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	var p Patron
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&p); err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+	p.ID = id
+	p.Version = version
+
+	if errs := p.Validate(); len(errs) > 0 {
+		RespondWithProblem(w, r, errs)
+		return
+	}
+
+	if err := a.Store.UpdatePatron(&p); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Patron not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("ETag", ETag(p.Version))
+	RespondWithJSON(w, http.StatusOK, p)
+}
+
+// PatchPatron apply a merge patch or JSON patch to a patron
+func (a *App) PatchPatron(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid patron ID")
+		return
+	}
+
+	version, err := IfMatchVersion(r, a.patronVersion(id))
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	current := Patron{ID: id}
+	if err := a.Store.GetPatron(&current); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Patron not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	if version != 0 && version != current.Version {
+		RespondWithProblem(w, r, ErrVersionMismatch)
+		return
+	}
+
+	var patched Patron
+	columns, err := applyPatch(r, current, &patched, patronFields)
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+	defer r.Body.Close()
+	patched.ID = id
+	patched.Version = current.Version
+
+	if errs := patched.Validate(); len(errs) > 0 {
+		RespondWithProblem(w, r, errs)
+		return
+	}
+
+	if err := a.Store.PatchPatron(&patched, columns); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Patron not found")
+		case ErrVersionMismatch:
+			if version == 0 {
+				RespondWithError(w, r, http.StatusConflict, "Patron was modified while being patched, retry the request")
+				return
+			}
+			RespondWithProblem(w, r, err)
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("ETag", ETag(patched.Version))
+	RespondWithJSON(w, http.StatusOK, patched)
+}
+
+// DeletePatron remove a patron with nothing checked out
+func (a *App) DeletePatron(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid patron ID")
+		return
+	}
+
+	version, err := IfMatchVersion(r, a.patronVersion(id))
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	if err := a.Store.DeletePatron(&Patron{ID: id, Version: version}); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Patron not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+// GetPatronLoans a patron's loans, the latest first
+func (a *App) GetPatronLoans(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid patron ID")
+		return
+	}
+
+	branchID, err := ParseBranch(r.FormValue("branch_id"))
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	loans, err := a.Store.GetPatronLoans(&Patron{ID: id}, branchID)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Patron not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, loans)
+}
+
+// GetPatronHolds a patron's holds, the latest first
+func (a *App) GetPatronHolds(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid patron ID")
+		return
+	}
+
+	branchID, err := ParseBranch(r.FormValue("branch_id"))
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	holds, err := a.Store.GetPatronHolds(&Patron{ID: id}, branchID)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Patron not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, holds)
+}
+
+// GetAccount a patron's ledger and balance, along with the fines their
+// overdue loans are running up
+func (a *App) GetAccount(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid patron ID")
+		return
+	}
+
+	branchID, err := ParseBranch(r.FormValue("branch_id"))
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	p := Patron{ID: id}
+	account, err := a.Store.GetAccount(&p, branchID)
+	if err == nil {
+		var loans []Loan
+		if loans, err = a.Store.GetPatronLoans(&p, branchID); err == nil {
+			account.Overdue = a.fines().Overdue(loans, time.Now())
+		}
+	}
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Patron not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	for _, o := range account.Overdue {
+		account.Accruing += o.Fine
+	}
+
+	RespondWithJSON(w, http.StatusOK, account)
+}
+
+// AddPayment record a payment towards a patron's balance
+func (a *App) AddPayment(w http.ResponseWriter, r *http.Request) {
+	a.settle(w, r, EntryPayment)
+}
+
+// AddWaiver forgive part of a patron's balance
+func (a *App) AddWaiver(w http.ResponseWriter, r *http.Request) {
+	a.settle(w, r, EntryWaiver)
+}
+
+// settle adds an entry of kind settling part of a patron's balance
+func (a *App) settle(w http.ResponseWriter, r *http.Request, kind EntryKind) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid patron ID")
+		return
+	}
+
+	var e AccountEntry
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&e); err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+	e.ID, e.PatronID, e.Kind, e.LoanID = 0, id, kind, nil
+
+	if errs := e.Validate(); len(errs) > 0 {
+		RespondWithProblem(w, r, errs)
+		return
+	}
+
+	if err := a.Store.AddEntry(&e); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Patron not found")
+		case ErrOverpayment:
+			RespondWithProblem(w, r, ValidationErrors{{Field: "amount", Code: CodeOutOfRange, Message: "amount can't be more than the patron owes"}})
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	RespondWithJSON(w, http.StatusCreated, e)
+}
+
+// GetBranch a single branch
+func (a *App) GetBranch(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid branch ID")
+		return
+	}
+
+	b := Branch{ID: id}
+	if err := a.Store.GetBranch(&b); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Branch not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	if NotModified(w, r, b.Version) {
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, b)
+}
+
+// GetBranches all branches
+func (a *App) GetBranches(w http.ResponseWriter, r *http.Request) {
+	opts, err := a.listOptions(r, branchSortColumns, branchFilters)
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if opts.Match != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Branches can't be listed by match, filter by code instead")
+		return
+	}
+
+	branches, total, err := a.Store.GetBranches(opts.lookahead())
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	next := ""
+	if len(branches) > opts.Count {
+		branches = branches[:opts.Count]
+		next = EncodeCursor(opts.Sort, branches[len(branches)-1])
+	}
+
+	RespondWithPage(w, r, branches, opts, total, next, nil)
+}
+
+// CreateBranch a new branch
+func (a *App) CreateBranch(w http.ResponseWriter, r *http.Request) {
+	var b Branch
+	decoder := json.NewDecoder(r.Body)
+
+	if err := decoder.Decode(&b); err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if errs := b.Validate(); len(errs) > 0 {
+		RespondWithProblem(w, r, errs)
+		return
+	}
+
+	if err := a.Store.CreateBranch(&b); err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", ETag(b.Version))
+	RespondWithJSON(w, http.StatusCreated, b)
+}
+
+// UpdateBranch update branch attributes
+func (a *App) UpdateBranch(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid branch ID")
+		return
+	}
+
+	version, err := IfMatchVersion(r, a.branchVersion(id))
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	var b Branch
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&b); err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+	b.ID = id
+	b.Version = version
+
+	if errs := b.Validate(); len(errs) > 0 {
+		RespondWithProblem(w, r, errs)
+		return
+	}
+
+	if err := a.Store.UpdateBranch(&b); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Branch not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("ETag", ETag(b.Version))
+	RespondWithJSON(w, http.StatusOK, b)
+}
+
+// DeleteBranch remove a branch nothing is shelved at or bound for
+func (a *App) DeleteBranch(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid branch ID")
+		return
+	}
+
+	version, err := IfMatchVersion(r, a.branchVersion(id))
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	if err := a.Store.DeleteBranch(&Branch{ID: id, Version: version}); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Branch not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+// transferRequest the payload asking for a copy to be sent to a branch,
+// such as {"itemId": n, "toBranchId": n, "note": "..."}
+type transferRequest struct {
+	ItemID     int    `json:"itemId"`
+	ToBranchID int    `json:"toBranchId"`
+	Note       string `json:"note"`
+}
+
+// transferStep the optional payload of a step in a transfer, such as
+// {"note": "..."}
+type transferStep struct {
+	Note string `json:"note"`
+}
+
+// GetTransfers all transfers, or those from or to branch_id
+func (a *App) GetTransfers(w http.ResponseWriter, r *http.Request) {
+	opts, err := a.listOptions(r, transferSortColumns, transferFilters)
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if opts.Match != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Transfers can't be listed by match")
+		return
+	}
+	if opts.Branch, err = ParseBranch(r.FormValue("branch_id")); err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	transfers, total, err := a.Store.GetTransfers(opts.lookahead())
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	next := ""
+	if len(transfers) > opts.Count {
+		transfers = transfers[:opts.Count]
+		next = EncodeCursor(opts.Sort, transfers[len(transfers)-1])
+	}
+
+	RespondWithPage(w, r, transfers, opts, total, next, nil)
+}
+
+// GetTransfer a single transfer along with its trail
+func (a *App) GetTransfer(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid transfer ID")
+		return
+	}
+
+	t := Transfer{ID: id}
+	if err := a.Store.GetTransfer(&t); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Transfer not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	if NotModified(w, r, t.Version) {
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, t)
+}
+
+// RequestTransfer ask for the copy in the payload to be sent from the
+// branch it is at to another
+func (a *App) RequestTransfer(w http.ResponseWriter, r *http.Request) {
+	var payload transferRequest
+	decoder := json.NewDecoder(r.Body)
+
+	if err := decoder.Decode(&payload); err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if errs := payload.Validate(); len(errs) > 0 {
+		RespondWithProblem(w, r, errs)
+		return
+	}
+	if _, err := a.branch(payload.ToBranchID); err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	t := Transfer{ItemID: payload.ItemID, ToBranchID: payload.ToBranchID}
+	if err := a.Store.RequestTransfer(&t, payload.Note); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusUnprocessableEntity, "Item not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("ETag", ETag(t.Version))
+	RespondWithJSON(w, http.StatusCreated, t)
+}
+
+// DispatchTransfer send a requested copy on its way
+func (a *App) DispatchTransfer(w http.ResponseWriter, r *http.Request) {
+	a.moveTransfer(w, r, TransferInTransit)
+}
+
+// ReceiveTransfer shelve a copy at the branch it was sent to
+func (a *App) ReceiveTransfer(w http.ResponseWriter, r *http.Request) {
+	a.moveTransfer(w, r, TransferReceived)
+}
+
+// CancelTransfer call off a transfer before the copy is sent
+func (a *App) CancelTransfer(w http.ResponseWriter, r *http.Request) {
+	a.moveTransfer(w, r, TransferCancelled)
+}
+
+// moveTransfer moves a transfer on to status, noting the payload's note
+// on its trail
+func (a *App) moveTransfer(w http.ResponseWriter, r *http.Request, status TransferStatus) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid transfer ID")
+		return
+	}
+
+	version, err := IfMatchVersion(r, a.transferVersion(id))
+	if err != nil {
+		RespondWithProblem(w, r, err)
+		return
+	}
+
+	if r.Body == nil {
+		r.Body = http.NoBody
+	}
+	var step transferStep
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&step); err != nil && err != io.EOF {
+		RespondWithError(w, r, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if errs := step.Validate(); len(errs) > 0 {
+		RespondWithProblem(w, r, errs)
+		return
+	}
+
+	t := Transfer{ID: id, Version: version}
+	if err := a.Store.MoveTransfer(&t, status, step.Note); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RespondWithError(w, r, http.StatusNotFound, "Transfer not found")
+		default:
+			RespondWithProblem(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("ETag", ETag(t.Version))
+	RespondWithJSON(w, http.StatusOK, t)
 }
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"database/sql"
+
 	"github.com/jmoiron/sqlx"
 )
 
@@ -10,44 +12,121 @@ type Author struct {
 	FirstName string `json:"firstName,omitempty" db:"first_name"`
 	LastName  string `json:"lastName,omitempty" db:"last_name"`
 	PenName   string `json:"penName,omitempty" db:"pen_name"`
+	Version   int    `json:"-"`
+}
+
+const authorColumns = "id, first_name, last_name, pen_name, version"
+
+// authorFields json fields a patch may change and the columns behind them
+var authorFields = map[string]string{
+	"firstName": "first_name",
+	"lastName":  "last_name",
+	"penName":   "pen_name",
+}
+
+// authorSortColumns columns authors can be sorted and paged by
+var authorSortColumns = []string{"id", "first_name", "last_name", "pen_name"}
+
+// SortKey value of a sortable column
+func (b Author) SortKey(column string) interface{} {
+	switch column {
+	case "id":
+		return b.ID
+	case "first_name":
+		return b.FirstName
+	case "last_name":
+		return b.LastName
+	case "pen_name":
+		return b.PenName
+	}
+
+	return nil
 }
 
-// GetAuthor return author based on id
-func (b *Author) GetAuthor(db *sqlx.DB) error {
+// GetAuthor return author based on id, reading only columns of it unless
+// they are nil
+func (b *Author) GetAuthor(db *sqlx.DB, columns []string) error {
 	author := Author{}
-	err := db.Get(&author, "SELECT * FROM authors WHERE id=$1", b.ID)
+	err := db.Get(&author, "SELECT "+projection(authorColumns, columns)+" FROM authors WHERE id=$1", b.ID)
+	if err != nil {
+		return err
+	}
 
-	return err
+	*b = author
+	return nil
 }
 
 // UpdateAuthor update author based on id
 func (b *Author) UpdateAuthor(db *sqlx.DB) error {
-	_, err := db.Exec("UPDATE authors set first_name=$1, last_name=$2, pen_name=$3 WHERE id=$4", b.FirstName, b.LastName, b.PenName, b.ID)
+	author := Author{}
+	err := db.Get(&author, "UPDATE authors set first_name=$1, last_name=$2, pen_name=$3, version=version+1 WHERE id=$4 AND ($5=0 OR version=$5) RETURNING "+authorColumns,
+		b.FirstName, b.LastName, b.PenName, b.ID, b.Version)
+	if err == sql.ErrNoRows {
+		return missingOrStale(db, "authors", b.ID)
+	}
+	if err != nil {
+		return err
+	}
 
-	return err
+	*b = author
+	return nil
 }
 
-// DeleteAuthor remove author based on id
-func (b *Author) DeleteAuthor(db *sqlx.DB) error {
-	_, err := db.Exec("DELETE FROM authors WHERE id=$1", b.ID)
+// PatchAuthor update only the given columns of an author
+func (b *Author) PatchAuthor(db *sqlx.DB, columns []string) error {
+	author := Author{}
+	if err := patchRow(db, &author, "authors", authorColumns, b.ID, b.Version, columns, b); err != nil {
+		return err
+	}
+
+	*b = author
+	return nil
+}
 
-	return err
+// DeleteAuthor remove author based on id
+func (b *Author) DeleteAuthor(db *sqlx.DB, cascade Cascade) error {
+	return deleteReferenced(db, "authors", "author_id", "author_id=$1 OR id IN (SELECT book_id FROM book_contributors WHERE author_id=$1)", b.ID, b.Version, cascade)
 }
 
 // CreateAuthor new author
 func (b *Author) CreateAuthor(db *sqlx.DB) error {
-	_, err := db.Exec("INSERT INTO authors (first_name, last_name, pen_name) VALUES ($1, $2, $3)", b.FirstName, b.LastName, b.PenName)
-	return err
+	author := Author{}
+	err := db.Get(&author, "INSERT INTO authors (first_name, last_name, pen_name) VALUES ($1, $2, $3) RETURNING "+authorColumns, b.FirstName, b.LastName, b.PenName)
+	if err != nil {
+		return err
+	}
+
+	*b = author
+	return nil
 }
 
-// GetAuthors return all authors
-func GetAuthors(db *sqlx.DB, start, count int) ([]Author, error) {
+// GetAuthors return a page of authors and the total count
+func GetAuthors(db *sqlx.DB, opts ListOptions) ([]Author, int, error) {
+	columns := projection(authorColumns, opts.Columns)
+
+	if opts.Match != nil {
+		authors := []Author{}
+		total, err := authorMatcher.List(db, &authors, columns, "authors", opts)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		return authors, total, nil
+	}
+
+	var total int
+	count, countArgs := countQuery("authors", opts)
+	if err := db.Get(&total, count, countArgs...); err != nil {
+		return nil, 0, err
+	}
+
 	authors := []Author{}
-	err := db.Select(&authors, "SELECT id, first_name, last_name, pen_name FROM authors")
+	query, args := listQuery(columns, "authors", opts)
+	err := db.Select(&authors, query, args...)
 
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
-	return authors, nil
+	return authors, total, nil
 }
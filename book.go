@@ -1,30 +1,191 @@
 package main
 
 import (
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
 )
 
-// Book model
+// Book model, a title of which the library has Copies, Available of them
+// on the shelf
 type Book struct {
-	ID            int        `json:"id,omitempty"`
-	Title         string     `json:"title,omitempty"`
-	PublishedDate time.Time  `json:"publishedDate,omitempty" db:"published_date"`
-	Rating        Rating     `json:"rating,omitempty"`
-	Status        Status     `json:"bookAvailable,omitempty"`
-	Publisher     *Publisher `json:"publisher,omitempty" db:"publisher_id"`
-	Author        *Author    `json:"author,omitempty" db:"author_id"`
+	ID            int           `json:"id,omitempty"`
+	Title         string        `json:"title,omitempty"`
+	PublishedDate time.Time     `json:"publishedDate,omitempty" db:"published_date"`
+	Rating        Rating        `json:"rating,omitempty"`
+	Copies        int           `json:"copies"`
+	Available     int           `json:"available"`
+	PublisherID   *int          `json:"publisherId,omitempty" db:"publisher_id"`
+	AuthorID      *int          `json:"authorId,omitempty" db:"author_id"`
+	Publisher     *Publisher    `json:"publisher,omitempty" db:"-"`
+	Author        *Author       `json:"author,omitempty" db:"-"`
+	Contributors  []Contributor `json:"contributors,omitempty" db:"-"`
+	Version       int           `json:"-"`
 }
 
-// Status checked in or checked out
-type Status int
+// bookFields json fields a patch may change and the columns behind them
+var bookFields = map[string]string{
+	"title":         "title",
+	"publishedDate": "published_date",
+	"rating":        "rating",
+	"authorId":      "author_id",
+	"publisherId":   "publisher_id",
+}
 
-// valid statuses
-const (
-	CheckedOut Status = iota
-	CheckedIn
-)
+// bookDerivedFields json fields that are read but never written and the
+// columns behind them
+var bookDerivedFields = map[string]string{"copies": "copies", "available": "available"}
+
+// bookRelations relations a book can expand
+var bookRelations = []string{"author", "publisher", "contributors"}
+
+const bookColumns = "id, title, published_date, rating, copies, available, author_id, publisher_id, version"
+
+// bookStatus SQL for a book's Status, as its number
+const bookStatus = "(CASE WHEN available > 0 THEN 1 ELSE 0 END)"
+
+// bookContributorIDs SQL for the ids of every author credited on a book
+const bookContributorIDs = "ARRAY(SELECT author_id FROM book_contributors WHERE book_contributors.book_id = books.id)"
+
+// Status CheckedIn while any copy is on the shelf, otherwise CheckedOut
+func (b Book) Status() Status {
+	if b.Available > 0 {
+		return CheckedIn
+	}
+
+	return CheckedOut
+}
+
+// derivedColumn values of the SQL expressions books can be filtered by,
+// the ids of its contributors coming from b.Contributors
+func (b Book) derivedColumn(column string) (interface{}, bool) {
+	switch column {
+	case bookStatus:
+		return int(b.Status()), true
+	case bookContributorIDs:
+		ids := []int{}
+		for _, c := range b.Contributors {
+			ids = append(ids, c.AuthorID)
+		}
+		return ids, true
+	}
+
+	return nil, false
+}
+
+// bookRow a books row along with any joined author and publisher columns
+type bookRow struct {
+	Book
+	AuthorFirstName  *string `db:"author_first_name"`
+	AuthorLastName   *string `db:"author_last_name"`
+	AuthorPenName    *string `db:"author_pen_name"`
+	AuthorVersion    *int    `db:"author_version"`
+	PublisherName    *string `db:"publisher_name"`
+	PublisherVersion *int    `db:"publisher_version"`
+}
+
+// bookRelationColumns foreign key behind each relation a book can expand
+var bookRelationColumns = map[string]string{"author": "author_id", "publisher": "publisher_id"}
+
+// bookSource returns the columns and source to select books from, joining
+// authors and publishers in a single query when they are expanded. base is
+// the books columns to select and table is books or a subquery aliased to
+// it.
+func bookSource(expand Expand, base, table string) (string, string) {
+	if !expand["author"] && !expand["publisher"] {
+		return base, table
+	}
+
+	columns, joined, joins := base, []string{}, ""
+	if expand["author"] {
+		columns += ", author_first_name, author_last_name, author_pen_name, author_version"
+		joined = append(joined, "authors.first_name AS author_first_name, authors.last_name AS author_last_name, authors.pen_name AS author_pen_name, authors.version AS author_version")
+		joins += " LEFT JOIN authors ON authors.id = books.author_id"
+	}
+	if expand["publisher"] {
+		columns += ", publisher_name, publisher_version"
+		joined = append(joined, "publishers.name AS publisher_name, publishers.version AS publisher_version")
+		joins += " LEFT JOIN publishers ON publishers.id = books.publisher_id"
+	}
+
+	source := fmt.Sprintf("(SELECT books.*, %s FROM %s%s) books", strings.Join(joined, ", "), table, joins)
+	return columns, source
+}
+
+// branchBooks the books with copies at a branch, their copies and
+// available counts only counting those, as a source aliased to books
+func branchBooks(branchID int) string {
+	return fmt.Sprintf(`(SELECT books.id, books.title, books.published_date, books.rating, counts.copies, counts.available, books.author_id, books.publisher_id, books.version
+		FROM books JOIN (SELECT book_id, COUNT(*) AS copies, COUNT(*) FILTER (WHERE status = %d) AS available FROM items WHERE branch_id = %d GROUP BY book_id) counts
+		ON counts.book_id = books.id) books`, CheckedIn, branchID)
+}
+
+// book builds the Book, attaching expanded relations
+func (r bookRow) book(expand Expand) Book {
+	b := r.Book
+	if expand["author"] && b.AuthorID != nil {
+		b.Author = &Author{ID: *b.AuthorID, FirstName: deref(r.AuthorFirstName), LastName: deref(r.AuthorLastName), PenName: deref(r.AuthorPenName), Version: derefInt(r.AuthorVersion)}
+	}
+	if expand["publisher"] && b.PublisherID != nil {
+		b.Publisher = &Publisher{ID: *b.PublisherID, Name: deref(r.PublisherName), Version: derefInt(r.PublisherVersion)}
+	}
+
+	return b
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+
+	return *s
+}
+
+func derefInt(n *int) int {
+	if n == nil {
+		return 0
+	}
+
+	return *n
+}
+
+// ETag the book's tag for the representation expand and fields select,
+// which changes with the version of an expanded author or publisher as
+// well as the book's own. Setting contributors bumps the book's version.
+func (b Book) ETag(expand Expand, fields Fields) string {
+	if len(expand) == 0 && fields == nil {
+		return ETag(b.Version)
+	}
+
+	variant := "expand=" + expand.String() + "&fields=" + fields.String()
+	if b.Author != nil {
+		variant += fmt.Sprintf("&author=%d", b.Author.Version)
+	}
+	if b.Publisher != nil {
+		variant += fmt.Sprintf("&publisher=%d", b.Publisher.Version)
+	}
+
+	return VariantTag(b.Version, variant)
+}
+
+// bookSortColumns columns books can be sorted and paged by
+var bookSortColumns = []string{"id", "title", "published_date"}
+
+// SortKey value of a sortable column
+func (b Book) SortKey(column string) interface{} {
+	switch column {
+	case "id":
+		return b.ID
+	case "title":
+		return b.Title
+	case "published_date":
+		return b.PublishedDate
+	}
+
+	return nil
+}
 
 // Rating one to three stars
 type Rating int
@@ -36,56 +197,229 @@ const (
 	ThreeStars
 )
 
-// String interface returns status in english
-func (s Status) String() string {
-	names := [...]string{
-		"CheckedOut",
-		"CheckedIn",
+// GetBook returns a book, reading only columns of it unless they are nil
+func (b *Book) GetBook(db *sqlx.DB, expand Expand, columns []string) error {
+	row := bookRow{}
+	selected, source := bookSource(expand, projection(bookColumns, columns), "books")
+	err := db.Get(&row, "SELECT "+selected+" FROM "+source+" WHERE id=$1", b.ID)
+	if err != nil {
+		return err
 	}
 
-	if s != CheckedOut && s != CheckedIn {
-		return "Unknown"
+	book := row.book(expand)
+	if expand["contributors"] {
+		contributors, err := GetContributors(db, []int{book.ID}, Expand{})
+		if err != nil {
+			return err
+		}
+		book.Contributors = contributorList(contributors[book.ID])
 	}
 
-	return names[s]
+	*b = book
+	return nil
 }
 
-// GetBook returns a book
-func (b *Book) GetBook(db *sqlx.DB) error {
+// UpdateBook updates a book, crediting its author as the primary author
+// among its contributors
+func (b *Book) UpdateBook(db *sqlx.DB) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockBookVersion(tx, b.ID, b.Version); err != nil {
+		return err
+	}
+	authorID, err := creditAuthor(tx, b.ID, b.AuthorID)
+	if err != nil {
+		return err
+	}
+
 	book := Book{}
-	err := db.Get(&book, "SELECT * FROM books WHERE id=$1", b.ID)
+	err = tx.Get(&book, "UPDATE books set title=$1, published_date=$2, rating=$3, author_id=$4, publisher_id=$5, version=version+1 WHERE id=$6 RETURNING "+bookColumns,
+		b.Title, b.PublishedDate, b.Rating, authorID, b.PublisherID, b.ID)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
 
-	return err
+	*b = book
+	return nil
 }
 
-// UpdateBook updates a book
-func (b *Book) UpdateBook(db *sqlx.DB) error {
-	_, err := db.Exec("UPDATE books set title=$1 WHERE id=$2", b.Title, b.ID)
+// PatchBook updates only the given columns of a book, crediting a patched
+// author as the primary author among its contributors
+func (b *Book) PatchBook(db *sqlx.DB, columns []string) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	return err
+	if err := lockBookVersion(tx, b.ID, b.Version); err != nil {
+		return err
+	}
+	for _, column := range columns {
+		if column == "author_id" {
+			if b.AuthorID, err = creditAuthor(tx, b.ID, b.AuthorID); err != nil {
+				return err
+			}
+		}
+	}
+
+	book := Book{}
+	if err := patchRow(tx, &book, "books", bookColumns, b.ID, b.Version, columns, b); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	*b = book
+	return nil
+}
+
+// lockBookVersion locks a book for the rest of tx, a non-zero version must
+// match the stored one
+func lockBookVersion(tx *sqlx.Tx, id, version int) error {
+	var current int
+	if err := tx.Get(&current, "SELECT version FROM books WHERE id=$1 FOR UPDATE", id); err != nil {
+		return err
+	}
+	if version != 0 && version != current {
+		return ErrVersionMismatch
+	}
+
+	return nil
 }
 
-// DeleteBook removes a book
+// DeleteBook removes a book along with its copies and their loans
 func (b *Book) DeleteBook(db *sqlx.DB) error {
-	_, err := db.Exec("DELETE FROM books WHERE id=$1", b.ID)
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockBookVersion(tx, b.ID, b.Version); err != nil {
+		return err
+	}
+	if err := deleteBooks(tx, "id=$1", b.ID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
 
+// deleteBooks removes the books where condition holds for args. Their
+// loans go first since a copy with loans on record can't be deleted on its
+// own.
+func deleteBooks(tx *sqlx.Tx, condition string, args ...interface{}) error {
+	if _, err := tx.Exec("DELETE FROM loans WHERE book_id IN (SELECT id FROM books WHERE "+condition+")", args...); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec("DELETE FROM books WHERE "+condition, args...)
 	return err
 }
 
-// CreateBook inserts a new record
+// CreateBook inserts a new record, crediting its author as the primary
+// author among its contributors
 func (b *Book) CreateBook(db *sqlx.DB) error {
-	_, err := db.Exec("INSERT INTO books (title, published_date) VALUES ($1, $2)", b.Title, b.PublishedDate)
-	return err
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	book := Book{}
+	err = tx.Get(&book, "INSERT INTO books (title, published_date, rating, author_id, publisher_id) VALUES ($1, $2, $3, $4, $5) RETURNING "+bookColumns,
+		b.Title, b.PublishedDate, b.Rating, b.AuthorID, b.PublisherID)
+	if err != nil {
+		return err
+	}
+	if _, err := creditAuthor(tx, book.ID, b.AuthorID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	*b = book
+	return nil
 }
 
-// GetBooks returns all books
-func GetBooks(db *sqlx.DB, start, count int) ([]Book, error) {
-	books := []Book{}
-	err := db.Select(&books, "SELECT id, title FROM books")
+// GetBooks returns a page of books, the total count and any facets in
+// opts.Facets, all read in one transaction. With opts.Branch set only
+// books with copies at that branch are listed, counting only those copies.
+func GetBooks(db *sqlx.DB, opts ListOptions) ([]Book, int, Facets, error) {
+	table, matcher := "books", bookMatcher
+	if opts.Branch != 0 {
+		table = branchBooks(opts.Branch)
+		matcher.Table = table
+	}
+
+	var (
+		tx  *sqlx.Tx
+		err error
+	)
+	if opts.Match != nil {
+		tx, err = matcher.begin(db, opts.Match)
+	} else {
+		tx, err = beginRead(db)
+	}
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	defer tx.Rollback()
+
+	var total int
+	rows := []bookRow{}
+	columns, source := bookSource(opts.Expand, projection(bookColumns, opts.Columns), table)
+
+	var matches string
+	var matchArgs []interface{}
+	if opts.Match != nil {
+		if total, err = matcher.list(tx, &rows, columns, source, opts); err != nil {
+			return nil, 0, nil, err
+		}
+		matches, matchArgs = matcher.filterQuery("*", opts)
+	} else {
+		count, countArgs := countQuery(table, opts)
+		if err := tx.Get(&total, count, countArgs...); err != nil {
+			return nil, 0, nil, err
+		}
+
+		query, args := listQuery(columns, source, opts)
+		if err := tx.Select(&rows, query, args...); err != nil {
+			return nil, 0, nil, err
+		}
+		matches, matchArgs = filterQuery("*", table, opts)
+	}
+
+	books, ids := []Book{}, []int{}
+	for _, row := range rows {
+		books = append(books, row.book(opts.Expand))
+		ids = append(ids, row.ID)
+	}
+
+	if opts.Expand["contributors"] {
+		contributors, err := GetContributors(tx, ids, Expand{})
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		for i := range books {
+			books[i].Contributors = contributorList(contributors[books[i].ID])
+		}
+	}
 
+	facets, err := bookFacetCounts(tx, opts.Facets, matches, matchArgs)
 	if err != nil {
-		return nil, err
+		return nil, 0, nil, err
 	}
 
-	return books, nil
+	return books, total, facets, nil
 }
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrDuplicateBranchCode returned when a code is already another
+	// branch's
+	ErrDuplicateBranchCode = errors.New("branch code is already taken")
+
+	// ErrBranchInUse returned when deleting a branch that still has
+	// copies, holds to be picked up there or transfers from or to it
+	ErrBranchInUse = errors.New("branch still has copies, holds or transfers")
+)
+
+// Branch a library building that copies are shelved at and holds are
+// picked up from, identified by a short code
+type Branch struct {
+	ID      int    `json:"id,omitempty"`
+	Code    string `json:"code,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Address string `json:"address,omitempty"`
+	Version int    `json:"-"`
+}
+
+const branchColumns = "id, code, name, address, version"
+
+// branchSortColumns columns branches can be sorted and paged by
+var branchSortColumns = []string{"id", "code", "name"}
+
+// SortKey value of a sortable column
+func (b Branch) SortKey(column string) interface{} {
+	switch column {
+	case "id":
+		return b.ID
+	case "code":
+		return b.Code
+	case "name":
+		return b.Name
+	}
+
+	return nil
+}
+
+// atBranch SQL condition that the copy whose id is in column is shelved at
+// a branch, always true when branchID is 0
+func atBranch(column string, branchID int) string {
+	if branchID == 0 {
+		return "TRUE"
+	}
+
+	return fmt.Sprintf("%s IN (SELECT id FROM items WHERE branch_id = %d)", column, branchID)
+}
+
+// uniqueBranchCode reports a duplicate code as ErrDuplicateBranchCode
+func uniqueBranchCode(err error) error {
+	if e, ok := err.(*pq.Error); ok && e.Code == pqUniqueViolation {
+		return ErrDuplicateBranchCode
+	}
+
+	return err
+}
+
+// GetBranch returns a branch
+func (b *Branch) GetBranch(db *sqlx.DB) error {
+	branch := Branch{}
+	if err := db.Get(&branch, "SELECT "+branchColumns+" FROM branches WHERE id=$1", b.ID); err != nil {
+		return err
+	}
+
+	*b = branch
+	return nil
+}
+
+// CreateBranch inserts a new branch
+func (b *Branch) CreateBranch(db *sqlx.DB) error {
+	branch := Branch{}
+	err := db.Get(&branch, "INSERT INTO branches (code, name, address) VALUES ($1, $2, $3) RETURNING "+branchColumns,
+		b.Code, b.Name, b.Address)
+	if err != nil {
+		return uniqueBranchCode(err)
+	}
+
+	*b = branch
+	return nil
+}
+
+// UpdateBranch updates a branch
+func (b *Branch) UpdateBranch(db *sqlx.DB) error {
+	branch := Branch{}
+	err := db.Get(&branch, "UPDATE branches SET code=$1, name=$2, address=$3, version=version+1 WHERE id=$4 AND ($5=0 OR version=$5) RETURNING "+branchColumns,
+		b.Code, b.Name, b.Address, b.ID, b.Version)
+	if err == sql.ErrNoRows {
+		return missingOrStale(db, "branches", b.ID)
+	}
+	if err != nil {
+		return uniqueBranchCode(err)
+	}
+
+	*b = branch
+	return nil
+}
+
+// DeleteBranch removes a branch nothing is shelved at or waiting to be
+// picked up at. A branch any transfer names is kept so its trail is.
+func (b *Branch) DeleteBranch(db *sqlx.DB) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var version int
+	if err := tx.Get(&version, "SELECT version FROM branches WHERE id=$1 FOR UPDATE", b.ID); err != nil {
+		return err
+	}
+	if b.Version != 0 && b.Version != version {
+		return ErrVersionMismatch
+	}
+
+	var inUse bool
+	err = tx.Get(&inUse, `SELECT EXISTS (SELECT 1 FROM items WHERE branch_id=$1)
+		OR EXISTS (SELECT 1 FROM holds WHERE pickup_branch_id=$1 AND status IN ('waiting', 'ready'))
+		OR EXISTS (SELECT 1 FROM transfers WHERE from_branch_id=$1 OR to_branch_id=$1)`, b.ID)
+	if err != nil {
+		return err
+	}
+	if inUse {
+		return ErrBranchInUse
+	}
+
+	if _, err := tx.Exec("UPDATE holds SET pickup_branch_id=NULL WHERE pickup_branch_id=$1", b.ID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM branches WHERE id=$1", b.ID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetBranches returns a page of branches and the total count
+func GetBranches(db *sqlx.DB, opts ListOptions) ([]Branch, int, error) {
+	var total int
+	count, countArgs := countQuery("branches", opts)
+	if err := db.Get(&total, count, countArgs...); err != nil {
+		return nil, 0, err
+	}
+
+	branches := []Branch{}
+	query, args := listQuery(branchColumns, "branches", opts)
+	if err := db.Select(&branches, query, args...); err != nil {
+		return nil, 0, err
+	}
+
+	return branches, total, nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Role the part an author played in a book
+type Role string
+
+// valid roles
+const (
+	RoleAuthor      Role = "author"
+	RoleEditor      Role = "editor"
+	RoleTranslator  Role = "translator"
+	RoleIllustrator Role = "illustrator"
+	RoleNarrator    Role = "narrator"
+)
+
+// Roles every valid role
+var Roles = []Role{RoleAuthor, RoleEditor, RoleTranslator, RoleIllustrator, RoleNarrator}
+
+// Contributor an author credited on a book, in the order they are credited
+type Contributor struct {
+	BookID   int     `json:"-" db:"book_id"`
+	AuthorID int     `json:"authorId" db:"author_id"`
+	Role     Role    `json:"role"`
+	Position int     `json:"position"`
+	Author   *Author `json:"author,omitempty" db:"-"`
+}
+
+// contributorRelations relations a contributor can expand
+var contributorRelations = []string{"author"}
+
+const contributorColumns = "book_contributors.book_id, book_contributors.author_id, book_contributors.role, book_contributors.position"
+
+// contributorRow a book_contributors row joined to its author
+type contributorRow struct {
+	Contributor
+	AuthorFirstName *string `db:"author_first_name"`
+	AuthorLastName  *string `db:"author_last_name"`
+	AuthorPenName   *string `db:"author_pen_name"`
+	AuthorVersion   int     `db:"author_version"`
+}
+
+// contributorsTag the tag of a book's contributors, which changes with the
+// version of an expanded author as well as the book's
+func contributorsTag(version int, contributors []Contributor) string {
+	variant := ""
+	for _, c := range contributors {
+		if c.Author != nil {
+			variant += fmt.Sprintf("author=%d:%d&", c.Author.ID, c.Author.Version)
+		}
+	}
+
+	return VariantTag(version, variant)
+}
+
+// primaryAuthor the first contributor credited as an author, which is what
+// books.author_id holds for older clients
+func primaryAuthor(contributors []Contributor) *int {
+	for _, c := range contributors {
+		if c.Role == RoleAuthor {
+			id := c.AuthorID
+			return &id
+		}
+	}
+
+	return nil
+}
+
+// GetContributors returns the contributors of books, keyed by book id, in
+// one query however many books are asked for
+func GetContributors(db sqlx.Queryer, bookIDs []int, expand Expand) (map[int][]Contributor, error) {
+	rows := []contributorRow{}
+	err := sqlx.Select(db, &rows, "SELECT "+contributorColumns+`,
+		authors.first_name AS author_first_name, authors.last_name AS author_last_name,
+		authors.pen_name AS author_pen_name, authors.version AS author_version
+		FROM book_contributors JOIN authors ON authors.id = book_contributors.author_id
+		WHERE book_contributors.book_id = ANY($1) ORDER BY book_contributors.book_id, book_contributors.position`, pq.Array(bookIDs))
+	if err != nil {
+		return nil, err
+	}
+
+	contributors := map[int][]Contributor{}
+	for _, row := range rows {
+		c := row.Contributor
+		if expand["author"] {
+			c.Author = &Author{ID: c.AuthorID, FirstName: deref(row.AuthorFirstName), LastName: deref(row.AuthorLastName), PenName: deref(row.AuthorPenName), Version: row.AuthorVersion}
+		}
+		contributors[c.BookID] = append(contributors[c.BookID], c)
+	}
+
+	return contributors, nil
+}
+
+// SetContributors replaces the contributors of a book and keeps author_id
+// pointing at the primary author. The book is locked for the whole swap and
+// a non-zero version must match the stored one.
+func (b *Book) SetContributors(db *sqlx.DB, contributors []Contributor) ([]Contributor, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := lockBookVersion(tx, b.ID, b.Version); err != nil {
+		return nil, err
+	}
+	if err := replaceContributors(tx, b.ID, contributors); err != nil {
+		return nil, err
+	}
+
+	book := Book{}
+	err = tx.Get(&book, "UPDATE books SET author_id=$1, version=version+1 WHERE id=$2 RETURNING "+bookColumns, primaryAuthor(contributors), b.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := GetContributors(tx, []int{b.ID}, Expand{})
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	*b = book
+	return contributorList(stored[b.ID]), nil
+}
+
+// replaceContributors swaps the contributors of a book for contributors,
+// numbered in the order given. The caller must hold the book's lock.
+func replaceContributors(tx *sqlx.Tx, bookID int, contributors []Contributor) error {
+	if _, err := tx.Exec("DELETE FROM book_contributors WHERE book_id=$1", bookID); err != nil {
+		return err
+	}
+	for i, c := range contributors {
+		_, err := tx.Exec("INSERT INTO book_contributors (book_id, author_id, role, position) VALUES ($1, $2, $3, $4)", bookID, c.AuthorID, c.Role, i+1)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// creditAuthor credits authorID as the primary author of a book, which is
+// what writing its author_id means, and returns the author_id that follows
+// from its contributors. The caller must hold the book's lock.
+func creditAuthor(tx *sqlx.Tx, bookID int, authorID *int) (*int, error) {
+	stored, err := GetContributors(tx, []int{bookID}, Expand{})
+	if err != nil {
+		return nil, err
+	}
+
+	contributors := withPrimaryAuthor(bookID, stored[bookID], authorID)
+	if err := replaceContributors(tx, bookID, contributors); err != nil {
+		return nil, err
+	}
+
+	return primaryAuthor(contributors), nil
+}
+
+// withPrimaryAuthor contributors with authorID in the place of the primary
+// author, or first when there is none, and without the primary author when
+// authorID is nil. Positions are numbered from 1 again.
+func withPrimaryAuthor(bookID int, contributors []Contributor, authorID *int) []Contributor {
+	primary := -1
+	for i, c := range contributors {
+		if c.Role == RoleAuthor {
+			primary = i
+			break
+		}
+	}
+
+	credits := []Contributor{}
+	if primary == -1 && authorID != nil {
+		credits = append(credits, Contributor{BookID: bookID, AuthorID: *authorID, Role: RoleAuthor})
+	}
+	for i, c := range contributors {
+		switch {
+		case i == primary && authorID == nil:
+			continue
+		case i == primary:
+			c.AuthorID = *authorID
+		case authorID != nil && c.AuthorID == *authorID && c.Role == RoleAuthor:
+			// credited further down already, it moves up to primary
+			continue
+		}
+		credits = append(credits, c)
+	}
+	for i := range credits {
+		credits[i].Position = i + 1
+	}
+
+	return credits
+}
+
+// contributorList never returns nil so an empty list encodes as []
+func contributorList(contributors []Contributor) []Contributor {
+	if contributors == nil {
+		return []Contributor{}
+	}
+
+	return contributors
+}
@@ -0,0 +1,2 @@
+ALTER TABLE IF EXISTS books
+DROP COLUMN author_id;
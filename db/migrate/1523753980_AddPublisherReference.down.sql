@@ -0,0 +1,2 @@
+ALTER TABLE IF EXISTS books
+DROP COLUMN publisher_id;
@@ -0,0 +1,3 @@
+ALTER TABLE IF EXISTS books
+DROP COLUMN Rating,
+DROP COLUMN Status;
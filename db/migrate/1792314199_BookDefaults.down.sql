@@ -0,0 +1,8 @@
+ALTER TABLE IF EXISTS books
+ALTER COLUMN rating DROP NOT NULL,
+ALTER COLUMN rating DROP DEFAULT,
+ALTER COLUMN status DROP NOT NULL,
+ALTER COLUMN status DROP DEFAULT,
+ALTER COLUMN title DROP NOT NULL,
+ALTER COLUMN published_date DROP NOT NULL,
+ALTER COLUMN published_date DROP DEFAULT;
@@ -0,0 +1,13 @@
+UPDATE books SET rating = 0 WHERE rating IS NULL;
+UPDATE books SET status = 0 WHERE status IS NULL;
+UPDATE books SET title = '' WHERE title IS NULL;
+UPDATE books SET published_date = '0001-01-01' WHERE published_date IS NULL;
+
+ALTER TABLE IF EXISTS books
+ALTER COLUMN rating SET DEFAULT 0,
+ALTER COLUMN rating SET NOT NULL,
+ALTER COLUMN status SET DEFAULT 0,
+ALTER COLUMN status SET NOT NULL,
+ALTER COLUMN title SET NOT NULL,
+ALTER COLUMN published_date SET DEFAULT '0001-01-01',
+ALTER COLUMN published_date SET NOT NULL;
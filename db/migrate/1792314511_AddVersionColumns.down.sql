@@ -0,0 +1,8 @@
+ALTER TABLE IF EXISTS books
+DROP COLUMN version;
+
+ALTER TABLE IF EXISTS authors
+DROP COLUMN version;
+
+ALTER TABLE IF EXISTS publishers
+DROP COLUMN version;
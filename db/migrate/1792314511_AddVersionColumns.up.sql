@@ -0,0 +1,8 @@
+ALTER TABLE IF EXISTS books
+ADD COLUMN version integer NOT NULL DEFAULT 1;
+
+ALTER TABLE IF EXISTS authors
+ADD COLUMN version integer NOT NULL DEFAULT 1;
+
+ALTER TABLE IF EXISTS publishers
+ADD COLUMN version integer NOT NULL DEFAULT 1;
@@ -0,0 +1,7 @@
+UPDATE books SET author_id = (
+  SELECT author_id FROM book_contributors
+  WHERE book_contributors.book_id = books.id AND role = 'author'
+  ORDER BY position LIMIT 1
+);
+
+DROP TABLE book_contributors;
@@ -0,0 +1,13 @@
+CREATE TABLE book_contributors (
+  book_id integer NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+  author_id integer NOT NULL REFERENCES authors(id) ON DELETE CASCADE,
+  role TEXT NOT NULL CHECK (role IN ('author', 'editor', 'translator', 'illustrator', 'narrator')),
+  position integer NOT NULL,
+  PRIMARY KEY (book_id, position),
+  UNIQUE (book_id, author_id, role)
+);
+
+CREATE INDEX book_contributors_author_id ON book_contributors (author_id);
+
+INSERT INTO book_contributors (book_id, author_id, role, position)
+SELECT id, author_id, 'author', 1 FROM books WHERE author_id IS NOT NULL;
@@ -0,0 +1,13 @@
+CREATE TABLE editions (
+  id SERIAL PRIMARY KEY,
+  book_id integer NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+  isbn13 TEXT NOT NULL UNIQUE CHECK (isbn13 ~ '^97[89][0-9]{10}$'),
+  format TEXT NOT NULL DEFAULT '',
+  page_count integer NOT NULL DEFAULT 0,
+  language TEXT NOT NULL DEFAULT '',
+  publisher_id integer REFERENCES publishers(id) ON DELETE SET NULL,
+  published_date TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT '0001-01-01',
+  version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX editions_book_id ON editions (book_id);
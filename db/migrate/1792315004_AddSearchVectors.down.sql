@@ -0,0 +1,13 @@
+DROP TRIGGER book_contributors_search_refresh ON book_contributors;
+DROP FUNCTION book_contributors_search_refresh();
+DROP TRIGGER publishers_search_refresh ON publishers;
+DROP TRIGGER authors_search_refresh ON authors;
+DROP TRIGGER books_search ON books;
+DROP FUNCTION books_search_refresh();
+DROP FUNCTION books_search_update();
+
+ALTER TABLE books DROP COLUMN search;
+DROP FUNCTION book_search(books);
+
+ALTER TABLE publishers DROP COLUMN search;
+ALTER TABLE authors DROP COLUMN search;
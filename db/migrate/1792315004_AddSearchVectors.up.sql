@@ -0,0 +1,66 @@
+ALTER TABLE authors ADD COLUMN search tsvector GENERATED ALWAYS AS (
+  setweight(to_tsvector('english', coalesce(pen_name, '')), 'A') ||
+  setweight(to_tsvector('english', coalesce(first_name, '') || ' ' || coalesce(last_name, '')), 'A')
+) STORED;
+
+ALTER TABLE publishers ADD COLUMN search tsvector GENERATED ALWAYS AS (
+  setweight(to_tsvector('english', coalesce(name, '')), 'A')
+) STORED;
+
+-- a book is found by its title and by the names of its contributors and
+-- publisher, which live in other tables so are kept up to date with triggers
+ALTER TABLE books ADD COLUMN search tsvector;
+
+CREATE FUNCTION book_search(book books) RETURNS tsvector AS $$
+  SELECT setweight(to_tsvector('english', coalesce(book.title, '')), 'A') ||
+    setweight(to_tsvector('english', coalesce((SELECT string_agg(concat_ws(' ', first_name, last_name, pen_name), ' ')
+      FROM book_contributors JOIN authors ON authors.id = book_contributors.author_id WHERE book_contributors.book_id = book.id), '')), 'B') ||
+    setweight(to_tsvector('english', coalesce((SELECT name FROM publishers WHERE id = book.publisher_id), '')), 'C')
+$$ LANGUAGE sql STABLE;
+
+CREATE FUNCTION books_search_update() RETURNS trigger AS $$
+BEGIN
+  NEW.search := book_search(NEW);
+  RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER books_search BEFORE INSERT OR UPDATE OF title, author_id, publisher_id ON books
+FOR EACH ROW EXECUTE FUNCTION books_search_update();
+
+CREATE FUNCTION books_search_refresh() RETURNS trigger AS $$
+BEGIN
+  IF TG_TABLE_NAME = 'authors' THEN
+    UPDATE books SET search = book_search(books) WHERE id IN (SELECT book_id FROM book_contributors WHERE author_id = NEW.id);
+  ELSE
+    UPDATE books SET search = book_search(books) WHERE publisher_id = NEW.id;
+  END IF;
+  RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER authors_search_refresh AFTER UPDATE OF first_name, last_name, pen_name ON authors
+FOR EACH ROW EXECUTE FUNCTION books_search_refresh();
+
+CREATE TRIGGER publishers_search_refresh AFTER UPDATE OF name ON publishers
+FOR EACH ROW EXECUTE FUNCTION books_search_refresh();
+
+CREATE FUNCTION book_contributors_search_refresh() RETURNS trigger AS $$
+BEGIN
+  IF TG_OP = 'DELETE' THEN
+    UPDATE books SET search = book_search(books) WHERE id = OLD.book_id;
+  ELSE
+    UPDATE books SET search = book_search(books) WHERE id = NEW.book_id;
+  END IF;
+  RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER book_contributors_search_refresh AFTER INSERT OR UPDATE OR DELETE ON book_contributors
+FOR EACH ROW EXECUTE FUNCTION book_contributors_search_refresh();
+
+UPDATE books SET search = book_search(books);
+
+CREATE INDEX books_search ON books USING GIN (search);
+CREATE INDEX authors_search ON authors USING GIN (search);
+CREATE INDEX publishers_search ON publishers USING GIN (search);
@@ -0,0 +1,3 @@
+DROP INDEX publishers_name_trgm;
+DROP INDEX books_title_trgm;
+DROP INDEX authors_name_trgm;
@@ -0,0 +1,7 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX authors_name_trgm ON authors
+USING GIN ((coalesce(first_name, '') || ' ' || coalesce(last_name, '') || ' ' || coalesce(pen_name, '')) gin_trgm_ops);
+
+CREATE INDEX books_title_trgm ON books USING GIN (title gin_trgm_ops);
+CREATE INDEX publishers_name_trgm ON publishers USING GIN (name gin_trgm_ops);
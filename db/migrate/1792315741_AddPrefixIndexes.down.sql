@@ -0,0 +1,6 @@
+DROP INDEX publishers_name_prefix;
+DROP INDEX authors_full_name_prefix;
+DROP INDEX authors_pen_name_prefix;
+DROP INDEX authors_last_name_prefix;
+DROP INDEX authors_first_name_prefix;
+DROP INDEX books_title_prefix;
@@ -0,0 +1,9 @@
+CREATE INDEX books_title_prefix ON books (lower(title) text_pattern_ops);
+
+CREATE INDEX authors_first_name_prefix ON authors (lower(first_name) text_pattern_ops);
+CREATE INDEX authors_last_name_prefix ON authors (lower(last_name) text_pattern_ops);
+CREATE INDEX authors_pen_name_prefix ON authors (lower(pen_name) text_pattern_ops);
+CREATE INDEX authors_full_name_prefix ON authors
+((lower(coalesce(first_name, '') || ' ' || coalesce(last_name, ''))) text_pattern_ops);
+
+CREATE INDEX publishers_name_prefix ON publishers (lower(name) text_pattern_ops);
@@ -0,0 +1,3 @@
+ALTER TABLE books ALTER COLUMN status SET DEFAULT 0;
+
+DROP TABLE loans;
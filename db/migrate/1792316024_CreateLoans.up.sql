@@ -0,0 +1,20 @@
+CREATE TABLE loans (
+  id SERIAL PRIMARY KEY,
+  book_id integer NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+  checked_out_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  due_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  returned_at TIMESTAMP WITH TIME ZONE,
+  CHECK (due_at > checked_out_at),
+  CHECK (returned_at IS NULL OR returned_at >= checked_out_at)
+);
+
+-- a book can only be out once at a time
+CREATE UNIQUE INDEX loans_open_book_id ON loans (book_id) WHERE returned_at IS NULL;
+CREATE INDEX loans_book_id ON loans (book_id, checked_out_at);
+
+-- status was set by hand and defaulted to checked out, without anything
+-- saying who had a book, so every book starts checked in and from here
+-- on follows its loans
+UPDATE books SET status = 1;
+
+ALTER TABLE books ALTER COLUMN status SET DEFAULT 1;
@@ -0,0 +1,5 @@
+DROP INDEX loans_patron_id;
+
+ALTER TABLE loans DROP COLUMN patron_id;
+
+DROP TABLE patrons;
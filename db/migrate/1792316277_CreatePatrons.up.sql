@@ -0,0 +1,16 @@
+CREATE TABLE patrons (
+  id SERIAL PRIMARY KEY,
+  card_number TEXT NOT NULL UNIQUE,
+  name TEXT NOT NULL,
+  email TEXT NOT NULL DEFAULT '',
+  phone TEXT NOT NULL DEFAULT '',
+  status TEXT NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'suspended', 'expired')),
+  expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  borrowing_limit integer NOT NULL DEFAULT 5 CHECK (borrowing_limit >= 0),
+  version integer NOT NULL DEFAULT 1
+);
+
+-- loans made before patrons were recorded stay without one
+ALTER TABLE loans ADD COLUMN patron_id integer REFERENCES patrons(id) ON DELETE SET NULL;
+
+CREATE INDEX loans_patron_id ON loans (patron_id, checked_out_at);
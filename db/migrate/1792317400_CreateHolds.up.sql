@@ -0,0 +1,18 @@
+CREATE TABLE holds (
+  id SERIAL PRIMARY KEY,
+  book_id integer NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+  patron_id integer NOT NULL REFERENCES patrons(id) ON DELETE CASCADE,
+  status TEXT NOT NULL DEFAULT 'waiting' CHECK (status IN ('waiting', 'ready', 'fulfilled', 'cancelled', 'expired')),
+  placed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  ready_at TIMESTAMP WITH TIME ZONE,
+  expires_at TIMESTAMP WITH TIME ZONE,
+  pickup_seconds integer NOT NULL CHECK (pickup_seconds > 0),
+  CHECK (status <> 'ready' OR expires_at IS NOT NULL)
+);
+
+-- a patron is in a book's queue at most once, and a book waits on the
+-- shelf for one patron at a time
+CREATE UNIQUE INDEX holds_active_book_patron ON holds (book_id, patron_id) WHERE status IN ('waiting', 'ready');
+CREATE UNIQUE INDEX holds_ready_book_id ON holds (book_id) WHERE status = 'ready';
+CREATE INDEX holds_queue ON holds (book_id, placed_at, id) WHERE status = 'waiting';
+CREATE INDEX holds_patron_id ON holds (patron_id, placed_at);
@@ -0,0 +1,5 @@
+DROP TABLE account_entries;
+
+DROP FUNCTION account_entries_append_only();
+
+ALTER TABLE loans DROP COLUMN item_type;
@@ -0,0 +1,25 @@
+-- loans so far were all of books
+ALTER TABLE loans ADD COLUMN item_type TEXT NOT NULL DEFAULT 'book';
+
+CREATE TABLE account_entries (
+  id SERIAL PRIMARY KEY,
+  patron_id integer NOT NULL REFERENCES patrons(id) ON DELETE CASCADE,
+  kind TEXT NOT NULL CHECK (kind IN ('charge', 'payment', 'waiver')),
+  amount integer NOT NULL CHECK (amount > 0),
+  -- kept after the loan's book is deleted, so not a foreign key
+  loan_id integer,
+  note TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE INDEX account_entries_patron_id ON account_entries (patron_id, created_at);
+
+-- the ledger is append only, a mistake is put right by another entry
+CREATE FUNCTION account_entries_append_only() RETURNS trigger AS $$
+BEGIN
+  RAISE EXCEPTION 'account entries can not be changed or removed';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER account_entries_append_only BEFORE UPDATE OR DELETE ON account_entries
+  FOR EACH ROW EXECUTE FUNCTION account_entries_append_only();
@@ -0,0 +1,14 @@
+ALTER TABLE books ADD COLUMN status integer NOT NULL DEFAULT 1;
+UPDATE books SET status = CASE WHEN available > 0 THEN 1 ELSE 0 END;
+ALTER TABLE books DROP COLUMN available;
+ALTER TABLE books DROP COLUMN copies;
+
+DROP INDEX holds_ready_item_id;
+ALTER TABLE holds DROP COLUMN item_id;
+CREATE UNIQUE INDEX holds_ready_book_id ON holds (book_id) WHERE status = 'ready';
+
+DROP INDEX loans_open_item_id;
+ALTER TABLE loans DROP COLUMN item_id;
+CREATE UNIQUE INDEX loans_open_book_id ON loans (book_id) WHERE returned_at IS NULL;
+
+DROP TABLE items;
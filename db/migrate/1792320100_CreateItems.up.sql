@@ -0,0 +1,41 @@
+CREATE TABLE items (
+  id SERIAL PRIMARY KEY,
+  book_id integer NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+  barcode TEXT NOT NULL UNIQUE,
+  call_number TEXT NOT NULL DEFAULT '',
+  location TEXT NOT NULL DEFAULT '',
+  condition TEXT NOT NULL DEFAULT 'good' CHECK (condition IN ('new', 'good', 'fair', 'poor', 'damaged')),
+  item_type TEXT NOT NULL DEFAULT 'book',
+  status integer NOT NULL DEFAULT 1,
+  version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX items_book_id ON items (book_id, status);
+
+-- every book so far stood for a single copy of itself
+INSERT INTO items (book_id, barcode, status) SELECT id, 'ITEM-' || id, status FROM books;
+
+-- a copy's loans are its history, so a copy that has been lent is kept
+ALTER TABLE loans ADD COLUMN item_id integer REFERENCES items(id) ON DELETE RESTRICT;
+UPDATE loans SET item_id = items.id FROM items WHERE items.book_id = loans.book_id;
+ALTER TABLE loans ALTER COLUMN item_id SET NOT NULL;
+
+-- a copy, rather than a book, can only be out once at a time
+DROP INDEX loans_open_book_id;
+CREATE UNIQUE INDEX loans_open_item_id ON loans (item_id) WHERE returned_at IS NULL;
+
+-- a ready hold has a copy set aside for it, and a book can have as many
+-- ready holds as it has copies
+ALTER TABLE holds ADD COLUMN item_id integer REFERENCES items(id) ON DELETE SET NULL;
+UPDATE holds SET item_id = items.id FROM items WHERE items.book_id = holds.book_id AND holds.status = 'ready';
+DROP INDEX holds_ready_book_id;
+CREATE UNIQUE INDEX holds_ready_item_id ON holds (item_id) WHERE status = 'ready';
+
+UPDATE items SET status = 2 WHERE id IN (SELECT item_id FROM holds WHERE status = 'ready');
+
+ALTER TABLE books ADD COLUMN copies integer NOT NULL DEFAULT 0;
+ALTER TABLE books ADD COLUMN available integer NOT NULL DEFAULT 0;
+UPDATE books SET copies = counts.copies, available = counts.available
+  FROM (SELECT book_id, COUNT(*) AS copies, COUNT(*) FILTER (WHERE status = 1) AS available FROM items GROUP BY book_id) counts
+  WHERE counts.book_id = books.id;
+ALTER TABLE books DROP COLUMN status;
@@ -0,0 +1,11 @@
+DROP TABLE transfer_events;
+DROP FUNCTION transfer_events_append_only();
+DROP TABLE transfers;
+
+DROP INDEX holds_pickup_branch_id;
+ALTER TABLE holds DROP COLUMN pickup_branch_id;
+
+DROP INDEX items_branch_id;
+ALTER TABLE items DROP COLUMN branch_id;
+
+DROP TABLE branches;
@@ -0,0 +1,57 @@
+CREATE TABLE branches (
+  id SERIAL PRIMARY KEY,
+  code TEXT NOT NULL UNIQUE,
+  name TEXT NOT NULL,
+  address TEXT NOT NULL DEFAULT '',
+  version integer NOT NULL DEFAULT 1
+);
+
+-- every copy so far was at the one library
+INSERT INTO branches (code, name) VALUES ('MAIN', 'Main library');
+
+ALTER TABLE items ADD COLUMN branch_id integer REFERENCES branches(id);
+UPDATE items SET branch_id = (SELECT id FROM branches WHERE code = 'MAIN');
+ALTER TABLE items ALTER COLUMN branch_id SET NOT NULL;
+CREATE INDEX items_branch_id ON items (branch_id, book_id);
+
+-- a hold without a pickup branch takes a copy from any of them
+ALTER TABLE holds ADD COLUMN pickup_branch_id integer REFERENCES branches(id);
+CREATE INDEX holds_pickup_branch_id ON holds (pickup_branch_id) WHERE pickup_branch_id IS NOT NULL;
+
+CREATE TABLE transfers (
+  id SERIAL PRIMARY KEY,
+  item_id integer NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+  book_id integer NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+  from_branch_id integer NOT NULL REFERENCES branches(id),
+  to_branch_id integer NOT NULL REFERENCES branches(id),
+  status TEXT NOT NULL DEFAULT 'requested' CHECK (status IN ('requested', 'in_transit', 'received', 'cancelled')),
+  requested_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  version integer NOT NULL DEFAULT 1,
+  CHECK (from_branch_id <> to_branch_id)
+);
+
+-- a copy is on its way to at most one branch at a time
+CREATE UNIQUE INDEX transfers_open_item_id ON transfers (item_id) WHERE status IN ('requested', 'in_transit');
+CREATE INDEX transfers_from_branch_id ON transfers (from_branch_id, status);
+CREATE INDEX transfers_to_branch_id ON transfers (to_branch_id, status);
+
+CREATE TABLE transfer_events (
+  id SERIAL PRIMARY KEY,
+  transfer_id integer NOT NULL REFERENCES transfers(id) ON DELETE CASCADE,
+  status TEXT NOT NULL CHECK (status IN ('requested', 'in_transit', 'received', 'cancelled')),
+  note TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE INDEX transfer_events_transfer_id ON transfer_events (transfer_id, created_at);
+
+-- the trail is append only, like the account ledger
+CREATE FUNCTION transfer_events_append_only() RETURNS trigger AS $$
+BEGIN
+  RAISE EXCEPTION 'transfer events can not be changed';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER transfer_events_append_only BEFORE UPDATE ON transfer_events
+  FOR EACH ROW EXECUTE PROCEDURE transfer_events_append_only();
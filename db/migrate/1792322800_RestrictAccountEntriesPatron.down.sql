@@ -0,0 +1,3 @@
+ALTER TABLE account_entries DROP CONSTRAINT account_entries_patron_id_fkey;
+ALTER TABLE account_entries ADD CONSTRAINT account_entries_patron_id_fkey
+  FOREIGN KEY (patron_id) REFERENCES patrons(id) ON DELETE CASCADE;
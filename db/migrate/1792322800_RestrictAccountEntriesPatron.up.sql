@@ -0,0 +1,4 @@
+-- a patron's ledger outlives settling it, so a patron with entries is kept
+ALTER TABLE account_entries DROP CONSTRAINT account_entries_patron_id_fkey;
+ALTER TABLE account_entries ADD CONSTRAINT account_entries_patron_id_fkey
+  FOREIGN KEY (patron_id) REFERENCES patrons(id) ON DELETE RESTRICT;
@@ -1,6 +1,8 @@
 CREATE DATABASE book_development;
 CREATE ROLE dev WITH superuser login;
 GRANT ALL PRIVILEGES ON DATABASE book_development TO dev;
+CREATE DATABASE book_test;
+GRANT ALL PRIVILEGES ON DATABASE book_test TO dev;
 
 -- CREATE TABLE books (
 --   id SERIAL PRIMARY KEY,
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Edition a published form of a book, identified by its ISBN
+type Edition struct {
+	ID            int       `json:"id,omitempty"`
+	BookID        int       `json:"bookId,omitempty" db:"book_id"`
+	ISBN13        string    `json:"isbn13,omitempty"`
+	ISBN10        string    `json:"isbn10,omitempty" db:"-"`
+	Format        Format    `json:"format,omitempty"`
+	PageCount     int       `json:"pageCount,omitempty" db:"page_count"`
+	Language      string    `json:"language,omitempty"`
+	PublisherID   *int      `json:"publisherId,omitempty" db:"publisher_id"`
+	PublishedDate time.Time `json:"publishedDate,omitempty" db:"published_date"`
+	Book          *Book     `json:"book,omitempty" db:"-"`
+	Version       int       `json:"-"`
+}
+
+// Format physical or digital form of an edition
+type Format string
+
+// valid formats
+const (
+	FormatHardcover Format = "hardcover"
+	FormatPaperback Format = "paperback"
+	FormatEbook     Format = "ebook"
+	FormatAudiobook Format = "audiobook"
+)
+
+// Formats every valid format
+var Formats = []Format{FormatHardcover, FormatPaperback, FormatEbook, FormatAudiobook}
+
+const editionColumns = "id, book_id, isbn13, format, page_count, language, publisher_id, published_date, version"
+
+// NormalizeISBN stores the ISBN in its 13 digit form, taking it from the
+// ISBN-10 when only that was given
+func (e *Edition) NormalizeISBN() error {
+	isbn := e.ISBN13
+	if isbn == "" {
+		isbn = e.ISBN10
+	}
+
+	isbn13, err := NormalizeISBN(isbn)
+	if err != nil {
+		return err
+	}
+
+	e.ISBN13, e.ISBN10 = isbn13, ISBN10(isbn13)
+	return nil
+}
+
+// withISBN10 fills in the ISBN-10, which is not stored
+func (e Edition) withISBN10() Edition {
+	e.ISBN10 = ISBN10(e.ISBN13)
+	return e
+}
+
+// uniqueISBN reports a duplicate isbn13 as ErrDuplicateISBN
+func uniqueISBN(err error) error {
+	if e, ok := err.(*pq.Error); ok && e.Code == pqUniqueViolation {
+		return ErrDuplicateISBN
+	}
+
+	return err
+}
+
+// GetEdition returns an edition of a book
+func (e *Edition) GetEdition(db *sqlx.DB) error {
+	edition := Edition{}
+	err := db.Get(&edition, "SELECT "+editionColumns+" FROM editions WHERE id=$1 AND book_id=$2", e.ID, e.BookID)
+	if err != nil {
+		return err
+	}
+
+	*e = edition.withISBN10()
+	return nil
+}
+
+// GetEditionByISBN returns the edition with e's normalized ISBN-13
+func (e *Edition) GetEditionByISBN(db *sqlx.DB) error {
+	edition := Edition{}
+	err := db.Get(&edition, "SELECT "+editionColumns+" FROM editions WHERE isbn13=$1", e.ISBN13)
+	if err != nil {
+		return err
+	}
+
+	*e = edition.withISBN10()
+	return nil
+}
+
+// GetEditions returns every edition of a book
+func GetEditions(db *sqlx.DB, b *Book) ([]Edition, error) {
+	if err := b.GetBook(db, Expand{}, nil); err != nil {
+		return nil, err
+	}
+
+	editions := []Edition{}
+	if err := db.Select(&editions, "SELECT "+editionColumns+" FROM editions WHERE book_id=$1 ORDER BY published_date, id", b.ID); err != nil {
+		return nil, err
+	}
+
+	for i := range editions {
+		editions[i] = editions[i].withISBN10()
+	}
+
+	return editions, nil
+}
+
+// CreateEdition inserts a new edition
+func (e *Edition) CreateEdition(db *sqlx.DB) error {
+	edition := Edition{}
+	err := db.Get(&edition, "INSERT INTO editions (book_id, isbn13, format, page_count, language, publisher_id, published_date) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING "+editionColumns,
+		e.BookID, e.ISBN13, e.Format, e.PageCount, e.Language, e.PublisherID, e.PublishedDate)
+	if err != nil {
+		return uniqueISBN(err)
+	}
+
+	*e = edition.withISBN10()
+	return nil
+}
+
+// UpdateEdition updates an edition
+func (e *Edition) UpdateEdition(db *sqlx.DB) error {
+	edition := Edition{}
+	err := db.Get(&edition, "UPDATE editions SET isbn13=$1, format=$2, page_count=$3, language=$4, publisher_id=$5, published_date=$6, version=version+1 WHERE id=$7 AND book_id=$8 AND ($9=0 OR version=$9) RETURNING "+editionColumns,
+		e.ISBN13, e.Format, e.PageCount, e.Language, e.PublisherID, e.PublishedDate, e.ID, e.BookID, e.Version)
+	if err == sql.ErrNoRows {
+		return e.missingOrStale(db)
+	}
+	if err != nil {
+		return uniqueISBN(err)
+	}
+
+	*e = edition.withISBN10()
+	return nil
+}
+
+// DeleteEdition removes an edition
+func (e *Edition) DeleteEdition(db *sqlx.DB) error {
+	result, err := db.Exec("DELETE FROM editions WHERE id=$1 AND book_id=$2 AND ($3=0 OR version=$3)", e.ID, e.BookID, e.Version)
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return e.missingOrStale(db)
+	}
+
+	return nil
+}
+
+// missingOrStale explains why a conditional write to an edition matched
+// nothing, an edition of another book counts as missing
+func (e *Edition) missingOrStale(db *sqlx.DB) error {
+	var exists bool
+	if err := db.Get(&exists, "SELECT EXISTS(SELECT 1 FROM editions WHERE id=$1 AND book_id=$2)", e.ID, e.BookID); err != nil {
+		return err
+	}
+
+	if exists {
+		return ErrVersionMismatch
+	}
+
+	return sql.ErrNoRows
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/sha1"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ETag strong entity tag for a record version
+func ETag(version int) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// VariantTag strong entity tag for a variant of a record's representation,
+// such as one narrowed by ?fields= or embedding related records. It starts
+// with the record's version, followed by a digest of variant, which names
+// whatever else the body depends on. An empty variant is ETag(version).
+func VariantTag(version int, variant string) string {
+	if variant == "" {
+		return ETag(version)
+	}
+
+	sum := sha1.Sum([]byte(variant))
+	return fmt.Sprintf(`"%d-%x"`, version, sum[:8])
+}
+
+// IfMatchVersion reads the If-Match header, returning the version a write
+// requires or 0 when the header is absent or * so any version will do.
+// A VariantTag names the version it starts with. Weak or malformed tags
+// can never match, as required by RFC 7232. When the list names more than
+// one version, current looks up the record's version to pick the one the
+// write requires.
+func IfMatchVersion(r *http.Request, current func() (int, error)) (int, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return 0, nil
+	}
+
+	versions := []int{}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if !strings.HasPrefix(tag, `"`) || !strings.HasSuffix(tag, `"`) || len(tag) < 2 {
+			continue
+		}
+
+		version, err := strconv.Atoi(strings.SplitN(tag[1:len(tag)-1], "-", 2)[0])
+		if err != nil || version < 1 || containsInt(versions, version) {
+			continue
+		}
+		versions = append(versions, version)
+	}
+
+	switch len(versions) {
+	case 0:
+		return 0, NewProblem(http.StatusPreconditionFailed, "If-Match lists no strong ETag")
+	case 1:
+		return versions[0], nil
+	}
+
+	version, err := current()
+	if err == sql.ErrNoRows {
+		// let the write report the record missing
+		return versions[0], nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !containsInt(versions, version) {
+		return 0, ErrVersionMismatch
+	}
+
+	return version, nil
+}
+
+// NotModified answers a GET with 304 when If-None-Match lists the current
+// version, returning true when it did
+func NotModified(w http.ResponseWriter, r *http.Request, version int) bool {
+	return NotModifiedTag(w, r, ETag(version))
+}
+
+// NotModifiedTag answers a GET with 304 when If-None-Match lists current,
+// the tag of the representation asked for, returning true when it did
+func NotModifiedTag(w http.ResponseWriter, r *http.Request, current string) bool {
+	w.Header().Set("ETag", current)
+
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		// If-None-Match uses weak comparison
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == current {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+
+	return false
+}
+
+// containsInt whether ns holds n
+func containsInt(ns []int, n int) bool {
+	for _, m := range ns {
+		if m == n {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Expand relations to load alongside a resource, read from ?expand=
+type Expand map[string]bool
+
+// ParseExpand reads a comma separated list of relations restricted to allowed
+func ParseExpand(s string, allowed []string) (Expand, error) {
+	expand := Expand{}
+	if s == "" {
+		return expand, nil
+	}
+
+	for _, part := range strings.Split(s, ",") {
+		relation := strings.TrimSpace(part)
+
+		ok := false
+		for _, name := range allowed {
+			if name == relation {
+				ok = true
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("Invalid expand relation '%s'", relation)
+		}
+
+		expand[relation] = true
+	}
+
+	return expand, nil
+}
+
+// String the relations in e, sorted and comma separated
+func (e Expand) String() string {
+	relations := []string{}
+	for relation, ok := range e {
+		if ok {
+			relations = append(relations, relation)
+		}
+	}
+	sort.Strings(relations)
+
+	return strings.Join(relations, ",")
+}
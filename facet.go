@@ -0,0 +1,116 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Facets counts of the books in a list by each value of the facets asked
+// for, read from ?facets=
+type Facets map[string][]FacetCount
+
+// FacetCount number of books with a facet's value. Value is what the
+// matching filter takes, where there is one, and Label names the record
+// an id refers to.
+type FacetCount struct {
+	Value string `json:"value"`
+	Label string `json:"label,omitempty"`
+	Count int    `json:"count"`
+}
+
+// bookFacets queries counting the books in matches by each facet. A book
+// with editions in several languages counts once in each, as does a book
+// credited to several authors.
+var bookFacets = map[string]string{
+	"rating":    `SELECT rating::text AS value, '' AS label, COUNT(*) AS count FROM matches GROUP BY rating`,
+	"status":    `SELECT ` + bookStatus + `::text AS value, '' AS label, COUNT(*) AS count FROM matches GROUP BY 1`,
+	"available": `SELECT available::text AS value, '' AS label, COUNT(*) AS count FROM matches GROUP BY available`,
+	"publisher": `SELECT publishers.id::text AS value, publishers.name AS label, COUNT(*) AS count
+		FROM matches JOIN publishers ON publishers.id = matches.publisher_id GROUP BY publishers.id`,
+	"author": `SELECT authors.id::text AS value, concat_ws(' ', authors.first_name, authors.last_name) AS label, COUNT(DISTINCT matches.id) AS count
+		FROM matches JOIN book_contributors ON book_contributors.book_id = matches.id
+		JOIN authors ON authors.id = book_contributors.author_id GROUP BY authors.id`,
+	"decade": `SELECT (extract(year FROM published_date)::int / 10 * 10)::text AS value, '' AS label, COUNT(*) AS count
+		FROM matches GROUP BY 1`,
+	"language": `SELECT editions.language AS value, '' AS label, COUNT(DISTINCT matches.id) AS count
+		FROM matches JOIN editions ON editions.book_id = matches.id GROUP BY editions.language`,
+}
+
+// facetNames facets books can be counted by, in the order they're listed
+var facetNames = []string{"rating", "status", "available", "publisher", "author", "decade", "language"}
+
+// ParseFacets reads a comma separated list of facets restricted to
+// facetNames, none when s is empty
+func ParseFacets(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	return parseTypes(s, facetNames, "facet")
+}
+
+// bookFacetCounts counts the books selected by matches, a query over books
+// taking args, by each of names. Run it in the transaction that reads the
+// page so the counts agree with it.
+func bookFacetCounts(q sqlx.Queryer, names []string, matches string, args []interface{}) (Facets, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	facets := Facets{}
+	for _, name := range names {
+		counts := []FacetCount{}
+		query := "WITH matches AS (" + matches + ") " + bookFacets[name] + " ORDER BY count DESC, value"
+		if err := sqlx.Select(q, &counts, query, args...); err != nil {
+			return nil, err
+		}
+
+		if name == "status" {
+			for i, c := range counts {
+				status, _ := strconv.Atoi(c.Value)
+				counts[i].Value = Status(status).String()
+			}
+		}
+		facets[name] = counts
+	}
+
+	return facets, nil
+}
+
+// facetCounter tallies facet values for stores that count in process
+type facetCounter map[string]map[FacetCount]int
+
+// add counts a book under value of facet
+func (c facetCounter) add(facet, value, label string) {
+	if c[facet] == nil {
+		c[facet] = map[FacetCount]int{}
+	}
+	c[facet][FacetCount{Value: value, Label: label}]++
+}
+
+// facets the tallies of names, ordered like bookFacetCounts orders them
+func (c facetCounter) facets(names []string) Facets {
+	if len(names) == 0 {
+		return nil
+	}
+
+	facets := Facets{}
+	for _, name := range names {
+		counts := []FacetCount{}
+		for fc, n := range c[name] {
+			fc.Count = n
+			counts = append(counts, fc)
+		}
+		sort.Slice(counts, func(i, j int) bool {
+			if counts[i].Count != counts[j].Count {
+				return counts[i].Count > counts[j].Count
+			}
+			return counts[i].Value < counts[j].Value
+		})
+		facets[name] = counts
+	}
+
+	return facets
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFacets(t *testing.T) {
+	if facets, err := ParseFacets(""); facets != nil || err != nil {
+		t.Errorf("Expected no facets by default. Got %v, %v", facets, err)
+	}
+
+	if facets, err := ParseFacets("decade, rating"); err != nil || !reflect.DeepEqual(facets, []string{"decade", "rating"}) {
+		t.Errorf("Expected decade and rating. Got %v, %v", facets, err)
+	}
+
+	if _, err := ParseFacets("rating,isbn"); err == nil {
+		t.Error("Expected an unknown facet to be rejected")
+	}
+}
+
+func TestFacetCounter(t *testing.T) {
+	counter := facetCounter{}
+	counter.add("publisher", "2", "Tor")
+	counter.add("publisher", "1", "Ace")
+	counter.add("publisher", "2", "Tor")
+	counter.add("publisher", "3", "Orbit")
+
+	facets := counter.facets([]string{"publisher", "rating"})
+
+	expected := Facets{
+		"publisher": {{Value: "2", Label: "Tor", Count: 2}, {Value: "1", Label: "Ace", Count: 1}, {Value: "3", Label: "Orbit", Count: 1}},
+		"rating":    {},
+	}
+	if !reflect.DeepEqual(facets, expected) {
+		t.Errorf("Expected %v. Got %v", expected, facets)
+	}
+}
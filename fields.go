@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Fields JSON fields to include in a response, read from ?fields=, with
+// the fields wanted from a nested relation under its name. A relation
+// with no nested fields is included whole, and nil Fields include
+// everything.
+type Fields map[string]Fields
+
+var (
+	authorSchema    = Fields{"id": nil, "firstName": nil, "lastName": nil, "penName": nil}
+	publisherSchema = Fields{"id": nil, "name": nil}
+	bookSchema      = Fields{
+		"id":            nil,
+		"title":         nil,
+		"publishedDate": nil,
+		"rating":        nil,
+		"copies":        nil,
+		"available":     nil,
+		"publisherId":   nil,
+		"authorId":      nil,
+		"publisher":     publisherSchema,
+		"author":        authorSchema,
+		"contributors":  Fields{"authorId": nil, "role": nil, "position": nil},
+	}
+)
+
+// ParseFields reads a comma separated list of fields such as
+// id,title,author.lastName, restricted to the fields in schema
+func ParseFields(s string, schema Fields) (Fields, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	fields := Fields{}
+	for _, part := range strings.Split(s, ",") {
+		path := strings.Split(strings.TrimSpace(part), ".")
+
+		wanted, allowed := fields, schema
+		for i, name := range path {
+			nested, ok := allowed[name]
+			if !ok || (i < len(path)-1 && nested == nil) {
+				return nil, fmt.Errorf("Invalid field '%s'", strings.TrimSpace(part))
+			}
+
+			if i == len(path)-1 {
+				// naming a relation outright includes all of it
+				wanted[name] = nil
+				break
+			}
+			if _, ok := wanted[name]; !ok {
+				wanted[name] = Fields{}
+			} else if wanted[name] == nil {
+				break
+			}
+			wanted, allowed = wanted[name], nested
+		}
+	}
+
+	return fields, nil
+}
+
+// String the fields in f as ParseFields reads them, sorted, empty when f
+// is nil
+func (f Fields) String() string {
+	return strings.Join(f.paths(""), ",")
+}
+
+func (f Fields) paths(prefix string) []string {
+	paths := []string{}
+	for name, nested := range f {
+		if nested == nil {
+			paths = append(paths, prefix+name)
+		} else {
+			paths = append(paths, nested.paths(prefix+name+".")...)
+		}
+	}
+	sort.Strings(paths)
+
+	return paths
+}
+
+// Expand adds the relations of allowed named in f to expand, since a
+// relation has to be loaded for its fields to be returned
+func (f Fields) Expand(expand Expand, allowed []string) {
+	for _, relation := range allowed {
+		if _, ok := f[relation]; ok {
+			expand[relation] = true
+		}
+	}
+}
+
+// Columns the columns behind the fields in f, looked up in each of
+// fieldColumns such as the columns a patch writes or the foreign key of a
+// relation. sort adds the columns a cursor needs, while id and version are
+// always selected.
+func (f Fields) Columns(sort []SortField, fieldColumns ...map[string]string) []string {
+	if f == nil {
+		return nil
+	}
+
+	columns := []string{"id", "version"}
+	for name := range f {
+		for _, m := range fieldColumns {
+			if column, ok := m[name]; ok {
+				columns = append(columns, column)
+			}
+		}
+	}
+	for _, s := range sort {
+		columns = append(columns, s.Column)
+	}
+
+	return columns
+}
+
+// projection the columns of the comma separated all that are in wanted,
+// or all of them when wanted is nil
+func projection(all string, wanted []string) string {
+	if wanted == nil {
+		return all
+	}
+
+	want := map[string]bool{}
+	for _, column := range wanted {
+		want[column] = true
+	}
+
+	columns := []string{}
+	for _, column := range strings.Split(all, ", ") {
+		if want[column] {
+			columns = append(columns, column)
+		}
+	}
+
+	return strings.Join(columns, ", ")
+}
+
+// Select v with only the fields in f, v itself when f is nil. Lists have
+// each of their items narrowed.
+func (f Fields) Select(v interface{}) interface{} {
+	if f == nil {
+		return v
+	}
+
+	body, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+
+	var doc interface{}
+	json.Unmarshal(body, &doc)
+
+	return f.prune(doc)
+}
+
+func (f Fields) prune(doc interface{}) interface{} {
+	switch d := doc.(type) {
+	case []interface{}:
+		for i := range d {
+			d[i] = f.prune(d[i])
+		}
+	case map[string]interface{}:
+		for k, v := range d {
+			nested, ok := f[k]
+			if !ok {
+				delete(d, k)
+			} else if nested != nil {
+				d[k] = nested.prune(v)
+			}
+		}
+	}
+
+	return doc
+}
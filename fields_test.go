@@ -0,0 +1,67 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestParseFields(t *testing.T) {
+	fields, err := ParseFields("id, title,author.lastName,author.firstName,publisher", bookSchema)
+	if err != nil {
+		t.Fatalf("Expected fields to parse. Got %v", err)
+	}
+
+	expected := Fields{"id": nil, "title": nil, "author": Fields{"lastName": nil, "firstName": nil}, "publisher": nil}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("Expected %v. Got %v", expected, fields)
+	}
+
+	if s := fields.String(); s != "author.firstName,author.lastName,id,publisher,title" {
+		t.Errorf("Expected the fields sorted as they're read. Got %s", s)
+	}
+
+	if fields, _ := ParseFields("author,author.lastName", bookSchema); fields["author"] != nil {
+		t.Errorf("Expected naming a relation to include all of it. Got %v", fields)
+	}
+
+	if fields, err := ParseFields("", bookSchema); fields != nil || err != nil {
+		t.Errorf("Expected no fields to select everything. Got %v, %v", fields, err)
+	}
+
+	for _, s := range []string{"isbn", "title.length", "author.isbn", "publisher.name.first", "id,"} {
+		if _, err := ParseFields(s, bookSchema); err == nil {
+			t.Errorf("Expected fields '%s' to be rejected", s)
+		}
+	}
+}
+
+func TestFieldsColumns(t *testing.T) {
+	fields := Fields{"title": nil, "available": nil, "author": Fields{"lastName": nil}}
+	columns := fields.Columns([]SortField{{Column: "published_date"}}, bookFields, bookDerivedFields, bookRelationColumns)
+	sort.Strings(columns)
+
+	expected := []string{"author_id", "available", "id", "published_date", "title", "version"}
+	if !reflect.DeepEqual(columns, expected) {
+		t.Errorf("Expected columns %v. Got %v", expected, columns)
+	}
+
+	if p := projection(bookColumns, columns); p != "id, title, published_date, available, author_id, version" {
+		t.Errorf("Expected the projection to keep the column order. Got '%s'", p)
+	}
+	if p := projection(bookColumns, nil); p != bookColumns {
+		t.Errorf("Expected every column without fields. Got '%s'", p)
+	}
+}
+
+func TestFieldsSelect(t *testing.T) {
+	id := 1
+	books := []Book{{ID: 1, Title: "Dune", AuthorID: &id, Author: &Author{ID: 1, FirstName: "Frank", LastName: "Herbert"}}}
+
+	selected := Fields{"title": nil, "author": Fields{"lastName": nil}}.Select(books)
+
+	expected := []interface{}{map[string]interface{}{"title": "Dune", "author": map[string]interface{}{"lastName": "Herbert"}}}
+	if !reflect.DeepEqual(selected, expected) {
+		t.Errorf("Expected %v. Got %v", expected, selected)
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Filter restricts a list to records whose column compares to one of
+// Values with Op. The has Op matches an array column holding any of Values.
+type Filter struct {
+	Column string
+	Op     string
+	Values []interface{}
+}
+
+// derivedColumns models whose filters may name an SQL expression rather
+// than a column, for stores that filter in process
+type derivedColumns interface {
+	derivedColumn(column string) (interface{}, bool)
+}
+
+// FilterField a query parameter a list can be filtered by. An = or has
+// filter accepts a comma separated list of values and matches any of them.
+type FilterField struct {
+	Column string
+	Op     string
+	Parse  func(string) (interface{}, error)
+}
+
+// listParams query parameters list endpoints take besides their filters
+var listParams = map[string]bool{
+	"start":      true,
+	"count":      true,
+	"sort":       true,
+	"cursor":     true,
+	"expand":     true,
+	"envelope":   true,
+	"match":      true,
+	"similarity": true,
+	"fields":     true,
+	"facets":     true,
+	"branch_id":  true,
+}
+
+// bookFilters query parameters books can be filtered by
+var bookFilters = map[string]FilterField{
+	"rating":           {Column: "rating", Op: "=", Parse: parseRating},
+	"status":           {Column: bookStatus, Op: "=", Parse: parseStatus},
+	"available":        {Column: "available", Op: ">=", Parse: parseCount},
+	"author_id":        {Column: bookContributorIDs, Op: "has", Parse: parseID},
+	"publisher_id":     {Column: "publisher_id", Op: "=", Parse: parseID},
+	"published_after":  {Column: "published_date", Op: ">=", Parse: parseDate},
+	"published_before": {Column: "published_date", Op: "<", Parse: parseDate},
+}
+
+// authorFilters query parameters authors can be filtered by
+var authorFilters = map[string]FilterField{
+	"first_name": {Column: "first_name", Op: "=", Parse: parseText},
+	"last_name":  {Column: "last_name", Op: "=", Parse: parseText},
+	"pen_name":   {Column: "pen_name", Op: "=", Parse: parseText},
+}
+
+// publisherFilters query parameters publishers can be filtered by
+var publisherFilters = map[string]FilterField{
+	"name": {Column: "name", Op: "=", Parse: parseText},
+}
+
+// patronFilters query parameters patrons can be filtered by
+var patronFilters = map[string]FilterField{
+	"card_number":    {Column: "card_number", Op: "=", Parse: parseText},
+	"status":         {Column: "status", Op: "=", Parse: parsePatronStatus},
+	"expires_before": {Column: "expires_at", Op: "<", Parse: parseDate},
+}
+
+// branchFilters query parameters branches can be filtered by
+var branchFilters = map[string]FilterField{
+	"code": {Column: "code", Op: "=", Parse: parseText},
+	"name": {Column: "name", Op: "=", Parse: parseText},
+}
+
+// transferFilters query parameters transfers can be filtered by, besides
+// branch_id which lists those from or to a branch
+var transferFilters = map[string]FilterField{
+	"status":           {Column: "status", Op: "=", Parse: parseTransferStatus},
+	"item_id":          {Column: "item_id", Op: "=", Parse: parseID},
+	"book_id":          {Column: "book_id", Op: "=", Parse: parseID},
+	"from_branch_id":   {Column: "from_branch_id", Op: "=", Parse: parseID},
+	"to_branch_id":     {Column: "to_branch_id", Op: "=", Parse: parseID},
+	"requested_after":  {Column: "requested_at", Op: ">=", Parse: parseDate},
+	"requested_before": {Column: "requested_at", Op: "<", Parse: parseDate},
+}
+
+// ParseFilters reads the filters in query, rejecting parameters that are
+// neither filters nor listParams so a misspelled filter isn't ignored
+func ParseFilters(query url.Values, fields map[string]FilterField) ([]Filter, error) {
+	params := []string{}
+	for param := range query {
+		params = append(params, param)
+	}
+	sort.Strings(params)
+
+	filters := []Filter{}
+	for _, param := range params {
+		field, ok := fields[param]
+		if !ok {
+			if listParams[param] {
+				continue
+			}
+			return nil, fmt.Errorf("Invalid filter '%s'", param)
+		}
+
+		for _, value := range query[param] {
+			parts := []string{value}
+			if field.Op == "=" || field.Op == "has" {
+				parts = strings.Split(value, ",")
+			}
+
+			f := Filter{Column: field.Column, Op: field.Op}
+			for _, part := range parts {
+				v, err := field.Parse(strings.TrimSpace(part))
+				if err != nil {
+					return nil, fmt.Errorf("Invalid %s '%s': %v", param, part, err)
+				}
+				f.Values = append(f.Values, v)
+			}
+			filters = append(filters, f)
+		}
+	}
+
+	return filters, nil
+}
+
+func parseID(s string) (interface{}, error) {
+	id, err := strconv.Atoi(s)
+	if err != nil || id < 1 {
+		return nil, fmt.Errorf("expected an id")
+	}
+
+	return id, nil
+}
+
+func parseRating(s string) (interface{}, error) {
+	rating, err := strconv.Atoi(s)
+	if err != nil || Rating(rating) < OneStar || Rating(rating) > ThreeStars {
+		return nil, fmt.Errorf("expected %d to %d", OneStar, ThreeStars)
+	}
+
+	return rating, nil
+}
+
+// parseStatus accepts a book status by name or number
+func parseStatus(s string) (interface{}, error) {
+	for _, status := range []Status{CheckedOut, CheckedIn} {
+		if s == status.String() || s == strconv.Itoa(int(status)) {
+			return int(status), nil
+		}
+	}
+
+	return nil, fmt.Errorf("expected %s or %s", CheckedOut, CheckedIn)
+}
+
+// parseCount accepts a number of copies
+func parseCount(s string) (interface{}, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("expected a number of copies")
+	}
+
+	return n, nil
+}
+
+// parseDate accepts a date or an RFC 3339 time
+func parseDate(s string) (interface{}, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	return nil, fmt.Errorf("expected a date such as 2000-01-31")
+}
+
+func parsePatronStatus(s string) (interface{}, error) {
+	for _, status := range PatronStatuses {
+		if s == string(status) {
+			return s, nil
+		}
+	}
+
+	return nil, fmt.Errorf("expected one of %v", PatronStatuses)
+}
+
+func parseTransferStatus(s string) (interface{}, error) {
+	for _, status := range TransferStatuses {
+		if s == string(status) {
+			return s, nil
+		}
+	}
+
+	return nil, fmt.Errorf("expected one of %v", TransferStatuses)
+}
+
+// ParseBranch reads the branch_id a list is narrowed to, 0 when there is
+// none
+func ParseBranch(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	id, err := parseID(s)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid branch_id '%s': %v", s, err)
+	}
+
+	return id.(int), nil
+}
+
+func parseText(s string) (interface{}, error) {
+	if s == "" {
+		return nil, fmt.Errorf("expected a value")
+	}
+
+	return s, nil
+}
+
+// filterConditions SQL conditions for filters, passing their values to arg
+// for placeholders. Columns come from the FilterField whitelists.
+func filterConditions(filters []Filter, arg func(v interface{}) string) []string {
+	conditions := []string{}
+	for _, f := range filters {
+		if f.Op == "has" {
+			matches := []string{}
+			for _, v := range f.Values {
+				matches = append(matches, arg(v)+" = ANY("+f.Column+")")
+			}
+			conditions = append(conditions, "("+strings.Join(matches, " OR ")+")")
+			continue
+		}
+
+		if len(f.Values) == 1 {
+			conditions = append(conditions, f.Column+" "+f.Op+" "+arg(f.Values[0]))
+			continue
+		}
+
+		placeholders := []string{}
+		for _, v := range f.Values {
+			placeholders = append(placeholders, arg(v))
+		}
+		conditions = append(conditions, f.Column+" IN ("+strings.Join(placeholders, ", ")+")")
+	}
+
+	return conditions
+}
+
+// matchesFilters reports whether model passes every filter, for stores
+// that filter in process
+func matchesFilters(model interface{}, filters []Filter) bool {
+	for _, f := range filters {
+		v := filterColumn(model, f.Column)
+		if v == nil {
+			return false
+		}
+
+		ok := false
+		for _, value := range f.Values {
+			if f.Op == "has" {
+				for _, held := range v.([]int) {
+					ok = ok || held == value
+				}
+				continue
+			}
+
+			c := compareKeys(v, value)
+			switch f.Op {
+			case "=":
+				ok = ok || c == 0
+			case ">=":
+				ok = ok || c >= 0
+			case "<":
+				ok = ok || c < 0
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// filterColumn the value of model's column, or of the SQL expression a
+// filter names in its place
+func filterColumn(model interface{}, column string) interface{} {
+	if d, ok := model.(derivedColumns); ok {
+		if v, ok := d.derivedColumn(column); ok {
+			return v
+		}
+	}
+
+	return filterValue(columnValue(model, column))
+}
+
+// filterValue a column value as compareKeys expects it, with named ints as
+// int and nil pointers as nil
+func filterValue(v interface{}) interface{} {
+	value := reflect.ValueOf(v)
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+
+	switch value.Kind() {
+	case reflect.Int:
+		return int(value.Int())
+	case reflect.String:
+		return value.String()
+	}
+
+	return value.Interface()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ItemTypeBook the item type of a loan of a whole book, and the rate used
+// for item types the schedule doesn't list
+const ItemTypeBook = "book"
+
+// DefaultMaxBalance cents a patron may owe and still borrow when
+// App.MaxBalance is nil
+const DefaultMaxBalance = 1000
+
+// FineRate what an overdue loan of an item type costs, in cents. Nothing
+// is charged for a loan returned within GraceDays of being due, and after
+// that every day since it was due counts, up to Cap when Cap is set.
+type FineRate struct {
+	DailyRate int `json:"dailyRate"`
+	Cap       int `json:"cap"`
+	GraceDays int `json:"graceDays"`
+}
+
+// FineSchedule the fine rate of each item type
+type FineSchedule map[string]FineRate
+
+// DefaultFineSchedule charges 25 cents a day up to 10.00 after a day's
+// grace
+var DefaultFineSchedule = FineSchedule{ItemTypeBook: {DailyRate: 25, Cap: 1000, GraceDays: 1}}
+
+// ParseFineSchedule reads a schedule such as
+// {"book": {"dailyRate": 25, "cap": 1000, "graceDays": 1}}
+func ParseFineSchedule(s string) (FineSchedule, error) {
+	schedule := FineSchedule{}
+	if err := json.Unmarshal([]byte(s), &schedule); err != nil {
+		return nil, fmt.Errorf("Invalid fine schedule: %v", err)
+	}
+
+	for itemType, rate := range schedule {
+		if rate.DailyRate < 0 || rate.Cap < 0 || rate.GraceDays < 0 {
+			return nil, fmt.Errorf("Invalid fine schedule: the rate of %s can't be negative", itemType)
+		}
+	}
+
+	return schedule, nil
+}
+
+// rate the fine rate of itemType
+func (s FineSchedule) rate(itemType string) FineRate {
+	if rate, ok := s[itemType]; ok {
+		return rate
+	}
+
+	return s[ItemTypeBook]
+}
+
+// Fine the days l is overdue at and the fine owed for them, which is
+// nothing while the loan is within its grace period
+func (s FineSchedule) Fine(l Loan, at time.Time) (int, int) {
+	late := at.Sub(l.DueAt)
+	if late <= 0 {
+		return 0, 0
+	}
+
+	day := 24 * time.Hour
+	days := int((late + day - 1) / day)
+
+	rate := s.rate(l.ItemType)
+	if days <= rate.GraceDays {
+		return days, 0
+	}
+
+	fine := days * rate.DailyRate
+	if rate.Cap > 0 && fine > rate.Cap {
+		fine = rate.Cap
+	}
+
+	return days, fine
+}
+
+// OverdueLoan an open loan past its due date and the fine it has run up
+// so far
+type OverdueLoan struct {
+	Loan
+	DaysOverdue int `json:"daysOverdue"`
+	Fine        int `json:"fine"`
+}
+
+// Overdue the loans of loans that are still out and overdue at now
+func (s FineSchedule) Overdue(loans []Loan, now time.Time) []OverdueLoan {
+	overdue := []OverdueLoan{}
+	for _, l := range loans {
+		if l.ReturnedAt != nil {
+			continue
+		}
+		if days, fine := s.Fine(l, now); days > 0 {
+			overdue = append(overdue, OverdueLoan{Loan: l, DaysOverdue: days, Fine: fine})
+		}
+	}
+
+	return overdue
+}
+
+// Accruing what the overdue loans of loans would cost if returned at now
+func (s FineSchedule) Accruing(loans []Loan, now time.Time) int {
+	accruing := 0
+	for _, o := range s.Overdue(loans, now) {
+		accruing += o.Fine
+	}
+
+	return accruing
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFine(t *testing.T) {
+	due := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	fines := FineSchedule{
+		ItemTypeBook: {DailyRate: 25, Cap: 100, GraceDays: 2},
+		"dvd":        {DailyRate: 100},
+	}
+
+	cases := []struct {
+		itemType string
+		late     time.Duration
+		days     int
+		fine     int
+	}{
+		{ItemTypeBook, -time.Hour, 0, 0},
+		{ItemTypeBook, 0, 0, 0},
+		{ItemTypeBook, time.Minute, 1, 0},
+		{ItemTypeBook, 2 * day, 2, 0},
+		{ItemTypeBook, 2*day + time.Minute, 3, 75},
+		{ItemTypeBook, 30 * day, 30, 100},
+		{"dvd", 30 * day, 30, 3000},
+		{"magazine", 3 * day, 3, 75},
+	}
+
+	for _, c := range cases {
+		days, fine := fines.Fine(Loan{ItemType: c.itemType, DueAt: due}, due.Add(c.late))
+		if days != c.days || fine != c.fine {
+			t.Errorf("Expected a %s %v late to be %d days and %d cents. Got %d and %d", c.itemType, c.late, c.days, c.fine, days, fine)
+		}
+	}
+
+	returned := due.Add(10 * day)
+	overdue := fines.Overdue([]Loan{
+		{ID: 1, ItemType: ItemTypeBook, DueAt: due},
+		{ID: 2, ItemType: ItemTypeBook, DueAt: due, ReturnedAt: &returned},
+		{ID: 3, ItemType: ItemTypeBook, DueAt: due.Add(20 * day)},
+	}, due.Add(5*day))
+	if len(overdue) != 1 || overdue[0].ID != 1 || overdue[0].DaysOverdue != 5 || overdue[0].Fine != 100 {
+		t.Errorf("Expected only the open overdue loan. Got %+v", overdue)
+	}
+}
+
+func TestParseFineSchedule(t *testing.T) {
+	fines, err := ParseFineSchedule(`{"book": {"dailyRate": 10, "cap": 200, "graceDays": 3}}`)
+	if err != nil || fines[ItemTypeBook] != (FineRate{DailyRate: 10, Cap: 200, GraceDays: 3}) {
+		t.Errorf("Expected the book rate to be read. Got %v, %v", fines, err)
+	}
+
+	for _, s := range []string{`book=10`, `{"book": {"dailyRate": -1}}`} {
+		if _, err := ParseFineSchedule(s); err == nil {
+			t.Errorf("Expected %s to be rejected", s)
+		}
+	}
+}
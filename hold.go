@@ -0,0 +1,292 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// DefaultPickupPeriod how long a book waits on the shelf for the patron
+// whose hold came up when App.PickupPeriod is unset
+const DefaultPickupPeriod = 7 * 24 * time.Hour
+
+var (
+	// ErrBookAvailable returned when holding a book with a copy on the
+	// shelf
+	ErrBookAvailable = errors.New("book is available, check it out instead")
+
+	// ErrDuplicateHold returned when a patron holds a book twice
+	ErrDuplicateHold = errors.New("patron is already in the queue for this book")
+
+	// ErrAlreadyBorrowed returned when a patron holds a book they have out
+	ErrAlreadyBorrowed = errors.New("patron already has this book checked out")
+
+	// ErrOnHold returned when checking out a copy set aside for another
+	// patron
+	ErrOnHold = errors.New("book is on hold for another patron")
+
+	// ErrHoldClosed returned when cancelling a hold that was fulfilled,
+	// cancelled or expired
+	ErrHoldClosed = errors.New("hold is no longer active")
+)
+
+// Hold a patron's place in the queue for a book. Holds wait in the order
+// they were placed, and when a copy comes back the first one it can be
+// picked up for is ready until ExpiresAt, with ItemID the copy set aside
+// for it. A hold with a PickupBranchID only takes copies shelved there,
+// one without takes a copy from any branch. Position counts from 1 for the
+// hold at the front of the queue and is left out once a hold is closed.
+type Hold struct {
+	ID             int        `json:"id"`
+	BookID         int        `json:"bookId" db:"book_id"`
+	PatronID       int        `json:"patronId" db:"patron_id"`
+	ItemID         *int       `json:"itemId,omitempty" db:"item_id"`
+	PickupBranchID *int       `json:"pickupBranchId,omitempty" db:"pickup_branch_id"`
+	Status         HoldStatus `json:"status"`
+	Position       int        `json:"position,omitempty"`
+	PlacedAt       time.Time  `json:"placedAt" db:"placed_at"`
+	ReadyAt        *time.Time `json:"readyAt,omitempty" db:"ready_at"`
+	ExpiresAt      *time.Time `json:"expiresAt,omitempty" db:"expires_at"`
+	PickupSeconds  int        `json:"-" db:"pickup_seconds"`
+}
+
+// HoldStatus where a hold is in its life
+type HoldStatus string
+
+// valid hold statuses, waiting and ready holds are active
+const (
+	HoldWaiting   HoldStatus = "waiting"
+	HoldReady     HoldStatus = "ready"
+	HoldFulfilled HoldStatus = "fulfilled"
+	HoldCancelled HoldStatus = "cancelled"
+	HoldExpired   HoldStatus = "expired"
+)
+
+// Active whether the hold still has a place in the queue
+func (h Hold) Active() bool {
+	return h.Status == HoldWaiting || h.Status == HoldReady
+}
+
+const holdColumns = "id, book_id, patron_id, item_id, pickup_branch_id, status, placed_at, ready_at, expires_at, pickup_seconds"
+
+// asOf h as it stands at now, a ready hold whose pickup period is over
+// counting as expired before a write moves its queue along
+func (h Hold) asOf(now time.Time) Hold {
+	if h.Status == HoldReady && h.ExpiresAt != nil && !now.Before(*h.ExpiresAt) {
+		h.Status = HoldExpired
+	}
+
+	return h
+}
+
+// holdsNow holds as they stand now, which reads show without moving the
+// queue along, as Hold.asOf does
+const holdsNow = `SELECT id, book_id, patron_id, item_id, pickup_branch_id,
+	CASE WHEN status = 'ready' AND expires_at <= now() THEN 'expired' ELSE status END AS status,
+	placed_at, ready_at, expires_at, pickup_seconds FROM holds`
+
+// holdQueue holds numbered by their place in their book's queue, the ready
+// holds first and then the waiting ones, each as they were placed
+const holdQueue = `SELECT holds.*, CASE WHEN status IN ('waiting', 'ready')
+	THEN row_number() OVER (PARTITION BY book_id, status IN ('waiting', 'ready') ORDER BY status = 'ready' DESC, placed_at, id)
+	ELSE 0 END AS position FROM (` + holdsNow + `) holds`
+
+// PlaceHold queues p for b to pick up at branch, or at any branch when its
+// ID is 0, keeping the pickup period in force now for when the hold comes
+// up. Only a book without a copy on the shelf where it is to be picked up
+// can be held.
+func (b *Book) PlaceHold(db *sqlx.DB, p *Patron, branch *Branch, pickup time.Duration) (Hold, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return Hold{}, err
+	}
+	defer tx.Rollback()
+
+	patron := Patron{}
+	if err := tx.Get(&patron, "SELECT "+patronColumns+" FROM patrons WHERE id=$1", p.ID); err != nil {
+		return Hold{}, err
+	}
+	if err := patron.CanHold(time.Now()); err != nil {
+		return Hold{}, err
+	}
+
+	if err := lockBook(tx, b.ID); err != nil {
+		return Hold{}, err
+	}
+	if err := advanceHolds(tx, b.ID); err != nil {
+		return Hold{}, err
+	}
+
+	var state struct {
+		Shelved  bool
+		Borrowed bool
+	}
+	err = tx.Get(&state, `SELECT EXISTS (SELECT 1 FROM items WHERE book_id=$1 AND status=$3 AND ($4=0 OR branch_id=$4)) AS shelved,
+		EXISTS (SELECT 1 FROM loans WHERE book_id=$1 AND patron_id=$2 AND returned_at IS NULL) AS borrowed`, b.ID, p.ID, CheckedIn, branch.ID)
+	if err != nil {
+		return Hold{}, err
+	}
+	switch {
+	case state.Shelved:
+		return Hold{}, ErrBookAvailable
+	case state.Borrowed:
+		return Hold{}, ErrAlreadyBorrowed
+	}
+
+	var id int
+	err = tx.Get(&id, "INSERT INTO holds (book_id, patron_id, pickup_branch_id, pickup_seconds) VALUES ($1, $2, NULLIF($3, 0), $4) RETURNING id", b.ID, p.ID, branch.ID, int(pickup.Seconds()))
+	if e, ok := err.(*pq.Error); ok && e.Code == pqUniqueViolation {
+		return Hold{}, ErrDuplicateHold
+	}
+	if err != nil {
+		return Hold{}, err
+	}
+
+	hold := Hold{}
+	if err := tx.Get(&hold, "SELECT "+holdColumns+", position FROM ("+holdQueue+") holds WHERE book_id=$1 AND id=$2", b.ID, id); err != nil {
+		return Hold{}, err
+	}
+
+	return hold, tx.Commit()
+}
+
+// advanceHolds moves b's queue along, the caller must hold b's lock. A
+// ready hold that wasn't picked up in time expires, and while a copy is
+// neither out, set aside nor in transit the first waiting hold that can be
+// picked up where it is shelved gets it. The book's availability is
+// brought up to date after.
+func advanceHolds(tx *sqlx.Tx, bookID int) error {
+	if _, err := tx.Exec("UPDATE holds SET status='expired' WHERE book_id=$1 AND status='ready' AND expires_at <= now()", bookID); err != nil {
+		return err
+	}
+
+	for {
+		result, err := tx.Exec(`UPDATE holds SET status='ready', item_id=pick.item_id, ready_at=now(), expires_at=now() + make_interval(secs => pickup_seconds)
+			FROM (SELECT id, item_id FROM (SELECT waiting.id, waiting.placed_at, (SELECT items.id FROM items WHERE items.book_id=$1
+					AND (waiting.pickup_branch_id IS NULL OR items.branch_id=waiting.pickup_branch_id)
+					AND NOT EXISTS (SELECT 1 FROM loans WHERE item_id=items.id AND returned_at IS NULL)
+					AND NOT EXISTS (SELECT 1 FROM holds WHERE item_id=items.id AND status='ready')
+					AND NOT EXISTS (SELECT 1 FROM transfers WHERE item_id=items.id AND status='in_transit')
+					ORDER BY items.id LIMIT 1) AS item_id
+				FROM holds waiting WHERE waiting.book_id=$1 AND waiting.status='waiting') candidates
+				WHERE item_id IS NOT NULL ORDER BY placed_at, id LIMIT 1) pick
+			WHERE holds.id=pick.id`, bookID)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			break
+		}
+	}
+
+	return syncAvailability(tx, bookID)
+}
+
+// holdsAtBranch SQL condition that a hold's copy is set aside at a branch,
+// or that it is still waiting to be picked up there, always true when
+// branchID is 0
+func holdsAtBranch(branchID int) string {
+	if branchID == 0 {
+		return "TRUE"
+	}
+
+	return fmt.Sprintf("(%s OR (item_id IS NULL AND pickup_branch_id = %d))", atBranch("item_id", branchID), branchID)
+}
+
+// GetHolds returns b's queue, the ready holds first, only those at branchID
+// when it isn't 0. Reading leaves the queue as it is, a hold that is no
+// longer ready is shown expired until a write moves the queue along.
+func GetHolds(db *sqlx.DB, b *Book, branchID int) ([]Hold, error) {
+	tx, err := beginRead(db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.Get(&exists, "SELECT EXISTS(SELECT 1 FROM books WHERE id=$1)", b.ID); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, sql.ErrNoRows
+	}
+
+	holds := []Hold{}
+	if err := tx.Select(&holds, "SELECT "+holdColumns+", position FROM ("+holdQueue+") holds WHERE book_id=$1 AND position > 0 AND "+holdsAtBranch(branchID)+" ORDER BY position", b.ID); err != nil {
+		return nil, err
+	}
+
+	return holds, nil
+}
+
+// GetHold returns a hold of h.BookID along with its place in the queue
+func (h *Hold) GetHold(db *sqlx.DB) error {
+	hold := Hold{}
+	if err := db.Get(&hold, "SELECT "+holdColumns+", position FROM ("+holdQueue+") holds WHERE book_id=$1 AND id=$2", h.BookID, h.ID); err != nil {
+		return err
+	}
+
+	*h = hold
+	return nil
+}
+
+// CancelHold takes h out of its book's queue, passing the copy set aside
+// for it on to the next hold
+func (h *Hold) CancelHold(db *sqlx.DB) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockBook(tx, h.BookID); err != nil {
+		return err
+	}
+	if err := advanceHolds(tx, h.BookID); err != nil {
+		return err
+	}
+
+	var status HoldStatus
+	if err := tx.Get(&status, "SELECT status FROM holds WHERE book_id=$1 AND id=$2", h.BookID, h.ID); err != nil {
+		return err
+	}
+	if status != HoldWaiting && status != HoldReady {
+		return ErrHoldClosed
+	}
+
+	if _, err := tx.Exec("UPDATE holds SET status='cancelled' WHERE id=$1", h.ID); err != nil {
+		return err
+	}
+	if err := advanceHolds(tx, h.BookID); err != nil {
+		return err
+	}
+
+	hold := Hold{}
+	if err := tx.Get(&hold, "SELECT "+holdColumns+", position FROM ("+holdQueue+") holds WHERE id=$1", h.ID); err != nil {
+		return err
+	}
+
+	*h = hold
+	return tx.Commit()
+}
+
+// GetPatronHolds returns p's holds, the latest first, only those at
+// branchID when it isn't 0
+func GetPatronHolds(db *sqlx.DB, p *Patron, branchID int) ([]Hold, error) {
+	if err := p.GetPatron(db); err != nil {
+		return nil, err
+	}
+
+	holds := []Hold{}
+	if err := db.Select(&holds, "SELECT "+holdColumns+", position FROM ("+holdQueue+") holds WHERE patron_id=$1 AND "+holdsAtBranch(branchID)+" ORDER BY placed_at DESC, id DESC", p.ID); err != nil {
+		return nil, err
+	}
+
+	return holds, nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdvanceHolds(t *testing.T) {
+	s := NewMemoryStore()
+	s.CreateBook(&Book{Title: "Dune"})
+	s.CreateBranch(&Branch{Code: "MAIN", Name: "Main library"})
+	s.CreateItem(&Item{BookID: 1, BranchID: 1, Barcode: "ITEM-1", Condition: ConditionGood, ItemType: ItemTypeBook})
+	for _, card := range []string{"A-1001", "A-1002"} {
+		p := Patron{CardNumber: card, Name: card}
+		p.Defaults(time.Now())
+		s.CreatePatron(&p)
+	}
+
+	if _, err := s.Checkout(&Book{ID: 1}, &Item{}, &Patron{ID: 1}, time.Hour, DefaultFineSchedule, DefaultMaxBalance); err != nil {
+		t.Fatalf("Expected the book to be checked out. Got %v", err)
+	}
+	for _, patron := range []int{1, 2} {
+		if _, err := s.PlaceHold(&Book{ID: 1}, &Patron{ID: patron}, &Branch{}, time.Hour); patron == 1 && err != ErrAlreadyBorrowed {
+			t.Errorf("Expected the borrower's hold to be refused. Got %v", err)
+		} else if patron == 2 && err != nil {
+			t.Fatalf("Expected the hold to be placed. Got %v", err)
+		}
+	}
+
+	now := time.Now()
+	s.advanceHolds(1, now)
+	if h := s.holds[1]; h.Status != HoldWaiting {
+		t.Errorf("Expected the hold to wait while the book is out. Got %+v", h)
+	}
+
+	s.Checkin(&Book{ID: 1}, &Item{}, DefaultFineSchedule)
+	h := s.holds[1]
+	if h.Status != HoldReady || h.ItemID == nil || *h.ItemID != 1 || h.ExpiresAt.Sub(*h.ReadyAt) != time.Hour {
+		t.Fatalf("Expected the copy to be set aside for the hold for an hour. Got %+v", h)
+	}
+	if s.items[1].Status != OnHoldShelf || s.books[1].Available != 0 {
+		t.Errorf("Expected the copy on the hold shelf. Got %+v, %+v", s.items[1], s.books[1])
+	}
+
+	s.advanceHolds(1, h.ExpiresAt.Add(-time.Second))
+	if s.holds[1].Status != HoldReady {
+		t.Errorf("Expected the hold to stay ready until it expires. Got %+v", s.holds[1])
+	}
+
+	s.advanceHolds(1, *h.ExpiresAt)
+	if s.holds[1].Status != HoldExpired || s.items[1].Status != CheckedIn || s.books[1].Available != 1 {
+		t.Errorf("Expected the hold to expire and the copy to go back on the shelf. Got %+v, %+v", s.holds[1], s.items[1])
+	}
+
+	if _, err := s.Checkout(&Book{ID: 1}, &Item{}, &Patron{ID: 1}, time.Hour, DefaultFineSchedule, DefaultMaxBalance); err != nil {
+		t.Errorf("Expected the book to be free once the hold expired. Got %v", err)
+	}
+}
+
+func TestReadExpiredHolds(t *testing.T) {
+	s := NewMemoryStore()
+	s.CreateBook(&Book{Title: "Dune"})
+	s.CreateBranch(&Branch{Code: "MAIN", Name: "Main library"})
+	s.CreateItem(&Item{BookID: 1, BranchID: 1, Barcode: "ITEM-1", Condition: ConditionGood, ItemType: ItemTypeBook})
+	for _, card := range []string{"A-1001", "A-1002"} {
+		p := Patron{CardNumber: card, Name: card}
+		p.Defaults(time.Now())
+		s.CreatePatron(&p)
+	}
+
+	s.Checkout(&Book{ID: 1}, &Item{}, &Patron{ID: 1}, time.Hour, DefaultFineSchedule, DefaultMaxBalance)
+	s.PlaceHold(&Book{ID: 1}, &Patron{ID: 2}, &Branch{}, time.Hour)
+	s.Checkin(&Book{ID: 1}, &Item{}, DefaultFineSchedule)
+
+	// the pickup period runs out with nothing written since
+	h := s.holds[1]
+	expired := h.ReadyAt.Add(-time.Second)
+	h.ExpiresAt = &expired
+	s.holds[1] = h
+
+	read := Hold{ID: 1, BookID: 1}
+	if err := s.GetHold(&read); err != nil || read.Status != HoldExpired || read.Position != 0 {
+		t.Errorf("Expected the hold to read as expired. Got %+v, %v", read, err)
+	}
+	if holds, _ := s.GetHolds(&Book{ID: 1}, 0); len(holds) != 0 {
+		t.Errorf("Expected an empty queue. Got %+v", holds)
+	}
+	if holds, _ := s.GetPatronHolds(&Patron{ID: 2}, 0); len(holds) != 1 || holds[0].Status != HoldExpired {
+		t.Errorf("Expected the patron's hold to read as expired. Got %+v", holds)
+	}
+	if s.holds[1].Status != HoldReady || s.items[1].Status != OnHoldShelf {
+		t.Errorf("Expected reads to leave the queue as it is. Got %+v, %+v", s.holds[1], s.items[1])
+	}
+
+	if _, err := s.Checkout(&Book{ID: 1}, &Item{}, &Patron{ID: 1}, time.Hour, DefaultFineSchedule, DefaultMaxBalance); err != nil {
+		t.Errorf("Expected a checkout to move the queue along. Got %v", err)
+	}
+	if s.holds[1].Status != HoldExpired {
+		t.Errorf("Expected the checkout to expire the hold. Got %+v", s.holds[1])
+	}
+}
+
+func TestAdvanceHoldsToPickupBranch(t *testing.T) {
+	s := NewMemoryStore()
+	s.CreateBook(&Book{Title: "Dune"})
+	for _, code := range []string{"MAIN", "EAST"} {
+		s.CreateBranch(&Branch{Code: code, Name: code})
+	}
+	s.CreateItem(&Item{BookID: 1, BranchID: 1, Barcode: "ITEM-1", Condition: ConditionGood, ItemType: ItemTypeBook})
+	for _, card := range []string{"A-1001", "A-1002", "A-1003"} {
+		p := Patron{CardNumber: card, Name: card}
+		p.Defaults(time.Now())
+		s.CreatePatron(&p)
+	}
+
+	if _, err := s.PlaceHold(&Book{ID: 1}, &Patron{ID: 2}, &Branch{ID: 1}, time.Hour); err != ErrBookAvailable {
+		t.Errorf("Expected a hold to be refused with a copy on the shelf at its branch. Got %v", err)
+	}
+	if _, err := s.Checkout(&Book{ID: 1}, &Item{}, &Patron{ID: 1}, time.Hour, DefaultFineSchedule, DefaultMaxBalance); err != nil {
+		t.Fatalf("Expected the book to be checked out. Got %v", err)
+	}
+	s.CreateItem(&Item{BookID: 1, BranchID: 1, Barcode: "ITEM-2", Condition: ConditionGood, ItemType: ItemTypeBook})
+	s.Checkout(&Book{ID: 1}, &Item{ID: 2}, &Patron{ID: 3}, time.Hour, DefaultFineSchedule, DefaultMaxBalance)
+
+	s.PlaceHold(&Book{ID: 1}, &Patron{ID: 2}, &Branch{ID: 2}, time.Hour)
+	s.Checkin(&Book{ID: 1}, &Item{ID: 1}, DefaultFineSchedule)
+	if h := s.holds[1]; h.Status != HoldWaiting || s.items[1].Status != CheckedIn {
+		t.Fatalf("Expected a copy at another branch to stay on the shelf. Got %+v, %+v", h, s.items[1])
+	}
+
+	transfer := Transfer{ItemID: 1, ToBranchID: 2}
+	if err := s.RequestTransfer(&transfer, "for a hold"); err != nil {
+		t.Fatalf("Expected the transfer to be requested. Got %v", err)
+	}
+	s.MoveTransfer(&transfer, TransferInTransit, "")
+	if s.items[1].Status != InTransit || s.books[1].Available != 0 {
+		t.Errorf("Expected the copy to be in transit. Got %+v, %+v", s.items[1], s.books[1])
+	}
+	s.MoveTransfer(&transfer, TransferReceived, "")
+
+	h := s.holds[1]
+	if h.Status != HoldReady || h.ItemID == nil || *h.ItemID != 1 || s.items[1].BranchID != 2 {
+		t.Errorf("Expected the received copy to be set aside at the pickup branch. Got %+v, %+v", h, s.items[1])
+	}
+	if len(transfer.Events) != 3 {
+		t.Errorf("Expected each step on the transfer's trail. Got %+v", transfer.Events)
+	}
+
+	// listings at a branch follow where each copy is shelved now
+	if holds, _ := s.GetHolds(&Book{ID: 1}, 1); len(holds) != 0 {
+		t.Errorf("Expected no holds at branch 1. Got %+v", holds)
+	}
+	if holds, _ := s.GetPatronHolds(&Patron{ID: 2}, 2); len(holds) != 1 {
+		t.Errorf("Expected the hold set aside at branch 2. Got %+v", holds)
+	}
+	if loans, _ := s.GetLoans(&Book{ID: 1}, 1); len(loans) != 1 || loans[0].ItemID != 2 {
+		t.Errorf("Expected only the loan of the copy still at branch 1. Got %+v", loans)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrInvalidISBN not a well formed ISBN-10 or ISBN-13
+var ErrInvalidISBN = errors.New("invalid ISBN")
+
+// NormalizeISBN checks an ISBN-10 or ISBN-13, which may contain hyphens or
+// spaces, and returns it in its 13 digit form
+func NormalizeISBN(s string) (string, error) {
+	isbn := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(s))
+
+	switch len(isbn) {
+	case 10:
+		if !validISBN10(isbn) {
+			return "", ErrInvalidISBN
+		}
+		isbn = "978" + isbn[:9]
+		return isbn + isbn13Check(isbn), nil
+	case 13:
+		if !digits(isbn) || isbn13Check(isbn[:12]) != isbn[12:] {
+			return "", ErrInvalidISBN
+		}
+		if !strings.HasPrefix(isbn, "978") && !strings.HasPrefix(isbn, "979") {
+			return "", ErrInvalidISBN
+		}
+		return isbn, nil
+	}
+
+	return "", ErrInvalidISBN
+}
+
+// ISBN10 the 10 digit form of a normalized ISBN-13, or "" for 979 numbers
+// which have none
+func ISBN10(isbn13 string) string {
+	if len(isbn13) != 13 || !strings.HasPrefix(isbn13, "978") {
+		return ""
+	}
+
+	isbn := isbn13[3:12]
+	sum := 0
+	for i, c := range isbn {
+		sum += (10 - i) * int(c-'0')
+	}
+
+	switch check := (11 - sum%11) % 11; check {
+	case 10:
+		return isbn + "X"
+	default:
+		return isbn + string(rune('0'+check))
+	}
+}
+
+func validISBN10(isbn string) bool {
+	if !digits(isbn[:9]) {
+		return false
+	}
+
+	sum := 0
+	for i, c := range isbn {
+		v := int(c - '0')
+		if c == 'X' && i == 9 {
+			v = 10
+		} else if c < '0' || c > '9' {
+			return false
+		}
+		sum += (10 - i) * v
+	}
+
+	return sum%11 == 0
+}
+
+// isbn13Check check digit for the first 12 digits of an ISBN-13
+func isbn13Check(isbn string) string {
+	sum := 0
+	for i, c := range isbn {
+		if i%2 == 0 {
+			sum += int(c - '0')
+		} else {
+			sum += 3 * int(c-'0')
+		}
+	}
+
+	return string(rune('0' + (10-sum%10)%10))
+}
+
+func digits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestNormalizeISBN(t *testing.T) {
+	cases := []struct {
+		in, isbn13, isbn10 string
+	}{
+		{"0-261-10320-2", "9780261103207", "0261103202"},
+		{"978-0-261-10320-7", "9780261103207", "0261103202"},
+		{"080442957X", "9780804429573", "080442957X"},
+		{"080442957x", "9780804429573", "080442957X"},
+		{"979-10-90636-07-1", "9791090636071", ""},
+		{"0-261-10320-3", "", ""},
+		{"978-0-261-10320-8", "", ""},
+		{"977-0-261-10320-5", "", ""},
+		{"X261103202", "", ""},
+		{"12345", "", ""},
+	}
+
+	for _, c := range cases {
+		isbn13, err := NormalizeISBN(c.in)
+		if c.isbn13 == "" {
+			if err != ErrInvalidISBN {
+				t.Errorf("Expected %s to be invalid. Got %s", c.in, isbn13)
+			}
+			continue
+		}
+
+		if err != nil || isbn13 != c.isbn13 {
+			t.Errorf("Expected %s to normalize to %s. Got %s, %v", c.in, c.isbn13, isbn13, err)
+		}
+		if isbn10 := ISBN10(isbn13); isbn10 != c.isbn10 {
+			t.Errorf("Expected the ISBN-10 of %s to be '%s'. Got '%s'", isbn13, c.isbn10, isbn10)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrDuplicateBarcode returned when a barcode is already on another copy
+	ErrDuplicateBarcode = errors.New("barcode is already on another copy")
+
+	// ErrItemOnLoan returned when deleting a copy that is checked out
+	ErrItemOnLoan = errors.New("copy is checked out")
+
+	// ErrItemOnHold returned when deleting a copy set aside for a hold
+	ErrItemOnHold = errors.New("copy is set aside for a hold")
+
+	// ErrItemHasLoans returned when deleting a copy that has been lent,
+	// whose loans are kept as its history
+	ErrItemHasLoans = errors.New("copy has loans on record")
+
+	// ErrNoCopies returned when checking out a book without any copies
+	ErrNoCopies = errors.New("book has no copies to lend")
+
+	// ErrWhichCopy returned when checking in a book with several copies
+	// out without saying which
+	ErrWhichCopy = errors.New("book has several copies out, say which one is returned")
+)
+
+// Item a physical copy of a book, identified by the barcode on it and
+// shelved at BranchID. Its Status follows from its loans, holds and
+// transfers.
+type Item struct {
+	ID         int       `json:"id,omitempty"`
+	BookID     int       `json:"bookId,omitempty" db:"book_id"`
+	BranchID   int       `json:"branchId,omitempty" db:"branch_id"`
+	Barcode    string    `json:"barcode,omitempty"`
+	CallNumber string    `json:"callNumber,omitempty" db:"call_number"`
+	Location   string    `json:"location,omitempty"`
+	Condition  Condition `json:"condition,omitempty"`
+	ItemType   string    `json:"itemType,omitempty" db:"item_type"`
+	Status     Status    `json:"status"`
+	Book       *Book     `json:"book,omitempty" db:"-"`
+	Version    int       `json:"-"`
+}
+
+// Condition the state a copy is in
+type Condition string
+
+// valid conditions
+const (
+	ConditionNew     Condition = "new"
+	ConditionGood    Condition = "good"
+	ConditionFair    Condition = "fair"
+	ConditionPoor    Condition = "poor"
+	ConditionDamaged Condition = "damaged"
+)
+
+// Conditions every valid condition
+var Conditions = []Condition{ConditionNew, ConditionGood, ConditionFair, ConditionPoor, ConditionDamaged}
+
+// Status checked in, checked out, on the hold shelf or in transit,
+// following whether a copy has an open loan, is set aside for a ready hold
+// or is on its way to another branch
+type Status int
+
+// valid statuses
+const (
+	CheckedOut Status = iota
+	CheckedIn
+	OnHoldShelf
+	InTransit
+)
+
+// String interface returns status in english
+func (s Status) String() string {
+	names := [...]string{
+		"CheckedOut",
+		"CheckedIn",
+		"OnHoldShelf",
+		"InTransit",
+	}
+
+	if s < CheckedOut || s > InTransit {
+		return "Unknown"
+	}
+
+	return names[s]
+}
+
+const itemColumns = "id, book_id, branch_id, barcode, call_number, location, condition, item_type, status, version"
+
+// Defaults fills in what a new copy may leave out
+func (i *Item) Defaults() {
+	if i.Condition == "" {
+		i.Condition = ConditionGood
+	}
+	if i.ItemType == "" {
+		i.ItemType = ItemTypeBook
+	}
+}
+
+// uniqueBarcode reports a duplicate barcode as ErrDuplicateBarcode
+func uniqueBarcode(err error) error {
+	if e, ok := err.(*pq.Error); ok && e.Code == pqUniqueViolation {
+		return ErrDuplicateBarcode
+	}
+
+	return err
+}
+
+// GetItem returns a copy of a book
+func (i *Item) GetItem(db *sqlx.DB) error {
+	item := Item{}
+	if err := db.Get(&item, "SELECT "+itemColumns+" FROM items WHERE id=$1 AND book_id=$2", i.ID, i.BookID); err != nil {
+		return err
+	}
+
+	*i = item
+	return nil
+}
+
+// GetItemByBarcode returns the copy with i's barcode
+func (i *Item) GetItemByBarcode(db *sqlx.DB) error {
+	item := Item{}
+	if err := db.Get(&item, "SELECT "+itemColumns+" FROM items WHERE barcode=$1", i.Barcode); err != nil {
+		return err
+	}
+
+	*i = item
+	return nil
+}
+
+// GetItems returns every copy of a book
+func GetItems(db *sqlx.DB, b *Book) ([]Item, error) {
+	if err := b.GetBook(db, Expand{}, nil); err != nil {
+		return nil, err
+	}
+
+	items := []Item{}
+	if err := db.Select(&items, "SELECT "+itemColumns+" FROM items WHERE book_id=$1 ORDER BY id", b.ID); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// CreateItem adds a copy to its book, which may go straight to the first
+// hold in the book's queue that it can be picked up for
+func (i *Item) CreateItem(db *sqlx.DB) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockBook(tx, i.BookID); err != nil {
+		return err
+	}
+
+	var id int
+	err = tx.Get(&id, "INSERT INTO items (book_id, branch_id, barcode, call_number, location, condition, item_type) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id",
+		i.BookID, i.BranchID, i.Barcode, i.CallNumber, i.Location, i.Condition, i.ItemType)
+	if err != nil {
+		return uniqueBarcode(err)
+	}
+
+	if err := advanceHolds(tx, i.BookID); err != nil {
+		return err
+	}
+
+	item := Item{}
+	if err := tx.Get(&item, "SELECT "+itemColumns+" FROM items WHERE id=$1", id); err != nil {
+		return err
+	}
+
+	*i = item
+	return tx.Commit()
+}
+
+// UpdateItem updates a copy, leaving its status to its loans and holds
+// and its branch to its transfers
+func (i *Item) UpdateItem(db *sqlx.DB) error {
+	item := Item{}
+	err := db.Get(&item, "UPDATE items SET barcode=$1, call_number=$2, location=$3, condition=$4, item_type=$5, version=version+1 WHERE id=$6 AND book_id=$7 AND ($8=0 OR version=$8) RETURNING "+itemColumns,
+		i.Barcode, i.CallNumber, i.Location, i.Condition, i.ItemType, i.ID, i.BookID, i.Version)
+	if err == sql.ErrNoRows {
+		return i.missingOrStale(db)
+	}
+	if err != nil {
+		return uniqueBarcode(err)
+	}
+
+	*i = item
+	return nil
+}
+
+// DeleteItem removes a copy that has never been lent and so is neither
+// out, set aside for a hold nor in transit either
+func (i *Item) DeleteItem(db *sqlx.DB) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockBook(tx, i.BookID); err != nil {
+		return err
+	}
+
+	item := Item{}
+	if err := tx.Get(&item, "SELECT "+itemColumns+" FROM items WHERE id=$1 AND book_id=$2", i.ID, i.BookID); err != nil {
+		return err
+	}
+	if i.Version != 0 && i.Version != item.Version {
+		return ErrVersionMismatch
+	}
+	switch item.Status {
+	case CheckedOut:
+		return ErrItemOnLoan
+	case OnHoldShelf:
+		return ErrItemOnHold
+	case InTransit:
+		return ErrItemInTransit
+	}
+
+	var lent bool
+	if err := tx.Get(&lent, "SELECT EXISTS(SELECT 1 FROM loans WHERE item_id=$1)", i.ID); err != nil {
+		return err
+	}
+	if lent {
+		return ErrItemHasLoans
+	}
+
+	if _, err := tx.Exec("DELETE FROM items WHERE id=$1", i.ID); err != nil {
+		return err
+	}
+	if err := syncAvailability(tx, i.BookID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// missingOrStale explains why a conditional write to a copy matched
+// nothing, a copy of another book counts as missing
+func (i *Item) missingOrStale(db *sqlx.DB) error {
+	var exists bool
+	if err := db.Get(&exists, "SELECT EXISTS(SELECT 1 FROM items WHERE id=$1 AND book_id=$2)", i.ID, i.BookID); err != nil {
+		return err
+	}
+
+	if exists {
+		return ErrVersionMismatch
+	}
+
+	return sql.ErrNoRows
+}
+
+// syncAvailability derives the status of each of a book's copies from
+// their loans, holds and transfers, and the book's counts of copies and available
+// ones from those. Copies and the book only move on to a new version when
+// they change. The caller must hold the book's lock.
+func syncAvailability(tx *sqlx.Tx, bookID int) error {
+	_, err := tx.Exec(`UPDATE items SET status=derived.status, version=items.version+1
+		FROM (SELECT id, CASE
+			WHEN EXISTS (SELECT 1 FROM loans WHERE item_id=items.id AND returned_at IS NULL) THEN $2
+			WHEN EXISTS (SELECT 1 FROM holds WHERE item_id=items.id AND status='ready') THEN $3
+			WHEN EXISTS (SELECT 1 FROM transfers WHERE item_id=items.id AND status='in_transit') THEN $5
+			ELSE $4 END AS status
+			FROM items WHERE book_id=$1) derived
+		WHERE items.id=derived.id AND items.status <> derived.status`, bookID, CheckedOut, OnHoldShelf, CheckedIn, InTransit)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE books SET copies=counts.copies, available=counts.available, version=version+1
+		FROM (SELECT COUNT(*) AS copies, COUNT(*) FILTER (WHERE status=$2) AS available FROM items WHERE book_id=$1) counts
+		WHERE books.id=$1 AND (books.copies, books.available) IS DISTINCT FROM (counts.copies, counts.available)`, bookID, CheckedIn)
+	return err
+}
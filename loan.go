@@ -0,0 +1,226 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// DefaultLoanPeriod how long a book is lent for when App.LoanPeriod is unset
+const DefaultLoanPeriod = 21 * 24 * time.Hour
+
+var (
+	// ErrCheckedOut returned when checking out a copy that is already out,
+	// or a book whose copies all are
+	ErrCheckedOut = errors.New("book is already checked out")
+
+	// ErrNotCheckedOut returned when checking in a book that isn't out
+	ErrNotCheckedOut = errors.New("book is not checked out")
+)
+
+// Loan a copy of a book lent to a patron, open until ReturnedAt is set. A
+// copy has at most one open loan, and its Status follows from whether it
+// has one. Loans from before patrons were recorded, or of deleted
+// patrons, have no PatronID. ItemType is the copy's when it was lent.
+type Loan struct {
+	ID           int        `json:"id"`
+	BookID       int        `json:"bookId" db:"book_id"`
+	ItemID       int        `json:"itemId" db:"item_id"`
+	PatronID     *int       `json:"patronId,omitempty" db:"patron_id"`
+	CheckedOutAt time.Time  `json:"checkedOutAt" db:"checked_out_at"`
+	DueAt        time.Time  `json:"dueAt" db:"due_at"`
+	ReturnedAt   *time.Time `json:"returnedAt,omitempty" db:"returned_at"`
+	ItemType     string     `json:"itemType" db:"item_type"`
+}
+
+const loanColumns = "id, book_id, item_id, patron_id, checked_out_at, due_at, returned_at, item_type"
+
+// Checkout lends a copy of b to p for period, item when its ID is set and
+// otherwise the copy set aside for p's hold or the first one on the
+// shelf. It locks the patron's row and then the book's, so concurrent
+// checkouts of the same book queue up and a patron's concurrent checkouts
+// can't take them past their limit. A copy set aside for a hold can only
+// go to the patron who placed it, and a patron who owes more than
+// maxBalance, counting what their overdue loans are accruing under fines,
+// can't borrow. Checking a book out fulfils the patron's holds on it.
+func (b *Book) Checkout(db *sqlx.DB, item *Item, p *Patron, period time.Duration, fines FineSchedule, maxBalance int) (Loan, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return Loan{}, err
+	}
+	defer tx.Rollback()
+
+	// NO KEY UPDATE leaves the key share that a checkin charging this
+	// patron needs free, while this waits on the book lock that checkin holds
+	patron := Patron{}
+	if err := tx.Get(&patron, "SELECT "+patronColumns+" FROM patrons WHERE id=$1 FOR NO KEY UPDATE", p.ID); err != nil {
+		return Loan{}, err
+	}
+	var borrowed int
+	if err := tx.Get(&borrowed, "SELECT COUNT(*) FROM loans WHERE patron_id=$1 AND returned_at IS NULL", p.ID); err != nil {
+		return Loan{}, err
+	}
+	if err := patron.CanBorrow(time.Now(), borrowed); err != nil {
+		return Loan{}, err
+	}
+	var balance int
+	if err := tx.Get(&balance, balanceQuery, p.ID); err != nil {
+		return Loan{}, err
+	}
+	out := []Loan{}
+	if err := tx.Select(&out, "SELECT "+loanColumns+" FROM loans WHERE patron_id=$1 AND returned_at IS NULL", p.ID); err != nil {
+		return Loan{}, err
+	}
+	if balance+fines.Accruing(out, time.Now()) > maxBalance {
+		return Loan{}, ErrFinesOwed
+	}
+
+	if err := lockBook(tx, b.ID); err != nil {
+		return Loan{}, err
+	}
+	if err := advanceHolds(tx, b.ID); err != nil {
+		return Loan{}, err
+	}
+
+	itemID, err := lendableItem(tx, b.ID, item.ID, p.ID)
+	if err != nil {
+		return Loan{}, err
+	}
+	if _, err := tx.Exec("UPDATE holds SET status='fulfilled' WHERE book_id=$1 AND patron_id=$2 AND status IN ('waiting', 'ready')", b.ID, p.ID); err != nil {
+		return Loan{}, err
+	}
+
+	loan := Loan{}
+	err = tx.Get(&loan, "INSERT INTO loans (book_id, item_id, patron_id, due_at, item_type) SELECT $1, id, $3, now() + make_interval(secs => $4), item_type FROM items WHERE id=$2 RETURNING "+loanColumns,
+		b.ID, itemID, p.ID, period.Seconds())
+	if err != nil {
+		return Loan{}, err
+	}
+
+	// a copy that was set aside for p but not taken goes to the next hold
+	if err := advanceHolds(tx, b.ID); err != nil {
+		return Loan{}, err
+	}
+
+	return loan, tx.Commit()
+}
+
+// lendableItem the copy of a book patronID can take, itemID when it is
+// set. The caller must hold the book's lock with its holds advanced.
+func lendableItem(tx *sqlx.Tx, bookID, itemID, patronID int) (int, error) {
+	var held sql.NullInt64
+	if err := tx.Get(&held, "SELECT (SELECT item_id FROM holds WHERE book_id=$1 AND patron_id=$2 AND status='ready') AS held", bookID, patronID); err != nil {
+		return 0, err
+	}
+
+	if itemID != 0 {
+		var status Status
+		if err := tx.Get(&status, "SELECT status FROM items WHERE id=$1 AND book_id=$2", itemID, bookID); err != nil {
+			return 0, err
+		}
+		switch {
+		case status == CheckedOut:
+			return 0, ErrCheckedOut
+		case status == OnHoldShelf && (!held.Valid || int(held.Int64) != itemID):
+			return 0, ErrOnHold
+		case status == InTransit:
+			return 0, ErrItemInTransit
+		}
+		return itemID, nil
+	}
+
+	if held.Valid {
+		return int(held.Int64), nil
+	}
+
+	var shelved []int
+	if err := tx.Select(&shelved, "SELECT id FROM items WHERE book_id=$1 AND status=$2 ORDER BY id LIMIT 1", bookID, CheckedIn); err != nil {
+		return 0, err
+	}
+	if len(shelved) > 0 {
+		return shelved[0], nil
+	}
+
+	var counts struct {
+		Copies int
+		Held   int
+	}
+	if err := tx.Get(&counts, "SELECT COUNT(*) AS copies, COUNT(*) FILTER (WHERE status=$2) AS held FROM items WHERE book_id=$1", bookID, OnHoldShelf); err != nil {
+		return 0, err
+	}
+	switch {
+	case counts.Copies == 0:
+		return 0, ErrNoCopies
+	case counts.Held > 0:
+		return 0, ErrOnHold
+	}
+
+	return 0, ErrCheckedOut
+}
+
+// Checkin closes the open loan of a copy of b, item when its ID is set and
+// otherwise the only copy out, charging the patron any fine it ran up on
+// the schedule. The copy goes to the first hold in the book's queue.
+func (b *Book) Checkin(db *sqlx.DB, item *Item, fines FineSchedule) (Loan, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return Loan{}, err
+	}
+	defer tx.Rollback()
+
+	if err := lockBook(tx, b.ID); err != nil {
+		return Loan{}, err
+	}
+
+	if item.ID == 0 {
+		var open int
+		if err := tx.Get(&open, "SELECT COUNT(*) FROM loans WHERE book_id=$1 AND returned_at IS NULL", b.ID); err != nil {
+			return Loan{}, err
+		}
+		if open > 1 {
+			return Loan{}, ErrWhichCopy
+		}
+	}
+
+	loan := Loan{}
+	err = tx.Get(&loan, "UPDATE loans SET returned_at=now() WHERE book_id=$1 AND ($2=0 OR item_id=$2) AND returned_at IS NULL RETURNING "+loanColumns, b.ID, item.ID)
+	if err == sql.ErrNoRows {
+		return Loan{}, ErrNotCheckedOut
+	}
+	if err != nil {
+		return Loan{}, err
+	}
+	if err := chargeOverdue(tx, loan, fines); err != nil {
+		return Loan{}, err
+	}
+
+	if err := advanceHolds(tx, b.ID); err != nil {
+		return Loan{}, err
+	}
+
+	return loan, tx.Commit()
+}
+
+// lockBook locks the book's row for the rest of tx, returning
+// sql.ErrNoRows when there is no such book
+func lockBook(tx *sqlx.Tx, id int) error {
+	var locked int
+	return tx.Get(&locked, "SELECT id FROM books WHERE id=$1 FOR UPDATE", id)
+}
+
+// GetLoans returns every loan of b, the latest first, only those of copies
+// shelved at branchID when it isn't 0
+func GetLoans(db *sqlx.DB, b *Book, branchID int) ([]Loan, error) {
+	if err := b.GetBook(db, Expand{}, nil); err != nil {
+		return nil, err
+	}
+
+	loans := []Loan{}
+	if err := db.Select(&loans, "SELECT "+loanColumns+" FROM loans WHERE book_id=$1 AND "+atBranch("item_id", branchID)+" ORDER BY checked_out_at DESC, id DESC", b.ID); err != nil {
+		return nil, err
+	}
+
+	return loans, nil
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -15,8 +17,51 @@ func main() {
 		log.Fatal("Error loading .env file")
 	}
 
-	a := App{}
 	fmt.Printf("env: DATABASE_URL%v\n", os.Getenv("DATABASE_URL"))
-	a.Initialize(os.Getenv("DATABASE_URL"))
+	store, err := NewPostgresStore(os.Getenv("DATABASE_URL"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		m, err := NewMigrator(store.DB)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := MigrateCommand(m, os.Args[2:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	maxPageSize, _ := strconv.Atoi(os.Getenv("MAX_PAGE_SIZE"))
+	loanDays, _ := strconv.Atoi(os.Getenv("LOAN_PERIOD_DAYS"))
+	pickupDays, _ := strconv.Atoi(os.Getenv("HOLD_PICKUP_DAYS"))
+
+	var maxBalance *int
+	if s := os.Getenv("MAX_BALANCE"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			log.Fatalf("MAX_BALANCE must be a number of cents, got %q", s)
+		}
+		maxBalance = &n
+	}
+
+	var fines FineSchedule
+	if schedule := os.Getenv("FINE_SCHEDULE"); schedule != "" {
+		if fines, err = ParseFineSchedule(schedule); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	a := App{
+		AutoMigrate:  os.Getenv("AUTO_MIGRATE") == "true",
+		MaxPageSize:  maxPageSize,
+		LoanPeriod:   time.Duration(loanDays) * 24 * time.Hour,
+		PickupPeriod: time.Duration(pickupDays) * 24 * time.Hour,
+		Fines:        fines,
+		MaxBalance:   maxBalance,
+	}
+	a.Initialize(store)
 	a.Run(":8080")
 }
@@ -7,8 +7,11 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"reflect"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -22,22 +25,57 @@ func TestMain(m *testing.M) {
 	}
 
 	a = App{}
-	a.Initialize(os.Getenv("TEST_DATABASE_URL"))
+	a.Initialize(NewMemoryStore())
 
 	code := m.Run()
+	if code != 0 {
+		os.Exit(code)
+	}
+
+	// run the suite a second time against postgres when one is reachable
+	store, err := NewPostgresStore(os.Getenv("TEST_DATABASE_URL"))
+	if err != nil {
+		log.Printf("Skipping postgres store: %v", err)
+		os.Exit(code)
+	}
+	if err := store.Migrate(); err != nil {
+		log.Fatalf("Error migrating the test database: %v", err)
+	}
+
+	a.Initialize(store)
+	code = m.Run()
 	ClearTable()
 	os.Exit(code)
 }
 
 func ClearTable() {
-	a.DB.Exec("DELETE FROM books")
-	a.DB.Exec("ALTER SEQUENCE books_id_seq RESTART WITH 1")
-
-	a.DB.Exec("DELETE FROM authors")
-	a.DB.Exec("ALTER SEQUENCE authors_id_seq RESTART WITH 1")
-
-	a.DB.Exec("DELETE FROM publishers")
-	a.DB.Exec("ALTER SEQUENCE publishers_id_seq RESTART WITH 1")
+	switch s := a.Store.(type) {
+	case *MemoryStore:
+		a.Store = NewMemoryStore()
+	case *PostgresStore:
+		s.DB.Exec("DELETE FROM books")
+		s.DB.Exec("ALTER SEQUENCE books_id_seq RESTART WITH 1")
+		s.DB.Exec("ALTER SEQUENCE editions_id_seq RESTART WITH 1")
+		s.DB.Exec("ALTER SEQUENCE items_id_seq RESTART WITH 1")
+		s.DB.Exec("ALTER SEQUENCE loans_id_seq RESTART WITH 1")
+		s.DB.Exec("ALTER SEQUENCE holds_id_seq RESTART WITH 1")
+
+		s.DB.Exec("DELETE FROM authors")
+		s.DB.Exec("ALTER SEQUENCE authors_id_seq RESTART WITH 1")
+
+		s.DB.Exec("DELETE FROM publishers")
+		s.DB.Exec("ALTER SEQUENCE publishers_id_seq RESTART WITH 1")
+
+		// the ledger refuses row deletes, truncating skips that trigger
+		s.DB.Exec("TRUNCATE account_entries RESTART IDENTITY")
+		s.DB.Exec("DELETE FROM patrons")
+		s.DB.Exec("ALTER SEQUENCE patrons_id_seq RESTART WITH 1")
+
+		s.DB.Exec("DELETE FROM branches")
+		s.DB.Exec("ALTER SEQUENCE branches_id_seq RESTART WITH 1")
+		s.DB.Exec("ALTER SEQUENCE transfers_id_seq RESTART WITH 1")
+		s.DB.Exec("ALTER SEQUENCE transfer_events_id_seq RESTART WITH 1")
+	}
 }
 
 func TestEmptyTable(t *testing.T) {
@@ -83,18 +121,18 @@ func TestGetNonExistentBook(t *testing.T) {
 
 	CheckResponseCode(t, http.StatusNotFound, response.Code)
 
-	var m map[string]string
+	var m map[string]interface{}
 	json.Unmarshal(response.Body.Bytes(), &m)
 
-	if m["error"] != "Book not found" {
-		t.Errorf("Expected the 'error' key of the response to be set to 'Book not found'. Got '%s'", m["error"])
+	if m["detail"] != "Book not found" {
+		t.Errorf("Expected the 'detail' key of the response to be set to 'Book not found'. Got '%v'", m["detail"])
 	}
 }
 
 func TestCreateBook(t *testing.T) {
 	ClearTable()
 
-	payload := []byte(`{"title":"The Hobbit", "publishedDate":"1937-09-21T00:00:00Z", "status":0}`)
+	payload := []byte(`{"title":"The Hobbit", "publishedDate":"1937-09-21T00:00:00Z"}`)
 
 	req, _ := http.NewRequest("POST", "/book", bytes.NewBuffer(payload))
 	response := ExecuteRequest(req)
@@ -123,13 +161,38 @@ func TestGetBook(t *testing.T) {
 	CheckResponseCode(t, http.StatusOK, response.Code)
 }
 
+// AddBooks adds count books with a copy of each on the shelf
 func AddBooks(count int) {
 	if count < 1 {
 		count = 1
 	}
 
 	for i := 0; i < count; i++ {
-		a.DB.Exec("INSERT INTO books (title, published_date, rating, status) VALUES ($1, NOW(), $2, $2)", "Book "+strconv.Itoa(i), i)
+		b := Book{Title: "Book " + strconv.Itoa(i), PublishedDate: time.Now(), Rating: Rating(i)}
+		a.Store.CreateBook(&b)
+		AddItems(b.ID, 1)
+	}
+}
+
+// AddItems adds count more copies of a book, shelved at the first branch
+func AddItems(bookID, count int) {
+	if err := a.Store.GetBranch(&Branch{ID: 1}); err != nil {
+		AddBranches(1)
+	}
+
+	items, _ := a.Store.GetItems(&Book{ID: bookID})
+	for i := len(items); i < len(items)+count; i++ {
+		item := Item{BookID: bookID, BranchID: 1, Barcode: "ITEM-" + strconv.Itoa(bookID) + "-" + strconv.Itoa(i+1)}
+		item.Defaults()
+		a.Store.CreateItem(&item)
+	}
+}
+
+// AddBranches adds count more branches
+func AddBranches(count int) {
+	_, total, _ := a.Store.GetBranches(ListOptions{Count: 1})
+	for i := total; i < total+count; i++ {
+		a.Store.CreateBranch(&Branch{Code: "BR-" + strconv.Itoa(i+1), Name: "Branch " + strconv.Itoa(i+1)})
 	}
 }
 
@@ -184,11 +247,11 @@ func TestGetNonExistentAuthor(t *testing.T) {
 
 	CheckResponseCode(t, http.StatusNotFound, response.Code)
 
-	var m map[string]string
+	var m map[string]interface{}
 	json.Unmarshal(response.Body.Bytes(), &m)
 
-	if m["error"] != "Author not found" {
-		t.Errorf("Expected the 'error' key of the response to be set to 'Author not found'. Got '%s'", m["error"])
+	if m["detail"] != "Author not found" {
+		t.Errorf("Expected the 'detail' key of the response to be set to 'Author not found'. Got '%v'", m["detail"])
 	}
 }
 
@@ -219,7 +282,7 @@ func AddAuthors(count int) {
 	}
 
 	for i := 0; i < count; i++ {
-		a.DB.Exec("INSERT INTO authors (first_name, last_name, pen_name) VALUES ($1, $2, $3)", "bob", "doe", "Author "+strconv.Itoa(i))
+		a.Store.CreateAuthor(&Author{FirstName: "bob", LastName: "doe", PenName: "Author " + strconv.Itoa(i)})
 	}
 }
 
@@ -272,11 +335,11 @@ func TestGetNonExistentPublisher(t *testing.T) {
 
 	CheckResponseCode(t, http.StatusNotFound, response.Code)
 
-	var m map[string]string
+	var m map[string]interface{}
 	json.Unmarshal(response.Body.Bytes(), &m)
 
-	if m["error"] != "Publisher not found" {
-		t.Errorf("Expected the 'error' key of the response to be set to 'Publisher not found'. Got '%s'", m["error"])
+	if m["detail"] != "Publisher not found" {
+		t.Errorf("Expected the 'detail' key of the response to be set to 'Publisher not found'. Got '%v'", m["detail"])
 	}
 }
 
@@ -314,7 +377,19 @@ func AddPublishers(count int) {
 	}
 
 	for i := 0; i < count; i++ {
-		a.DB.Exec("INSERT INTO publishers (name) VALUES ($1)", "Publisher "+strconv.Itoa(i))
+		a.Store.CreatePublisher(&Publisher{Name: "Publisher " + strconv.Itoa(i)})
+	}
+}
+
+func AddPatrons(count int) {
+	if count < 1 {
+		count = 1
+	}
+
+	for i := 0; i < count; i++ {
+		p := Patron{CardNumber: "CARD-" + strconv.Itoa(i), Name: "Patron " + strconv.Itoa(i)}
+		p.Defaults(time.Now())
+		a.Store.CreatePatron(&p)
 	}
 }
 
@@ -360,3 +435,2103 @@ func TestDeletePublisher(t *testing.T) {
 	CheckResponseCode(t, http.StatusNotFound, response.Code)
 
 }
+
+func TestPaginateBooks(t *testing.T) {
+	ClearTable()
+	AddBooks(15)
+
+	req, _ := http.NewRequest("GET", "/books?start=5&count=5", nil)
+	response := ExecuteRequest(req)
+
+	CheckResponseCode(t, http.StatusOK, response.Code)
+
+	var books []map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &books)
+
+	if len(books) != 5 {
+		t.Errorf("Expected 5 books. Got %d", len(books))
+	}
+
+	if len(books) > 0 && books[0]["title"] != "Book 5" {
+		t.Errorf("Expected the page to start at 'Book 5'. Got '%v'", books[0]["title"])
+	}
+
+	if total := response.Header().Get("X-Total-Count"); total != "15" {
+		t.Errorf("Expected X-Total-Count to be 15. Got '%s'", total)
+	}
+
+	link := response.Header().Get("Link")
+	for _, rel := range []string{
+		`</books?count=5&start=0>; rel="first"`,
+		`</books?count=5&start=0>; rel="prev"`,
+		`</books?count=5&start=10>; rel="next"`,
+		`</books?count=5&start=10>; rel="last"`,
+	} {
+		if !strings.Contains(link, rel) {
+			t.Errorf("Expected Link header to contain '%s'. Got '%s'", rel, link)
+		}
+	}
+}
+
+func TestPaginateEnvelope(t *testing.T) {
+	ClearTable()
+	AddAuthors(3)
+
+	req, _ := http.NewRequest("GET", "/authors?count=2&envelope=true", nil)
+	response := ExecuteRequest(req)
+
+	CheckResponseCode(t, http.StatusOK, response.Code)
+
+	var page struct {
+		Items []Author `json:"items"`
+		Total int      `json:"total"`
+		Next  *string  `json:"next"`
+	}
+	json.Unmarshal(response.Body.Bytes(), &page)
+
+	if len(page.Items) != 2 || page.Total != 3 {
+		t.Errorf("Expected 2 of 3 authors. Got %d of %d", len(page.Items), page.Total)
+	}
+
+	if page.Next == nil || *page.Next != "/authors?count=2&envelope=true&start=2" {
+		t.Errorf("Expected a next link. Got %v", page.Next)
+	}
+}
+
+func TestMaxPageSize(t *testing.T) {
+	ClearTable()
+	AddPublishers(12)
+
+	req, _ := http.NewRequest("GET", "/publishers?count=100", nil)
+	response := ExecuteRequest(req)
+
+	var publishers []Publisher
+	json.Unmarshal(response.Body.Bytes(), &publishers)
+
+	if len(publishers) != DefaultMaxPageSize {
+		t.Errorf("Expected count to be capped at %d. Got %d", DefaultMaxPageSize, len(publishers))
+	}
+}
+
+func TestCursorPagination(t *testing.T) {
+	ClearTable()
+	AddBooks(10)
+
+	titles := []string{}
+	url := "/books?sort=-title&count=4"
+	for url != "" {
+		req, _ := http.NewRequest("GET", url, nil)
+		response := ExecuteRequest(req)
+		CheckResponseCode(t, http.StatusOK, response.Code)
+
+		var books []Book
+		json.Unmarshal(response.Body.Bytes(), &books)
+		for _, b := range books {
+			titles = append(titles, b.Title)
+		}
+
+		url = ""
+		if cursor := response.Header().Get("X-Next-Cursor"); cursor != "" {
+			url = "/books?sort=-title&count=4&cursor=" + cursor
+		}
+
+		if len(titles) > 10 {
+			t.Fatalf("Expected cursors to stop after 10 books. Got %v", titles)
+		}
+	}
+
+	if len(titles) != 10 {
+		t.Fatalf("Expected to page through 10 books. Got %d", len(titles))
+	}
+
+	for i := 1; i < len(titles); i++ {
+		if titles[i] >= titles[i-1] {
+			t.Errorf("Expected titles in descending order. Got '%s' after '%s'", titles[i], titles[i-1])
+		}
+	}
+}
+
+func TestInvalidCursor(t *testing.T) {
+	ClearTable()
+	AddBooks(3)
+
+	req, _ := http.NewRequest("GET", "/books?count=1", nil)
+	response := ExecuteRequest(req)
+	cursor := response.Header().Get("X-Next-Cursor")
+
+	for _, url := range []string{
+		"/books?cursor=not-a-cursor",
+		"/books?sort=title&cursor=" + cursor,
+		"/books?sort=rating",
+	} {
+		req, _ = http.NewRequest("GET", url, nil)
+		response = ExecuteRequest(req)
+		CheckResponseCode(t, http.StatusBadRequest, response.Code)
+	}
+}
+
+func TestListQuery(t *testing.T) {
+	opts := ListOptions{
+		Count:  5,
+		Sort:   []SortField{{Column: "title", Desc: true}, {Column: "published_date"}},
+		Cursor: &Cursor{Values: []interface{}{"Dune", "1965-08-01T00:00:00Z"}, ID: 7},
+	}
+
+	query, args := listQuery("id, title", "books", opts)
+
+	expected := "SELECT id, title FROM books WHERE (title < $1) OR (title = $1 AND published_date > $2) OR (title = $1 AND published_date = $2 AND id > $3) ORDER BY title DESC, published_date, id LIMIT $4"
+	if query != expected {
+		t.Errorf("Expected query '%s'. Got '%s'", expected, query)
+	}
+
+	if len(args) != 4 || args[2] != 7 || args[3] != 5 {
+		t.Errorf("Expected cursor values, id and limit as args. Got %v", args)
+	}
+
+	opts.Filters = []Filter{{Column: "rating", Op: "=", Values: []interface{}{2, 3}}, {Column: "author_id", Op: "=", Values: []interface{}{5}}}
+	query, args = listQuery("id, title", "books", opts)
+
+	expected = "SELECT id, title FROM books WHERE rating IN ($1, $2) AND author_id = $3 AND ((title < $4) OR (title = $4 AND published_date > $5) OR (title = $4 AND published_date = $5 AND id > $6)) ORDER BY title DESC, published_date, id LIMIT $7"
+	if query != expected {
+		t.Errorf("Expected query '%s'. Got '%s'", expected, query)
+	}
+
+	if len(args) != 7 || args[0] != 2 || args[2] != 5 {
+		t.Errorf("Expected filter values before the cursor as args. Got %v", args)
+	}
+}
+
+func TestExpandBookRelations(t *testing.T) {
+	ClearTable()
+	AddAuthors(1)
+	AddPublishers(1)
+
+	payload := []byte(`{"title":"The Hobbit", "publishedDate":"1937-09-21T00:00:00Z", "authorId":1, "publisherId":1}`)
+	req, _ := http.NewRequest("POST", "/book", bytes.NewBuffer(payload))
+	response := ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusCreated, response.Code)
+
+	req, _ = http.NewRequest("GET", "/book/1?expand=author,publisher", nil)
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+
+	var b Book
+	json.Unmarshal(response.Body.Bytes(), &b)
+
+	if b.Author == nil || b.Author.PenName != "Author 0" {
+		t.Errorf("Expected the author to be expanded. Got %+v", b.Author)
+	}
+	if b.Publisher == nil || b.Publisher.Name != "Publisher 0" {
+		t.Errorf("Expected the publisher to be expanded. Got %+v", b.Publisher)
+	}
+
+	req, _ = http.NewRequest("GET", "/books?expand=author", nil)
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+
+	var books []Book
+	json.Unmarshal(response.Body.Bytes(), &books)
+
+	if len(books) != 1 || books[0].Author == nil || books[0].Publisher != nil {
+		t.Errorf("Expected only the author to be expanded. Got %+v", books)
+	}
+
+	req, _ = http.NewRequest("GET", "/book/1", nil)
+	response = ExecuteRequest(req)
+	b = Book{}
+	json.Unmarshal(response.Body.Bytes(), &b)
+
+	if b.Author != nil || b.AuthorID == nil || *b.AuthorID != 1 {
+		t.Errorf("Expected only the author id without expand. Got %+v", b)
+	}
+
+	req, _ = http.NewRequest("GET", "/book/1?expand=reviews", nil)
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusBadRequest, response.Code)
+}
+
+func TestCreateBookMissingRelation(t *testing.T) {
+	ClearTable()
+
+	payload := []byte(`{"title":"The Hobbit", "authorId":42}`)
+	req, _ := http.NewRequest("POST", "/book", bytes.NewBuffer(payload))
+	response := ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusUnprocessableEntity, response.Code)
+
+	AddBooks(1)
+	payload = []byte(`{"title":"The Hobbit", "publisherId":42}`)
+	req, _ = http.NewRequest("PUT", "/book/1", bytes.NewBuffer(payload))
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusUnprocessableEntity, response.Code)
+}
+
+func TestPersistAllBookFields(t *testing.T) {
+	ClearTable()
+	AddAuthors(1)
+	AddPublishers(1)
+
+	payload := []byte(`{"title":"The Hobbit", "publishedDate":"1937-09-21T00:00:00Z", "rating":3, "copies":2, "authorId":1, "publisherId":1}`)
+	req, _ := http.NewRequest("POST", "/book", bytes.NewBuffer(payload))
+	response := ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusCreated, response.Code)
+
+	var created Book
+	json.Unmarshal(response.Body.Bytes(), &created)
+
+	if created.ID != 1 {
+		t.Errorf("Expected the generated id 1 in the response. Got %d", created.ID)
+	}
+
+	req, _ = http.NewRequest("GET", "/book/1", nil)
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+
+	var b Book
+	json.Unmarshal(response.Body.Bytes(), &b)
+
+	if b.Title != "The Hobbit" || b.Rating != ThreeStars || b.Copies != 0 {
+		t.Errorf("Expected every field to be stored. Got %+v", b)
+	}
+	if b.AuthorID == nil || *b.AuthorID != 1 || b.PublisherID == nil || *b.PublisherID != 1 {
+		t.Errorf("Expected author and publisher ids to be stored. Got %+v", b)
+	}
+
+	payload = []byte(`{"title":"The Hobbit", "publishedDate":"1937-09-21T00:00:00Z", "rating":1}`)
+	req, _ = http.NewRequest("PUT", "/book/1", bytes.NewBuffer(payload))
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+
+	var updated Book
+	json.Unmarshal(response.Body.Bytes(), &updated)
+
+	if updated.ID != 1 || updated.Rating != OneStar || updated.AuthorID != nil {
+		t.Errorf("Expected the stored record after update. Got %+v", updated)
+	}
+}
+
+func TestUpdateNonExistentBook(t *testing.T) {
+	ClearTable()
+
+	payload := []byte(`{"title":"test book - updated title"}`)
+	req, _ := http.NewRequest("PUT", "/book/11", bytes.NewBuffer(payload))
+	response := ExecuteRequest(req)
+
+	CheckResponseCode(t, http.StatusNotFound, response.Code)
+}
+
+func TestCreateInvalidBook(t *testing.T) {
+	ClearTable()
+
+	payload := []byte(`{"title":"", "rating":42}`)
+	req, _ := http.NewRequest("POST", "/book", bytes.NewBuffer(payload))
+	response := ExecuteRequest(req)
+
+	CheckResponseCode(t, http.StatusUnprocessableEntity, response.Code)
+
+	var m struct {
+		Errors []FieldError `json:"errors"`
+	}
+	json.Unmarshal(response.Body.Bytes(), &m)
+
+	fields := map[string]string{}
+	for _, e := range m.Errors {
+		fields[e.Field] = e.Code
+	}
+
+	for field, code := range map[string]string{"title": CodeRequired, "rating": CodeOutOfRange} {
+		if fields[field] != code {
+			t.Errorf("Expected a '%s' error on '%s'. Got %v", code, field, m.Errors)
+		}
+	}
+}
+
+func TestUpdateInvalidAuthor(t *testing.T) {
+	ClearTable()
+	AddAuthors(1)
+
+	req, _ := http.NewRequest("PUT", "/author/1", bytes.NewBuffer([]byte(`{}`)))
+	response := ExecuteRequest(req)
+
+	CheckResponseCode(t, http.StatusUnprocessableEntity, response.Code)
+}
+
+func TestProblemResponse(t *testing.T) {
+	ClearTable()
+
+	req, _ := http.NewRequest("GET", "/book/11", nil)
+	req.Header.Set(RequestIDHeader, "abc123")
+	response := ExecuteRequest(req)
+
+	CheckResponseCode(t, http.StatusNotFound, response.Code)
+
+	if ct := response.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("Expected Content-Type 'application/problem+json'. Got '%s'", ct)
+	}
+
+	var p Problem
+	json.Unmarshal(response.Body.Bytes(), &p)
+
+	expected := Problem{Type: ProblemTypeBlank, Title: "Not Found", Status: http.StatusNotFound, Detail: "Book not found", Instance: "/book/11", RequestID: "abc123"}
+	if p.Type != expected.Type || p.Title != expected.Title || p.Status != expected.Status || p.Detail != expected.Detail || p.Instance != expected.Instance || p.RequestID != expected.RequestID {
+		t.Errorf("Expected %+v. Got %+v", expected, p)
+	}
+
+	if id := response.Header().Get(RequestIDHeader); id != "abc123" {
+		t.Errorf("Expected the request id to be echoed. Got '%s'", id)
+	}
+}
+
+func TestDeleteNonExistent(t *testing.T) {
+	ClearTable()
+
+	for _, url := range []string{"/book/11", "/author/11", "/publisher/11"} {
+		req, _ := http.NewRequest("DELETE", url, nil)
+		response := ExecuteRequest(req)
+		CheckResponseCode(t, http.StatusNotFound, response.Code)
+	}
+}
+
+func AddReferencedAuthor(t *testing.T) {
+	AddAuthors(1)
+	AddPublishers(1)
+
+	payload := []byte(`{"title":"The Hobbit", "authorId":1, "publisherId":1}`)
+	req, _ := http.NewRequest("POST", "/book", bytes.NewBuffer(payload))
+	response := ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusCreated, response.Code)
+}
+
+func TestDeleteReferencedAuthor(t *testing.T) {
+	ClearTable()
+	AddReferencedAuthor(t)
+
+	req, _ := http.NewRequest("DELETE", "/author/1", nil)
+	response := ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusConflict, response.Code)
+
+	var p Problem
+	json.Unmarshal(response.Body.Bytes(), &p)
+
+	if len(p.ReferencedBy) != 1 || p.ReferencedBy[0].ID != 1 || p.ReferencedBy[0].Title != "The Hobbit" {
+		t.Errorf("Expected the referencing book to be listed. Got %+v", p.ReferencedBy)
+	}
+
+	req, _ = http.NewRequest("DELETE", "/author/1?cascade=sideways", nil)
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusBadRequest, response.Code)
+
+	req, _ = http.NewRequest("DELETE", "/author/1?cascade=detach", nil)
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/book/1", nil)
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+
+	var b Book
+	json.Unmarshal(response.Body.Bytes(), &b)
+
+	if b.AuthorID != nil {
+		t.Errorf("Expected the book to be detached from the author. Got author %d", *b.AuthorID)
+	}
+}
+
+func TestDeletePublisherCascade(t *testing.T) {
+	ClearTable()
+	AddReferencedAuthor(t)
+
+	req, _ := http.NewRequest("DELETE", "/publisher/1?cascade=delete", nil)
+	response := ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/book/1", nil)
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusNotFound, response.Code)
+}
+
+func TestMergePatchAuthor(t *testing.T) {
+	ClearTable()
+	AddAuthors(1)
+
+	payload := []byte(`{"penName":"J.R.R. Tolkien"}`)
+	req, _ := http.NewRequest("PATCH", "/author/1", bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", MergePatchType)
+	response := ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+
+	var author Author
+	json.Unmarshal(response.Body.Bytes(), &author)
+
+	if author.PenName != "J.R.R. Tolkien" || author.FirstName != "bob" || author.LastName != "doe" {
+		t.Errorf("Expected only the pen name to change. Got %+v", author)
+	}
+
+	payload = []byte(`{"firstName":null, "lastName":null, "penName":null}`)
+	req, _ = http.NewRequest("PATCH", "/author/1", bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", MergePatchType)
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusUnprocessableEntity, response.Code)
+}
+
+func TestJSONPatchBook(t *testing.T) {
+	ClearTable()
+	AddBooks(2)
+
+	payload := []byte(`[{"op":"test","path":"/title","value":"Book 1"},{"op":"replace","path":"/rating","value":3}]`)
+	req, _ := http.NewRequest("PATCH", "/book/2", bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", JSONPatchType)
+	response := ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+
+	var b Book
+	json.Unmarshal(response.Body.Bytes(), &b)
+
+	if b.Title != "Book 1" || b.Rating != ThreeStars {
+		t.Errorf("Expected the rating to change. Got %+v", b)
+	}
+
+	for _, c := range []struct {
+		contentType string
+		payload     string
+		code        int
+	}{
+		{"application/json", `{"rating":1}`, http.StatusUnsupportedMediaType},
+		{JSONPatchType, `[{"op":"test","path":"/title","value":"Book 9"}]`, http.StatusConflict},
+		{JSONPatchType, `[{"op":"replace","path":"/id","value":9}]`, http.StatusUnprocessableEntity},
+		{JSONPatchType, `[{"op":"replace","path":"/nope","value":9}]`, http.StatusBadRequest},
+		{MergePatchType, `{"rating":42}`, http.StatusUnprocessableEntity},
+		{MergePatchType, `{"authorId":42}`, http.StatusUnprocessableEntity},
+	} {
+		req, _ = http.NewRequest("PATCH", "/book/2", bytes.NewBuffer([]byte(c.payload)))
+		req.Header.Set("Content-Type", c.contentType)
+		response = ExecuteRequest(req)
+		CheckResponseCode(t, c.code, response.Code)
+	}
+
+	req, _ = http.NewRequest("PATCH", "/book/11", bytes.NewBuffer([]byte(`{}`)))
+	req.Header.Set("Content-Type", MergePatchType)
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusNotFound, response.Code)
+}
+
+func TestConditionalRequests(t *testing.T) {
+	ClearTable()
+	AddPublishers(1)
+
+	req, _ := http.NewRequest("GET", "/publisher/1", nil)
+	response := ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+
+	etag := response.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("Expected an ETag on GET")
+	}
+
+	req, _ = http.NewRequest("GET", "/publisher/1", nil)
+	req.Header.Set("If-None-Match", etag)
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusNotModified, response.Code)
+
+	req, _ = http.NewRequest("PUT", "/publisher/1", bytes.NewBuffer([]byte(`{"name":"Penguin"}`)))
+	req.Header.Set("If-Match", etag)
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+
+	updated := response.Header().Get("ETag")
+	if updated == "" || updated == etag {
+		t.Errorf("Expected a new ETag after update. Got '%s'", updated)
+	}
+
+	// the first editor's tag is now stale
+	for _, c := range []struct {
+		method, contentType, payload string
+	}{
+		{"PUT", "application/json", `{"name":"Pelican"}`},
+		{"PATCH", MergePatchType, `{"name":"Pelican"}`},
+		{"DELETE", "", ""},
+	} {
+		req, _ = http.NewRequest(c.method, "/publisher/1", bytes.NewBuffer([]byte(c.payload)))
+		req.Header.Set("Content-Type", c.contentType)
+		req.Header.Set("If-Match", etag)
+		response = ExecuteRequest(req)
+		CheckResponseCode(t, http.StatusPreconditionFailed, response.Code)
+	}
+
+	req, _ = http.NewRequest("GET", "/publisher/1", nil)
+	req.Header.Set("If-None-Match", etag)
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+
+	// a list matches when any strong tag in it is current
+	for _, header := range []string{etag + ", W/" + updated, etag + `, "x"`} {
+		req, _ = http.NewRequest("PUT", "/publisher/1", bytes.NewBuffer([]byte(`{"name":"Pelican"}`)))
+		req.Header.Set("If-Match", header)
+		response = ExecuteRequest(req)
+		CheckResponseCode(t, http.StatusPreconditionFailed, response.Code)
+	}
+
+	req, _ = http.NewRequest("PUT", "/publisher/1", bytes.NewBuffer([]byte(`{"name":"Pelican"}`)))
+	req.Header.Set("If-Match", etag+", "+updated)
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+	updated = response.Header().Get("ETag")
+
+	req, _ = http.NewRequest("DELETE", "/publisher/1", nil)
+	req.Header.Set("If-Match", updated+", "+etag)
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+}
+
+func TestRepresentationETags(t *testing.T) {
+	ClearTable()
+	AddAuthors(1)
+	AddBooks(1)
+
+	author := 1
+	b := Book{ID: 1, Title: "Book 0", PublishedDate: time.Now(), AuthorID: &author}
+	a.Store.UpdateBook(&b)
+
+	tags := map[string]string{}
+	for _, query := range []string{"", "?expand=author", "?fields=id,title", "?fields=title,id", "?fields=id,author.lastName"} {
+		req, _ := http.NewRequest("GET", "/book/1"+query, nil)
+		response := ExecuteRequest(req)
+		CheckResponseCode(t, http.StatusOK, response.Code)
+		tags[query] = response.Header().Get("ETag")
+	}
+
+	if tags[""] != ETag(b.Version) || tags["?expand=author"] == tags[""] || tags["?fields=id,title"] == tags[""] {
+		t.Errorf("Expected each representation to have its own tag. Got %v", tags)
+	}
+	if tags["?fields=id,title"] != tags["?fields=title,id"] || tags["?fields=id,author.lastName"] == tags["?expand=author"] {
+		t.Errorf("Expected tags to follow the fields asked for, not their order. Got %v", tags)
+	}
+
+	// an expanded author changing makes the book's tag stale
+	a.Store.UpdateAuthor(&Author{ID: 1, FirstName: "bob", LastName: "roe"})
+	req, _ := http.NewRequest("GET", "/book/1?expand=author", nil)
+	req.Header.Set("If-None-Match", tags["?expand=author"])
+	response := ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/book/1?expand=author", nil)
+	req.Header.Set("If-None-Match", response.Header().Get("ETag"))
+	CheckResponseCode(t, http.StatusNotModified, ExecuteRequest(req).Code)
+
+	req, _ = http.NewRequest("GET", "/author/1?fields=lastName", nil)
+	if tag := ExecuteRequest(req).Header().Get("ETag"); tag == ETag(2) {
+		t.Errorf("Expected a narrowed author to have its own tag. Got %s", tag)
+	}
+
+	// a variant's tag still names the version a write requires
+	req, _ = http.NewRequest("PUT", "/book/1", bytes.NewBufferString(`{"title":"Dune", "publishedDate":"1965-08-01T00:00:00Z"}`))
+	req.Header.Set("If-Match", tags["?expand=author"])
+	CheckResponseCode(t, http.StatusOK, ExecuteRequest(req).Code)
+}
+
+func TestContributors(t *testing.T) {
+	ClearTable()
+	AddAuthors(3)
+	AddBooks(1)
+
+	req, _ := http.NewRequest("GET", "/book/1/contributors", nil)
+	response := ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+
+	if body := response.Body.String(); body != "[]" {
+		t.Errorf("Expected an empty array. Got %s", body)
+	}
+
+	payload := []byte(`[{"authorId":2,"role":"translator"},{"authorId":3,"role":"author"},{"authorId":1,"role":"author"}]`)
+	req, _ = http.NewRequest("PUT", "/book/1/contributors", bytes.NewBuffer(payload))
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+
+	var contributors []map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &contributors)
+
+	if len(contributors) != 3 {
+		t.Fatalf("Expected 3 contributors. Got %d", len(contributors))
+	}
+	for i, c := range contributors {
+		if c["position"] != float64(i+1) {
+			t.Errorf("Expected contributor %d at position %d. Got '%v'", i, i+1, c["position"])
+		}
+	}
+
+	req, _ = http.NewRequest("GET", "/book/1", nil)
+	response = ExecuteRequest(req)
+
+	var m map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &m)
+
+	if m["authorId"] != 3.0 {
+		t.Errorf("Expected the authorId to follow the first credited author 3. Got '%v'", m["authorId"])
+	}
+
+	req, _ = http.NewRequest("GET", "/book/1/contributors?expand=author", nil)
+	response = ExecuteRequest(req)
+	json.Unmarshal(response.Body.Bytes(), &contributors)
+
+	if author, _ := contributors[0]["author"].(map[string]interface{}); author["penName"] != "Author 1" {
+		t.Errorf("Expected the first contributor to expand to 'Author 1'. Got '%v'", contributors[0]["author"])
+	}
+
+	for _, payload := range []string{
+		`[{"authorId":1,"role":"ghostwriter"}]`,
+		`[{"authorId":1,"role":"author"},{"authorId":1,"role":"author"}]`,
+		`[{"authorId":9,"role":"author"}]`,
+	} {
+		req, _ = http.NewRequest("PUT", "/book/1/contributors", bytes.NewBuffer([]byte(payload)))
+		response = ExecuteRequest(req)
+		CheckResponseCode(t, http.StatusUnprocessableEntity, response.Code)
+	}
+
+	req, _ = http.NewRequest("DELETE", "/author/1", nil)
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusConflict, response.Code)
+
+	req, _ = http.NewRequest("DELETE", "/author/2?cascade=detach", nil)
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/book/1?expand=contributors", nil)
+	response = ExecuteRequest(req)
+	json.Unmarshal(response.Body.Bytes(), &m)
+
+	if credits, _ := m["contributors"].([]interface{}); len(credits) != 2 {
+		t.Errorf("Expected 2 contributors left after detaching an author. Got '%v'", m["contributors"])
+	}
+}
+
+func TestBookAuthorCredits(t *testing.T) {
+	ClearTable()
+	AddAuthors(3)
+
+	credits := func() string {
+		req, _ := http.NewRequest("GET", "/book/1/contributors", nil)
+		response := ExecuteRequest(req)
+		CheckResponseCode(t, http.StatusOK, response.Code)
+
+		var contributors []Contributor
+		json.Unmarshal(response.Body.Bytes(), &contributors)
+
+		credited := []string{}
+		for _, c := range contributors {
+			credited = append(credited, strconv.Itoa(c.Position)+":"+strconv.Itoa(c.AuthorID)+":"+string(c.Role))
+		}
+		return strings.Join(credited, ",")
+	}
+
+	payload := []byte(`{"title":"Dune","authorId":1}`)
+	req, _ := http.NewRequest("POST", "/book", bytes.NewBuffer(payload))
+	response := ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusCreated, response.Code)
+
+	if got := credits(); got != "1:1:author" {
+		t.Errorf("Expected a created book's author to be credited. Got '%s'", got)
+	}
+
+	payload = []byte(`[{"authorId":2,"role":"translator"},{"authorId":1,"role":"author"},{"authorId":3,"role":"author"}]`)
+	req, _ = http.NewRequest("PUT", "/book/1/contributors", bytes.NewBuffer(payload))
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+
+	payload = []byte(`{"title":"Dune","authorId":3}`)
+	req, _ = http.NewRequest("PUT", "/book/1", bytes.NewBuffer(payload))
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+
+	if got := credits(); got != "1:2:translator,2:3:author" {
+		t.Errorf("Expected an updated author to take the primary author's place. Got '%s'", got)
+	}
+
+	for _, c := range []struct {
+		query string
+		count int
+	}{
+		{"author_id=2", 1},
+		{"author_id=1", 0},
+		{"author_id=1,3", 1},
+	} {
+		req, _ = http.NewRequest("GET", "/books?"+c.query, nil)
+		response = ExecuteRequest(req)
+		CheckResponseCode(t, http.StatusOK, response.Code)
+
+		var books []Book
+		json.Unmarshal(response.Body.Bytes(), &books)
+		if len(books) != c.count {
+			t.Errorf("Expected %d books for %s. Got %d", c.count, c.query, len(books))
+		}
+	}
+
+	req, _ = http.NewRequest("GET", "/books?envelope=true&facets=author", nil)
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+
+	var page struct {
+		Facets Facets `json:"facets"`
+	}
+	json.Unmarshal(response.Body.Bytes(), &page)
+	if authors := page.Facets["author"]; len(authors) != 2 {
+		t.Errorf("Expected the translator and the author in the author facet. Got %+v", authors)
+	}
+
+	payload = []byte(`{"authorId":null}`)
+	req, _ = http.NewRequest("PATCH", "/book/1", bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", MergePatchType)
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+
+	var m map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &m)
+	if _, ok := m["authorId"]; ok {
+		t.Errorf("Expected no authorId once the author is patched away. Got '%v'", m["authorId"])
+	}
+	if got := credits(); got != "1:2:translator" {
+		t.Errorf("Expected patching the author away to drop the primary author. Got '%s'", got)
+	}
+}
+
+func TestEditions(t *testing.T) {
+	ClearTable()
+	AddBooks(2)
+
+	payload := []byte(`{"isbn10":"0-261-10320-2","format":"paperback","pageCount":310,"language":"en"}`)
+	req, _ := http.NewRequest("POST", "/book/1/editions", bytes.NewBuffer(payload))
+	response := ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusCreated, response.Code)
+
+	var m map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &m)
+
+	if m["isbn13"] != "9780261103207" || m["isbn10"] != "0261103202" {
+		t.Errorf("Expected both ISBN forms to be normalized. Got '%v' and '%v'", m["isbn13"], m["isbn10"])
+	}
+
+	for _, isbn := range []string{"0261103202", "978-0-261-10320-7"} {
+		req, _ = http.NewRequest("GET", "/isbn/"+isbn, nil)
+		response = ExecuteRequest(req)
+		CheckResponseCode(t, http.StatusOK, response.Code)
+
+		json.Unmarshal(response.Body.Bytes(), &m)
+		if book, _ := m["book"].(map[string]interface{}); book["id"] != 1.0 {
+			t.Errorf("Expected %s to resolve to book 1. Got '%v'", isbn, m["book"])
+		}
+	}
+
+	req, _ = http.NewRequest("GET", "/isbn/0261103203", nil)
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusBadRequest, response.Code)
+
+	req, _ = http.NewRequest("POST", "/book/2/editions", bytes.NewBuffer([]byte(`{"isbn13":"9780261103207"}`)))
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusConflict, response.Code)
+
+	req, _ = http.NewRequest("POST", "/book/2/editions", bytes.NewBuffer([]byte(`{"isbn13":"9780261103208","pageCount":-1}`)))
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusUnprocessableEntity, response.Code)
+
+	req, _ = http.NewRequest("GET", "/book/2/editions/1", nil)
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusNotFound, response.Code)
+
+	req, _ = http.NewRequest("PUT", "/book/1/editions/1", bytes.NewBuffer([]byte(`{"isbn13":"9780261103207","format":"hardcover"}`)))
+	req.Header.Set("If-Match", `"1"`)
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/book/1/editions", nil)
+	response = ExecuteRequest(req)
+
+	var editions []map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &editions)
+
+	if len(editions) != 1 || editions[0]["format"] != "hardcover" {
+		t.Errorf("Expected a single hardcover edition. Got %v", editions)
+	}
+
+	req, _ = http.NewRequest("DELETE", "/book/1", nil)
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/isbn/9780261103207", nil)
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusNotFound, response.Code)
+}
+
+func TestSearch(t *testing.T) {
+	ClearTable()
+
+	tolkien := Author{FirstName: "John", LastName: "Tolkien"}
+	a.Store.CreateAuthor(&tolkien)
+	a.Store.CreatePublisher(&Publisher{Name: "Hobbit House"})
+	a.Store.CreateBook(&Book{Title: "The Hobbit", AuthorID: &tolkien.ID})
+	a.Store.CreateBook(&Book{Title: "The Silmarillion", AuthorID: &tolkien.ID})
+
+	req, _ := http.NewRequest("GET", "/search?q=hobbit", nil)
+	response := ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+
+	var results []map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &results)
+
+	if len(results) != 2 {
+		t.Fatalf("Expected a book and a publisher. Got %v", results)
+	}
+	if results[0]["type"] != "book" || results[0]["highlight"] != "The <mark>Hobbit</mark>" {
+		t.Errorf("Expected the highlighted book first. Got %v", results[0])
+	}
+
+	req, _ = http.NewRequest("GET", "/search?q=tolkien&type=book&count=1", nil)
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+
+	if total := response.Header().Get("X-Total-Count"); total != "2" {
+		t.Errorf("Expected both of Tolkien's books to match. Got %s", total)
+	}
+	if link := response.Header().Get("Link"); !strings.Contains(link, `rel="next"`) {
+		t.Errorf("Expected a next link. Got %s", link)
+	}
+
+	for _, query := range []string{"/search", "/search?q=hobbit&type=edition"} {
+		req, _ = http.NewRequest("GET", query, nil)
+		response = ExecuteRequest(req)
+		CheckResponseCode(t, http.StatusBadRequest, response.Code)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	ClearTable()
+
+	a.Store.CreateAuthor(&Author{FirstName: "John", LastName: "Tolkien"})
+	a.Store.CreateAuthor(&Author{FirstName: "Jane", LastName: "Austen"})
+	a.Store.CreateAuthor(&Author{PenName: "Tolkien Society"})
+	a.Store.CreateBook(&Book{Title: "The Hobbit"})
+	a.Store.CreateBook(&Book{Title: "Pride and Prejudice"})
+
+	req, _ := http.NewRequest("GET", "/authors?match=tolkein", nil)
+	response := ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+
+	var authors []map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &authors)
+
+	if len(authors) != 2 || authors[0]["lastName"] != "Tolkien" {
+		t.Errorf("Expected both Tolkiens, John first. Got %v", authors)
+	}
+
+	req, _ = http.NewRequest("GET", "/authors?match=tolkein&similarity=0.9", nil)
+	response = ExecuteRequest(req)
+	json.Unmarshal(response.Body.Bytes(), &authors)
+
+	if len(authors) != 0 {
+		t.Errorf("Expected a strict threshold to rule out misspellings. Got %v", authors)
+	}
+
+	req, _ = http.NewRequest("GET", "/books?match=hobit", nil)
+	response = ExecuteRequest(req)
+
+	var books []map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &books)
+
+	if len(books) != 1 || books[0]["title"] != "The Hobbit" {
+		t.Errorf("Expected to find The Hobbit. Got %v", books)
+	}
+
+	for _, query := range []string{"/books?match=hobit&sort=title", "/authors?match=tolkein&similarity=2"} {
+		req, _ = http.NewRequest("GET", query, nil)
+		response = ExecuteRequest(req)
+		CheckResponseCode(t, http.StatusBadRequest, response.Code)
+	}
+
+	matched, err := a.Store.MatchAuthors(&Match{Text: "Tolkein", Threshold: DefaultMatchThreshold}, 1)
+	if err != nil || len(matched) != 1 || matched[0].ID != 1 {
+		t.Errorf("Expected author 1 to be the closest match. Got %v, %v", matched, err)
+	}
+}
+
+func TestFilterBooks(t *testing.T) {
+	ClearTable()
+	AddAuthors(2)
+
+	author := 1
+	for i, b := range []Book{
+		{Title: "Dune", Rating: ThreeStars, PublishedDate: time.Date(1965, 8, 1, 0, 0, 0, 0, time.UTC), AuthorID: &author},
+		{Title: "Emma", Rating: TwoStars, PublishedDate: time.Date(1815, 12, 23, 0, 0, 0, 0, time.UTC)},
+		{Title: "Hyperion", Rating: ThreeStars, PublishedDate: time.Date(1989, 5, 26, 0, 0, 0, 0, time.UTC), AuthorID: &author},
+		{Title: "Anathem", Rating: ThreeStars, PublishedDate: time.Date(2008, 9, 9, 0, 0, 0, 0, time.UTC), AuthorID: &author},
+	} {
+		if err := a.Store.CreateBook(&b); err != nil {
+			t.Fatalf("Expected book %d to be created. Got %v", i, err)
+		}
+	}
+	AddItems(1, 1)
+	AddItems(3, 1)
+	AddItems(4, 2)
+	AddPatrons(1)
+	if _, err := a.Store.Checkout(&Book{ID: 3}, &Item{}, &Patron{ID: 1}, time.Hour, DefaultFineSchedule, DefaultMaxBalance); err != nil {
+		t.Fatalf("Expected Hyperion to be checked out. Got %v", err)
+	}
+
+	cases := []struct {
+		query  string
+		titles string
+	}{
+		{"rating=3&available=1&author_id=1&sort=-published_date,title", "Anathem,Dune"},
+		{"rating=2,3&published_after=1900-01-01&published_before=2000-01-01", "Dune,Hyperion"},
+		{"available=2", "Anathem"},
+		{"status=CheckedIn", "Dune,Anathem"},
+		{"status=0", "Emma,Hyperion"},
+		{"status=CheckedIn,CheckedOut&rating=3", "Dune,Hyperion,Anathem"},
+		{"author_id=2", ""},
+	}
+
+	for _, c := range cases {
+		req, _ := http.NewRequest("GET", "/books?"+c.query, nil)
+		response := ExecuteRequest(req)
+		CheckResponseCode(t, http.StatusOK, response.Code)
+
+		var books []Book
+		json.Unmarshal(response.Body.Bytes(), &books)
+
+		titles := []string{}
+		for _, b := range books {
+			titles = append(titles, b.Title)
+		}
+		if strings.Join(titles, ",") != c.titles {
+			t.Errorf("Expected %s to list '%s'. Got '%s'", c.query, c.titles, strings.Join(titles, ","))
+		}
+		if total := response.Header().Get("X-Total-Count"); total != strconv.Itoa(len(books)) {
+			t.Errorf("Expected %s to count only filtered books. Got %s", c.query, total)
+		}
+	}
+
+	for _, query := range []string{"rating=5", "status=Lost", "available=-1", "published_after=yesterday", "isbn=123", "author_id=", "rating=3,x"} {
+		req, _ := http.NewRequest("GET", "/books?"+query, nil)
+		response := ExecuteRequest(req)
+		CheckResponseCode(t, http.StatusBadRequest, response.Code)
+	}
+
+	req, _ := http.NewRequest("GET", "/authors?last_name=doe&pen_name=Author%201", nil)
+	response := ExecuteRequest(req)
+
+	var authors []Author
+	json.Unmarshal(response.Body.Bytes(), &authors)
+
+	if len(authors) != 1 || authors[0].ID != 2 {
+		t.Errorf("Expected only author 2. Got %v", authors)
+	}
+}
+
+func TestSparseFieldsets(t *testing.T) {
+	ClearTable()
+	AddAuthors(1)
+	AddPublishers(1)
+
+	payload := []byte(`{"title":"The Hobbit", "publishedDate":"1937-09-21T00:00:00Z", "rating":3, "authorId":1, "publisherId":1}`)
+	req, _ := http.NewRequest("POST", "/book", bytes.NewBuffer(payload))
+	CheckResponseCode(t, http.StatusCreated, ExecuteRequest(req).Code)
+
+	req, _ = http.NewRequest("GET", "/book/1?fields=id,title,author.lastName", nil)
+	response := ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+
+	var book map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &book)
+
+	author, _ := book["author"].(map[string]interface{})
+	if len(book) != 3 || book["title"] != "The Hobbit" || len(author) != 1 || author["lastName"] != "doe" {
+		t.Errorf("Expected only id, title and the author's last name. Got %v", book)
+	}
+
+	req, _ = http.NewRequest("GET", "/books?fields=title,publisher&sort=published_date", nil)
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+
+	var books []map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &books)
+
+	publisher, _ := books[0]["publisher"].(map[string]interface{})
+	if len(books) != 1 || len(books[0]) != 2 || publisher["name"] != "Publisher 0" {
+		t.Errorf("Expected titles with whole publishers. Got %v", books)
+	}
+
+	for _, path := range []string{"/authors?fields=penName", "/author/1?fields=penName", "/publishers?fields=name", "/publisher/1?fields=name"} {
+		req, _ = http.NewRequest("GET", path, nil)
+		response = ExecuteRequest(req)
+		CheckResponseCode(t, http.StatusOK, response.Code)
+
+		if body := strings.TrimSpace(response.Body.String()); strings.Contains(body, `"id"`) {
+			t.Errorf("Expected %s to leave out the id. Got %s", path, body)
+		}
+	}
+
+	for _, path := range []string{"/book/1?fields=isbn", "/books?fields=author.isbn", "/authors?fields=name", "/publisher/1?fields=id.value"} {
+		req, _ = http.NewRequest("GET", path, nil)
+		CheckResponseCode(t, http.StatusBadRequest, ExecuteRequest(req).Code)
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	ClearTable()
+
+	a.Store.CreateAuthor(&Author{FirstName: "John", LastName: "Tolkien"})
+	a.Store.CreateAuthor(&Author{FirstName: "Leo", LastName: "Tolstoy"})
+	a.Store.CreateAuthor(&Author{FirstName: "Mary", LastName: "Shelley", PenName: "Tolly"})
+	a.Store.CreatePublisher(&Publisher{Name: "Tor Books"})
+	for _, title := range []string{"The Hobbit", "Tom Sawyer", "To Kill a Mockingbird"} {
+		a.Store.CreateBook(&Book{Title: title, PublishedDate: time.Now()})
+	}
+
+	cases := []struct {
+		query    string
+		expected string
+	}{
+		{"q=tol&type=author", "Tolly,Leo Tolstoy,John Tolkien"},
+		{"q=TO&type=title,publisher", "Tor Books,Tom Sawyer,To Kill a Mockingbird"},
+		{"q=to&count=2", "Tolly,Tor Books"},
+		{"q=john%20t", "John Tolkien"},
+		{"q=the%20h&type=title", "The Hobbit"},
+		{"q=z", ""},
+	}
+
+	for _, c := range cases {
+		req, _ := http.NewRequest("GET", "/suggest?"+c.query, nil)
+		response := ExecuteRequest(req)
+		CheckResponseCode(t, http.StatusOK, response.Code)
+
+		var suggestions []Suggestion
+		json.Unmarshal(response.Body.Bytes(), &suggestions)
+
+		texts := []string{}
+		for _, s := range suggestions {
+			texts = append(texts, s.Text)
+		}
+		if strings.Join(texts, ",") != c.expected {
+			t.Errorf("Expected %s to suggest '%s'. Got '%s'", c.query, c.expected, strings.Join(texts, ","))
+		}
+	}
+
+	// books that circulate come first
+	AddPatrons(1)
+	AddItems(3, 1)
+	a.Store.Checkout(&Book{ID: 3}, &Item{}, &Patron{ID: 1}, time.Hour, DefaultFineSchedule, DefaultMaxBalance)
+	req, _ := http.NewRequest("GET", "/suggest?q=to&type=title", nil)
+	var suggestions []Suggestion
+	json.Unmarshal(ExecuteRequest(req).Body.Bytes(), &suggestions)
+	if len(suggestions) != 2 || suggestions[0].Text != "To Kill a Mockingbird" || suggestions[0].Popularity != 1 {
+		t.Errorf("Expected the lent book first. Got %v", suggestions)
+	}
+
+	for _, query := range []string{"", "q=%20", "q=to&type=isbn"} {
+		req, _ := http.NewRequest("GET", "/suggest?"+query, nil)
+		CheckResponseCode(t, http.StatusBadRequest, ExecuteRequest(req).Code)
+	}
+}
+
+func TestFacets(t *testing.T) {
+	ClearTable()
+	AddAuthors(1)
+	AddPublishers(1)
+
+	author, publisher := 1, 1
+	for i, b := range []Book{
+		{Title: "Dune", Rating: ThreeStars, PublishedDate: time.Date(1965, 8, 1, 0, 0, 0, 0, time.UTC), AuthorID: &author, PublisherID: &publisher},
+		{Title: "Dune Messiah", Rating: TwoStars, PublishedDate: time.Date(1969, 10, 15, 0, 0, 0, 0, time.UTC), AuthorID: &author},
+		{Title: "Hyperion", Rating: ThreeStars, PublishedDate: time.Date(1989, 5, 26, 0, 0, 0, 0, time.UTC)},
+	} {
+		if err := a.Store.CreateBook(&b); err != nil {
+			t.Fatalf("Expected book %d to be created. Got %v", i, err)
+		}
+	}
+	for id := 1; id <= 3; id++ {
+		AddItems(id, 1)
+	}
+	AddPatrons(1)
+	if _, err := a.Store.Checkout(&Book{ID: 2}, &Item{}, &Patron{ID: 1}, time.Hour, DefaultFineSchedule, DefaultMaxBalance); err != nil {
+		t.Fatalf("Expected Dune Messiah to be checked out. Got %v", err)
+	}
+	for _, e := range []Edition{{BookID: 1, ISBN13: "9780441172719", Language: "en"}, {BookID: 1, ISBN13: "9783453317178", Language: "de"}, {BookID: 2, ISBN13: "9780593098233", Language: "en"}} {
+		if err := a.Store.CreateEdition(&e); err != nil {
+			t.Fatalf("Expected edition %s to be created. Got %v", e.ISBN13, err)
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "/books?facets=rating,status,available,publisher,author,decade,language&count=1", nil)
+	response := ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+
+	var page struct {
+		Items  []Book
+		Total  int
+		Facets Facets
+	}
+	json.Unmarshal(response.Body.Bytes(), &page)
+
+	expected := Facets{
+		"rating":    {{Value: "3", Count: 2}, {Value: "2", Count: 1}},
+		"status":    {{Value: "CheckedIn", Count: 2}, {Value: "CheckedOut", Count: 1}},
+		"available": {{Value: "1", Count: 2}, {Value: "0", Count: 1}},
+		"publisher": {{Value: "1", Label: "Publisher 0", Count: 1}},
+		"author":    {{Value: "1", Label: "bob doe", Count: 2}},
+		"decade":    {{Value: "1960", Count: 2}, {Value: "1980", Count: 1}},
+		"language":  {{Value: "en", Count: 2}, {Value: "de", Count: 1}},
+	}
+	if len(page.Items) != 1 || page.Total != 3 || !reflect.DeepEqual(page.Facets, expected) {
+		t.Errorf("Expected one book with facets counting all three. Got %d of %d, %v", len(page.Items), page.Total, page.Facets)
+	}
+
+	req, _ = http.NewRequest("GET", "/books?facets=decade&rating=3", nil)
+	json.Unmarshal(ExecuteRequest(req).Body.Bytes(), &page)
+	if d := page.Facets["decade"]; len(d) != 2 || d[0].Count != 1 || d[1].Count != 1 {
+		t.Errorf("Expected facets to count only filtered books. Got %v", page.Facets)
+	}
+
+	req, _ = http.NewRequest("GET", "/search?q=dune&facets=rating", nil)
+	json.Unmarshal(ExecuteRequest(req).Body.Bytes(), &page)
+	if r := page.Facets["rating"]; len(r) != 2 || page.Total != 2 {
+		t.Errorf("Expected search facets over both Dune books. Got %v", page.Facets)
+	}
+
+	req, _ = http.NewRequest("GET", "/books", nil)
+	response = ExecuteRequest(req)
+	if strings.HasPrefix(response.Body.String(), "{") {
+		t.Errorf("Expected a bare array without facets. Got %s", response.Body.String())
+	}
+
+	for _, path := range []string{"/books?facets=isbn", "/search?q=dune&facets=title"} {
+		req, _ = http.NewRequest("GET", path, nil)
+		CheckResponseCode(t, http.StatusBadRequest, ExecuteRequest(req).Code)
+	}
+}
+
+// checkoutPayload the body of a checkout by the given patron
+func checkoutPayload(patron int) *bytes.Buffer {
+	return bytes.NewBufferString(`{"patronId":` + strconv.Itoa(patron) + `}`)
+}
+
+func TestCirculation(t *testing.T) {
+	ClearTable()
+	AddBooks(1)
+	AddPatrons(1)
+
+	req, _ := http.NewRequest("POST", "/book/1/checkout", checkoutPayload(1))
+	response := ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusCreated, response.Code)
+
+	var loan Loan
+	json.Unmarshal(response.Body.Bytes(), &loan)
+	if loan.BookID != 1 || loan.ItemID != 1 || loan.PatronID == nil || *loan.PatronID != 1 || loan.ReturnedAt != nil || loan.DueAt.Sub(loan.CheckedOutAt) != DefaultLoanPeriod {
+		t.Errorf("Expected an open loan for the default period. Got %+v", loan)
+	}
+
+	req, _ = http.NewRequest("GET", "/book/1", nil)
+	response = ExecuteRequest(req)
+
+	var b Book
+	json.Unmarshal(response.Body.Bytes(), &b)
+	if b.Copies != 1 || b.Available != 0 || response.Header().Get("ETag") != ETag(3) {
+		t.Errorf("Expected none of the book's copy available at version 3. Got %+v, %s", b, response.Header().Get("ETag"))
+	}
+
+	req, _ = http.NewRequest("POST", "/book/1/checkout", checkoutPayload(1))
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusConflict, response.Code)
+	if !strings.Contains(response.Body.String(), ProblemTypeCirculation) {
+		t.Errorf("Expected a circulation problem. Got %s", response.Body.String())
+	}
+
+	payload := []byte(`[{"op":"replace","path":"/available","value":1}]`)
+	req, _ = http.NewRequest("PATCH", "/book/1", bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json-patch+json")
+	CheckResponseCode(t, http.StatusUnprocessableEntity, ExecuteRequest(req).Code)
+
+	payload = []byte(`{"title":"Book 0", "publishedDate":"1937-09-21T00:00:00Z", "copies":5, "available":1}`)
+	req, _ = http.NewRequest("PUT", "/book/1", bytes.NewBuffer(payload))
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+	json.Unmarshal(response.Body.Bytes(), &b)
+	if b.Copies != 1 || b.Available != 0 {
+		t.Errorf("Expected PUT to leave availability to the copies. Got %+v", b)
+	}
+
+	req, _ = http.NewRequest("POST", "/book/1/checkin", nil)
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+	json.Unmarshal(response.Body.Bytes(), &loan)
+	if loan.ReturnedAt == nil {
+		t.Errorf("Expected the loan to be closed. Got %+v", loan)
+	}
+
+	req, _ = http.NewRequest("POST", "/book/1/checkin", nil)
+	CheckResponseCode(t, http.StatusConflict, ExecuteRequest(req).Code)
+
+	req, _ = http.NewRequest("POST", "/book/1/checkout", checkoutPayload(1))
+	CheckResponseCode(t, http.StatusCreated, ExecuteRequest(req).Code)
+
+	req, _ = http.NewRequest("GET", "/book/1/loans", nil)
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+
+	var loans []Loan
+	json.Unmarshal(response.Body.Bytes(), &loans)
+	if len(loans) != 2 || loans[0].ReturnedAt != nil || loans[1].ReturnedAt == nil {
+		t.Errorf("Expected the open loan before the returned one. Got %+v", loans)
+	}
+
+	for _, path := range []string{"/book/9/checkout", "/book/9/checkin"} {
+		req, _ = http.NewRequest("POST", path, checkoutPayload(1))
+		CheckResponseCode(t, http.StatusNotFound, ExecuteRequest(req).Code)
+	}
+}
+
+func TestConcurrentCheckouts(t *testing.T) {
+	ClearTable()
+	AddBooks(1)
+	AddPatrons(1)
+
+	codes := make(chan int, 8)
+	for i := 0; i < cap(codes); i++ {
+		go func() {
+			req, _ := http.NewRequest("POST", "/book/1/checkout", checkoutPayload(1))
+			codes <- ExecuteRequest(req).Code
+		}()
+	}
+
+	created := 0
+	for i := 0; i < cap(codes); i++ {
+		if <-codes == http.StatusCreated {
+			created++
+		}
+	}
+	if created != 1 {
+		t.Errorf("Expected exactly one checkout to succeed. Got %d", created)
+	}
+}
+
+func TestPatrons(t *testing.T) {
+	ClearTable()
+
+	payload := []byte(`{"cardNumber":"A-1001","name":"Ada Lovelace","email":"ada@example.com"}`)
+	req, _ := http.NewRequest("POST", "/patron", bytes.NewBuffer(payload))
+	response := ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusCreated, response.Code)
+
+	var p Patron
+	json.Unmarshal(response.Body.Bytes(), &p)
+	if p.ID != 1 || p.Status != PatronActive || p.BorrowingLimit != DefaultBorrowingLimit || p.ExpiresAt.Before(time.Now()) {
+		t.Errorf("Expected an active patron with the defaults. Got %+v", p)
+	}
+
+	req, _ = http.NewRequest("POST", "/patron", bytes.NewBuffer(payload))
+	CheckResponseCode(t, http.StatusConflict, ExecuteRequest(req).Code)
+
+	payload = []byte(`{"cardNumber":"A 1","name":"","email":"ada"}`)
+	req, _ = http.NewRequest("POST", "/patron", bytes.NewBuffer(payload))
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusUnprocessableEntity, response.Code)
+	for _, field := range []string{"cardNumber", "name", "email"} {
+		if !strings.Contains(response.Body.String(), `"field":"`+field+`"`) {
+			t.Errorf("Expected an error on %s. Got %s", field, response.Body.String())
+		}
+	}
+
+	payload = []byte(`{"status":"suspended"}`)
+	req, _ = http.NewRequest("PATCH", "/patron/1", bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+	json.Unmarshal(response.Body.Bytes(), &p)
+	if p.Status != PatronSuspended || p.Name != "Ada Lovelace" || response.Header().Get("ETag") != ETag(2) {
+		t.Errorf("Expected a suspended patron at version 2. Got %+v, %s", p, response.Header().Get("ETag"))
+	}
+
+	AddPatrons(2)
+	req, _ = http.NewRequest("GET", "/patrons?status=suspended", nil)
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+	var patrons []Patron
+	json.Unmarshal(response.Body.Bytes(), &patrons)
+	if len(patrons) != 1 || patrons[0].ID != 1 {
+		t.Errorf("Expected only the suspended patron. Got %+v", patrons)
+	}
+
+	req, _ = http.NewRequest("GET", "/patrons?sort=-card_number", nil)
+	response = ExecuteRequest(req)
+	json.Unmarshal(response.Body.Bytes(), &patrons)
+	if len(patrons) != 3 || patrons[0].CardNumber != "CARD-1" || patrons[2].CardNumber != "A-1001" {
+		t.Errorf("Expected patrons by card number descending. Got %+v", patrons)
+	}
+
+	req, _ = http.NewRequest("DELETE", "/patron/2", nil)
+	CheckResponseCode(t, http.StatusOK, ExecuteRequest(req).Code)
+
+	req, _ = http.NewRequest("GET", "/patron/2", nil)
+	CheckResponseCode(t, http.StatusNotFound, ExecuteRequest(req).Code)
+}
+
+func TestCheckoutRefusals(t *testing.T) {
+	ClearTable()
+	AddBooks(4)
+	AddPatrons(3)
+
+	suspended := Patron{ID: 2}
+	a.Store.GetPatron(&suspended)
+	suspended.Status = PatronSuspended
+	a.Store.UpdatePatron(&suspended)
+
+	expired := Patron{ID: 3}
+	a.Store.GetPatron(&expired)
+	expired.ExpiresAt = time.Now().Add(-time.Hour)
+	a.Store.UpdatePatron(&expired)
+
+	limited := Patron{ID: 1}
+	a.Store.GetPatron(&limited)
+	limited.BorrowingLimit = 2
+	a.Store.UpdatePatron(&limited)
+
+	for _, book := range []int{1, 2} {
+		req, _ := http.NewRequest("POST", "/book/"+strconv.Itoa(book)+"/checkout", checkoutPayload(1))
+		CheckResponseCode(t, http.StatusCreated, ExecuteRequest(req).Code)
+	}
+
+	cases := []struct {
+		patron int
+		detail string
+	}{
+		{1, ErrLoanLimit.Error()},
+		{2, ErrPatronSuspended.Error()},
+		{3, ErrPatronExpired.Error()},
+	}
+	for _, c := range cases {
+		req, _ := http.NewRequest("POST", "/book/3/checkout", checkoutPayload(c.patron))
+		response := ExecuteRequest(req)
+		CheckResponseCode(t, http.StatusConflict, response.Code)
+		if !strings.Contains(response.Body.String(), c.detail) {
+			t.Errorf("Expected patron %d to be refused with '%s'. Got %s", c.patron, c.detail, response.Body.String())
+		}
+	}
+
+	for _, body := range []string{`{}`, `{"patronId":9}`} {
+		req, _ := http.NewRequest("POST", "/book/3/checkout", bytes.NewBufferString(body))
+		CheckResponseCode(t, http.StatusUnprocessableEntity, ExecuteRequest(req).Code)
+	}
+
+	req, _ := http.NewRequest("GET", "/patron/1/loans", nil)
+	response := ExecuteRequest(req)
+	var loans []Loan
+	json.Unmarshal(response.Body.Bytes(), &loans)
+	if len(loans) != 2 {
+		t.Errorf("Expected the patron's two loans. Got %+v", loans)
+	}
+
+	req, _ = http.NewRequest("DELETE", "/patron/1", nil)
+	CheckResponseCode(t, http.StatusConflict, ExecuteRequest(req).Code)
+
+	for _, book := range []int{1, 2} {
+		req, _ = http.NewRequest("POST", "/book/"+strconv.Itoa(book)+"/checkin", nil)
+		CheckResponseCode(t, http.StatusOK, ExecuteRequest(req).Code)
+	}
+
+	req, _ = http.NewRequest("DELETE", "/patron/1", nil)
+	CheckResponseCode(t, http.StatusOK, ExecuteRequest(req).Code)
+
+	req, _ = http.NewRequest("GET", "/book/1/loans", nil)
+	var history []Loan
+	json.Unmarshal(ExecuteRequest(req).Body.Bytes(), &history)
+	if len(history) != 1 || history[0].PatronID != nil {
+		t.Errorf("Expected the loan history to outlive the patron. Got %+v", history)
+	}
+}
+
+func TestHolds(t *testing.T) {
+	ClearTable()
+	AddBooks(1)
+	AddPatrons(4)
+
+	req, _ := http.NewRequest("POST", "/book/1/holds", checkoutPayload(2))
+	CheckResponseCode(t, http.StatusConflict, ExecuteRequest(req).Code)
+
+	req, _ = http.NewRequest("POST", "/book/1/checkout", checkoutPayload(1))
+	CheckResponseCode(t, http.StatusCreated, ExecuteRequest(req).Code)
+
+	req, _ = http.NewRequest("POST", "/book/1/holds", checkoutPayload(1))
+	CheckResponseCode(t, http.StatusConflict, ExecuteRequest(req).Code)
+
+	holds := map[int]Hold{}
+	for position, patron := range []int{2, 3, 4} {
+		req, _ = http.NewRequest("POST", "/book/1/holds", checkoutPayload(patron))
+		response := ExecuteRequest(req)
+		CheckResponseCode(t, http.StatusCreated, response.Code)
+
+		var h Hold
+		json.Unmarshal(response.Body.Bytes(), &h)
+		if h.Status != HoldWaiting || h.Position != position+1 {
+			t.Errorf("Expected patron %d to wait at position %d. Got %+v", patron, position+1, h)
+		}
+		holds[patron] = h
+	}
+
+	req, _ = http.NewRequest("POST", "/book/1/holds", checkoutPayload(2))
+	CheckResponseCode(t, http.StatusConflict, ExecuteRequest(req).Code)
+
+	req, _ = http.NewRequest("DELETE", "/book/1/holds/"+strconv.Itoa(holds[3].ID), nil)
+	response := ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+	var h Hold
+	json.Unmarshal(response.Body.Bytes(), &h)
+	if h.Status != HoldCancelled || h.Position != 0 {
+		t.Errorf("Expected a cancelled hold without a position. Got %+v", h)
+	}
+
+	req, _ = http.NewRequest("DELETE", "/book/1/holds/"+strconv.Itoa(holds[3].ID), nil)
+	CheckResponseCode(t, http.StatusConflict, ExecuteRequest(req).Code)
+
+	req, _ = http.NewRequest("GET", "/book/1/holds/"+strconv.Itoa(holds[4].ID), nil)
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+	h = Hold{}
+	json.Unmarshal(response.Body.Bytes(), &h)
+	if h.Position != 2 {
+		t.Errorf("Expected the last hold to move up to position 2. Got %+v", h)
+	}
+
+	req, _ = http.NewRequest("POST", "/book/1/checkin", nil)
+	CheckResponseCode(t, http.StatusOK, ExecuteRequest(req).Code)
+
+	req, _ = http.NewRequest("GET", "/book/1/holds", nil)
+	response = ExecuteRequest(req)
+	var queue []Hold
+	json.Unmarshal(response.Body.Bytes(), &queue)
+	if len(queue) != 2 || queue[0].PatronID != 2 || queue[0].Status != HoldReady || queue[1].Status != HoldWaiting {
+		t.Fatalf("Expected the book to be ready for the first hold. Got %+v", queue)
+	}
+	if pickup := queue[0].ExpiresAt.Sub(*queue[0].ReadyAt); pickup != DefaultPickupPeriod {
+		t.Errorf("Expected the default pickup period. Got %v", pickup)
+	}
+
+	req, _ = http.NewRequest("POST", "/book/1/checkout", checkoutPayload(4))
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusConflict, response.Code)
+	if !strings.Contains(response.Body.String(), ErrOnHold.Error()) {
+		t.Errorf("Expected the book to be held for another patron. Got %s", response.Body.String())
+	}
+
+	req, _ = http.NewRequest("POST", "/book/1/checkout", checkoutPayload(2))
+	CheckResponseCode(t, http.StatusCreated, ExecuteRequest(req).Code)
+
+	req, _ = http.NewRequest("GET", "/patron/2/holds", nil)
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+	var patronHolds []Hold
+	json.Unmarshal(response.Body.Bytes(), &patronHolds)
+	if len(patronHolds) != 1 || patronHolds[0].Status != HoldFulfilled {
+		t.Errorf("Expected the patron's hold to be fulfilled. Got %+v", patronHolds)
+	}
+
+	req, _ = http.NewRequest("GET", "/book/1/holds/"+strconv.Itoa(holds[4].ID), nil)
+	h = Hold{}
+	json.Unmarshal(ExecuteRequest(req).Body.Bytes(), &h)
+	if h.Status != HoldWaiting || h.Position != 1 {
+		t.Errorf("Expected the remaining hold at the front of the queue. Got %+v", h)
+	}
+
+	for _, path := range []string{"/book/9/holds", "/book/1/holds/99", "/patron/9/holds"} {
+		req, _ = http.NewRequest("GET", path, nil)
+		CheckResponseCode(t, http.StatusNotFound, ExecuteRequest(req).Code)
+	}
+}
+
+func TestConcurrentCheckins(t *testing.T) {
+	ClearTable()
+	AddBooks(1)
+	AddPatrons(6)
+
+	req, _ := http.NewRequest("POST", "/book/1/checkout", checkoutPayload(1))
+	CheckResponseCode(t, http.StatusCreated, ExecuteRequest(req).Code)
+
+	positions := make(chan int, 5)
+	for patron := 2; patron <= 6; patron++ {
+		go func(patron int) {
+			req, _ := http.NewRequest("POST", "/book/1/holds", checkoutPayload(patron))
+			var h Hold
+			json.Unmarshal(ExecuteRequest(req).Body.Bytes(), &h)
+			positions <- h.Position
+		}(patron)
+	}
+	seen := map[int]bool{}
+	for i := 0; i < cap(positions); i++ {
+		seen[<-positions] = true
+	}
+	for position := 1; position <= 5; position++ {
+		if !seen[position] {
+			t.Errorf("Expected a hold at every position from 1 to 5. Got %v", seen)
+		}
+	}
+
+	req, _ = http.NewRequest("GET", "/book/1/holds", nil)
+	var before []Hold
+	json.Unmarshal(ExecuteRequest(req).Body.Bytes(), &before)
+
+	codes := make(chan int, 8)
+	for i := 0; i < cap(codes); i++ {
+		go func() {
+			req, _ := http.NewRequest("POST", "/book/1/checkin", nil)
+			codes <- ExecuteRequest(req).Code
+		}()
+	}
+	checkedIn := 0
+	for i := 0; i < cap(codes); i++ {
+		if <-codes == http.StatusOK {
+			checkedIn++
+		}
+	}
+	if checkedIn != 1 {
+		t.Errorf("Expected exactly one checkin to succeed. Got %d", checkedIn)
+	}
+
+	req, _ = http.NewRequest("GET", "/book/1/holds", nil)
+	var after []Hold
+	json.Unmarshal(ExecuteRequest(req).Body.Bytes(), &after)
+	if len(after) != 5 || after[0].ID != before[0].ID || after[0].Status != HoldReady {
+		t.Fatalf("Expected the first hold to be ready. Got %+v", after)
+	}
+	for i, h := range after[1:] {
+		if h.ID != before[i+1].ID || h.Status != HoldWaiting || h.Position != i+2 {
+			t.Errorf("Expected the queue to keep its order. Got %+v", after)
+		}
+	}
+}
+
+func TestAccount(t *testing.T) {
+	ClearTable()
+	AddBooks(3)
+	AddPatrons(1)
+
+	defer func(fines FineSchedule, period time.Duration, maxBalance *int) {
+		a.Fines, a.LoanPeriod, a.MaxBalance = fines, period, maxBalance
+	}(a.Fines, a.LoanPeriod, a.MaxBalance)
+	a.Fines = FineSchedule{ItemTypeBook: {DailyRate: 300, Cap: 500}}
+	a.LoanPeriod = time.Millisecond
+	maxBalance := 400
+	a.MaxBalance = &maxBalance
+
+	account := func() Account {
+		req, _ := http.NewRequest("GET", "/patron/1/account", nil)
+		response := ExecuteRequest(req)
+		CheckResponseCode(t, http.StatusOK, response.Code)
+
+		var account Account
+		json.Unmarshal(response.Body.Bytes(), &account)
+		return account
+	}
+
+	req, _ := http.NewRequest("POST", "/book/1/checkout", checkoutPayload(1))
+	CheckResponseCode(t, http.StatusCreated, ExecuteRequest(req).Code)
+	time.Sleep(10 * time.Millisecond)
+
+	if acc := account(); acc.Balance != 0 || acc.Accruing != 300 || len(acc.Overdue) != 1 || acc.Overdue[0].DaysOverdue != 1 {
+		t.Errorf("Expected the overdue loan to accrue a day's fine. Got %+v", acc)
+	}
+
+	// a fine still accruing counts against the balance a patron may owe,
+	// and a limit of nothing is kept rather than replaced by the default
+	for _, maxBalance = range []int{0, 299} {
+		req, _ = http.NewRequest("POST", "/book/2/checkout", checkoutPayload(1))
+		CheckResponseCode(t, http.StatusConflict, ExecuteRequest(req).Code)
+	}
+	maxBalance = 400
+
+	for _, book := range []string{"1", "2"} {
+		if book == "2" {
+			req, _ = http.NewRequest("POST", "/book/2/checkout", checkoutPayload(1))
+			CheckResponseCode(t, http.StatusCreated, ExecuteRequest(req).Code)
+			time.Sleep(10 * time.Millisecond)
+		}
+		req, _ = http.NewRequest("POST", "/book/"+book+"/checkin", nil)
+		CheckResponseCode(t, http.StatusOK, ExecuteRequest(req).Code)
+	}
+
+	acc := account()
+	if acc.Balance != 600 || acc.Accruing != 0 || len(acc.Entries) != 2 || acc.Entries[1].Kind != EntryCharge || *acc.Entries[1].LoanID != 1 {
+		t.Errorf("Expected a charge for each late return. Got %+v", acc)
+	}
+
+	req, _ = http.NewRequest("POST", "/book/3/checkout", checkoutPayload(1))
+	response := ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusConflict, response.Code)
+	if !strings.Contains(response.Body.String(), ErrFinesOwed.Error()) {
+		t.Errorf("Expected the checkout to be refused for fines. Got %s", response.Body.String())
+	}
+
+	req, _ = http.NewRequest("DELETE", "/patron/1", nil)
+	CheckResponseCode(t, http.StatusConflict, ExecuteRequest(req).Code)
+
+	for _, c := range []struct{ path, payload string }{
+		{"/patron/1/payments", `{"amount":700}`},
+		{"/patron/1/payments", `{"amount":0}`},
+		{"/patron/1/waivers", `{"amount":100}`},
+	} {
+		req, _ = http.NewRequest("POST", c.path, bytes.NewBufferString(c.payload))
+		CheckResponseCode(t, http.StatusUnprocessableEntity, ExecuteRequest(req).Code)
+	}
+
+	req, _ = http.NewRequest("POST", "/patron/1/payments", bytes.NewBufferString(`{"amount":250,"note":"cash"}`))
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusCreated, response.Code)
+	var payment AccountEntry
+	json.Unmarshal(response.Body.Bytes(), &payment)
+	if payment.Kind != EntryPayment || payment.Amount != 250 || payment.PatronID != 1 {
+		t.Errorf("Expected the payment to be recorded. Got %+v", payment)
+	}
+
+	req, _ = http.NewRequest("POST", "/patron/1/waivers", bytes.NewBufferString(`{"amount":100,"note":"first offence"}`))
+	CheckResponseCode(t, http.StatusCreated, ExecuteRequest(req).Code)
+
+	acc = account()
+	if acc.Balance != 250 || len(acc.Entries) != 4 || acc.Entries[0].Kind != EntryWaiver || acc.Entries[1].Kind != EntryPayment {
+		t.Errorf("Expected the payment and waiver to settle part of the balance. Got %+v", acc)
+	}
+
+	req, _ = http.NewRequest("POST", "/book/3/checkout", checkoutPayload(1))
+	CheckResponseCode(t, http.StatusCreated, ExecuteRequest(req).Code)
+
+	// a settled ledger is still kept
+	req, _ = http.NewRequest("POST", "/book/3/checkin", nil)
+	CheckResponseCode(t, http.StatusOK, ExecuteRequest(req).Code)
+	req, _ = http.NewRequest("POST", "/patron/1/payments", bytes.NewBufferString(`{"amount":`+strconv.Itoa(account().Balance)+`}`))
+	CheckResponseCode(t, http.StatusCreated, ExecuteRequest(req).Code)
+	req, _ = http.NewRequest("DELETE", "/patron/1", nil)
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusConflict, response.Code)
+	if !strings.Contains(response.Body.String(), ErrPatronHasLedger.Error()) {
+		t.Errorf("Expected the delete to be refused for the ledger. Got %s", response.Body.String())
+	}
+	if acc := account(); acc.Balance != 0 || len(acc.Entries) < 5 {
+		t.Errorf("Expected the settled ledger to be kept. Got %+v", acc)
+	}
+
+	req, _ = http.NewRequest("GET", "/patron/9/account", nil)
+	CheckResponseCode(t, http.StatusNotFound, ExecuteRequest(req).Code)
+	req, _ = http.NewRequest("POST", "/patron/9/payments", bytes.NewBufferString(`{"amount":1}`))
+	CheckResponseCode(t, http.StatusNotFound, ExecuteRequest(req).Code)
+}
+
+func TestItems(t *testing.T) {
+	ClearTable()
+	AddBooks(1)
+	AddPatrons(3)
+
+	payload := []byte(`{"barcode":"C-0002","branchId":1,"callNumber":"823.912 TOL","location":"Stacks"}`)
+	req, _ := http.NewRequest("POST", "/book/1/items", bytes.NewBuffer(payload))
+	response := ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusCreated, response.Code)
+
+	var item Item
+	json.Unmarshal(response.Body.Bytes(), &item)
+	if item.ID != 2 || item.BookID != 1 || item.BranchID != 1 || item.Condition != ConditionGood || item.ItemType != ItemTypeBook || item.Status != CheckedIn {
+		t.Errorf("Expected a copy on the shelf in good condition. Got %+v", item)
+	}
+
+	for _, payload := range []string{`{"barcode":"C-0002","branchId":1}`, `{"barcode":"C 2","branchId":1}`, `{"barcode":"C-0003","branchId":1,"condition":"mint"}`,
+		`{"barcode":"C-0003"}`, `{"barcode":"C-0003","branchId":9}`} {
+		req, _ = http.NewRequest("POST", "/book/1/items", bytes.NewBufferString(payload))
+		if code := ExecuteRequest(req).Code; code != http.StatusConflict && code != http.StatusUnprocessableEntity {
+			t.Errorf("Expected %s to be refused. Got %d", payload, code)
+		}
+	}
+	AddItems(1, 1)
+
+	req, _ = http.NewRequest("POST", "/book/1/checkout", bytes.NewBufferString(`{"patronId":1,"itemId":2}`))
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusCreated, response.Code)
+	var loan Loan
+	json.Unmarshal(response.Body.Bytes(), &loan)
+	if loan.ItemID != 2 {
+		t.Errorf("Expected the copy asked for to be lent. Got %+v", loan)
+	}
+
+	req, _ = http.NewRequest("POST", "/book/1/checkout", checkoutPayload(2))
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusCreated, response.Code)
+	json.Unmarshal(response.Body.Bytes(), &loan)
+	if loan.ItemID != 1 {
+		t.Errorf("Expected the first copy on the shelf to be lent. Got %+v", loan)
+	}
+
+	req, _ = http.NewRequest("GET", "/books", nil)
+	var books []Book
+	json.Unmarshal(ExecuteRequest(req).Body.Bytes(), &books)
+	if len(books) != 1 || books[0].Copies != 3 || books[0].Available != 1 {
+		t.Errorf("Expected 1 of 3 copies available. Got %+v", books)
+	}
+
+	req, _ = http.NewRequest("GET", "/barcode/C-0002", nil)
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+	json.Unmarshal(response.Body.Bytes(), &item)
+	if item.ID != 2 || item.Status != CheckedOut || item.Book == nil || item.Book.ID != 1 {
+		t.Errorf("Expected the lent copy along with its book. Got %+v", item)
+	}
+
+	req, _ = http.NewRequest("DELETE", "/book/1/items/2", nil)
+	CheckResponseCode(t, http.StatusConflict, ExecuteRequest(req).Code)
+
+	req, _ = http.NewRequest("POST", "/book/1/checkout", bytes.NewBufferString(`{"patronId":3,"itemId":1}`))
+	CheckResponseCode(t, http.StatusConflict, ExecuteRequest(req).Code)
+
+	for _, payload := range []string{``, `{"itemId":9}`} {
+		req, _ = http.NewRequest("POST", "/book/1/checkin", bytes.NewBufferString(payload))
+		CheckResponseCode(t, http.StatusUnprocessableEntity, ExecuteRequest(req).Code)
+	}
+	req, _ = http.NewRequest("POST", "/book/1/checkin", bytes.NewBufferString(`{"itemId":2}`))
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+	json.Unmarshal(response.Body.Bytes(), &loan)
+	if loan.ItemID != 2 || loan.ReturnedAt == nil {
+		t.Errorf("Expected the copy's loan to be closed. Got %+v", loan)
+	}
+
+	// a returned copy keeps its loans, so it stays too
+	req, _ = http.NewRequest("DELETE", "/book/1/items/2", nil)
+	CheckResponseCode(t, http.StatusConflict, ExecuteRequest(req).Code)
+
+	req, _ = http.NewRequest("GET", "/book/1/loans", nil)
+	var loans []Loan
+	json.Unmarshal(ExecuteRequest(req).Body.Bytes(), &loans)
+	if len(loans) != 2 || loans[1].ItemID != 2 {
+		t.Errorf("Expected the returned copy's loan to be kept. Got %+v", loans)
+	}
+
+	req, _ = http.NewRequest("PUT", "/book/1/items/3", bytes.NewBufferString(`{"barcode":"C-0003","location":"Reserve","condition":"fair","status":0}`))
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+	json.Unmarshal(response.Body.Bytes(), &item)
+	if item.Barcode != "C-0003" || item.Location != "Reserve" || item.Condition != ConditionFair || item.Status != CheckedIn {
+		t.Errorf("Expected the copy to be updated, leaving its status. Got %+v", item)
+	}
+
+	req, _ = http.NewRequest("DELETE", "/book/1/items/3", nil)
+	CheckResponseCode(t, http.StatusOK, ExecuteRequest(req).Code)
+
+	req, _ = http.NewRequest("GET", "/book/1/items", nil)
+	var items []Item
+	json.Unmarshal(ExecuteRequest(req).Body.Bytes(), &items)
+	if len(items) != 2 || items[0].Status != CheckedOut || items[1].Status != CheckedIn {
+		t.Errorf("Expected the lent copy and the returned one. Got %+v", items)
+	}
+
+	// a new copy goes straight to the first hold
+	req, _ = http.NewRequest("POST", "/book/1/checkout", checkoutPayload(3))
+	CheckResponseCode(t, http.StatusCreated, ExecuteRequest(req).Code)
+	req, _ = http.NewRequest("POST", "/book/1/holds", checkoutPayload(1))
+	CheckResponseCode(t, http.StatusCreated, ExecuteRequest(req).Code)
+	AddItems(1, 1)
+
+	req, _ = http.NewRequest("GET", "/book/1/holds/1", nil)
+	var h Hold
+	json.Unmarshal(ExecuteRequest(req).Body.Bytes(), &h)
+	if h.Status != HoldReady || h.ItemID == nil || *h.ItemID != 4 {
+		t.Errorf("Expected the new copy to be set aside for the hold. Got %+v", h)
+	}
+	req, _ = http.NewRequest("DELETE", "/book/1/items/4", nil)
+	CheckResponseCode(t, http.StatusConflict, ExecuteRequest(req).Code)
+
+	for _, path := range []string{"/book/9/items", "/book/1/items/9", "/barcode/NOPE"} {
+		req, _ = http.NewRequest("GET", path, nil)
+		CheckResponseCode(t, http.StatusNotFound, ExecuteRequest(req).Code)
+	}
+}
+
+func TestBranches(t *testing.T) {
+	ClearTable()
+
+	payload := []byte(`{"code":"EAST","name":"East branch","address":"1 Harbour Road"}`)
+	req, _ := http.NewRequest("POST", "/branch", bytes.NewBuffer(payload))
+	response := ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusCreated, response.Code)
+
+	var b Branch
+	json.Unmarshal(response.Body.Bytes(), &b)
+	if b.ID != 1 || b.Code != "EAST" || response.Header().Get("ETag") != ETag(1) {
+		t.Errorf("Expected the branch at version 1. Got %+v, %s", b, response.Header().Get("ETag"))
+	}
+
+	req, _ = http.NewRequest("POST", "/branch", bytes.NewBuffer(payload))
+	CheckResponseCode(t, http.StatusConflict, ExecuteRequest(req).Code)
+	req, _ = http.NewRequest("POST", "/branch", bytes.NewBufferString(`{"code":"E","name":""}`))
+	CheckResponseCode(t, http.StatusUnprocessableEntity, ExecuteRequest(req).Code)
+
+	req, _ = http.NewRequest("PUT", "/branch/1", bytes.NewBufferString(`{"code":"EAST","name":"East library"}`))
+	req.Header.Set("If-Match", ETag(1))
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+	json.Unmarshal(response.Body.Bytes(), &b)
+	if b.Name != "East library" || response.Header().Get("ETag") != ETag(2) {
+		t.Errorf("Expected the renamed branch at version 2. Got %+v, %s", b, response.Header().Get("ETag"))
+	}
+
+	AddBranches(2)
+	req, _ = http.NewRequest("GET", "/branches?sort=code&code=BR-3", nil)
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+	var branches []Branch
+	json.Unmarshal(response.Body.Bytes(), &branches)
+	if len(branches) != 1 || branches[0].ID != 3 {
+		t.Errorf("Expected the branch with code BR-3. Got %+v", branches)
+	}
+
+	// a branch with copies shelved at it stays
+	AddBooks(1)
+	req, _ = http.NewRequest("DELETE", "/branch/1", nil)
+	CheckResponseCode(t, http.StatusConflict, ExecuteRequest(req).Code)
+	req, _ = http.NewRequest("DELETE", "/branch/3", nil)
+	CheckResponseCode(t, http.StatusOK, ExecuteRequest(req).Code)
+	req, _ = http.NewRequest("GET", "/branch/3", nil)
+	CheckResponseCode(t, http.StatusNotFound, ExecuteRequest(req).Code)
+}
+
+func transferPayload(item, to int) *bytes.Buffer {
+	return bytes.NewBufferString(`{"itemId":` + strconv.Itoa(item) + `,"toBranchId":` + strconv.Itoa(to) + `,"note":"rebalancing"}`)
+}
+
+func TestTransfers(t *testing.T) {
+	ClearTable()
+	AddBooks(1)
+	AddBranches(1)
+	AddPatrons(2)
+
+	req, _ := http.NewRequest("POST", "/transfer", transferPayload(1, 2))
+	response := ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusCreated, response.Code)
+
+	var transfer Transfer
+	json.Unmarshal(response.Body.Bytes(), &transfer)
+	if transfer.ID != 1 || transfer.BookID != 1 || transfer.FromBranchID != 1 || transfer.ToBranchID != 2 || transfer.Status != TransferRequested ||
+		len(transfer.Events) != 1 || transfer.Events[0].Note != "rebalancing" {
+		t.Errorf("Expected a requested transfer from branch 1 to 2. Got %+v", transfer)
+	}
+
+	for _, refused := range []struct {
+		payload *bytes.Buffer
+		code    int
+	}{
+		{transferPayload(1, 2), http.StatusConflict},
+		{transferPayload(9, 2), http.StatusUnprocessableEntity},
+		{transferPayload(1, 9), http.StatusUnprocessableEntity},
+		{bytes.NewBufferString(`{"itemId":1}`), http.StatusUnprocessableEntity},
+	} {
+		req, _ = http.NewRequest("POST", "/transfer", refused.payload)
+		CheckResponseCode(t, refused.code, ExecuteRequest(req).Code)
+	}
+
+	// the copy is only sent once it is back on the shelf
+	req, _ = http.NewRequest("POST", "/book/1/checkout", checkoutPayload(1))
+	CheckResponseCode(t, http.StatusCreated, ExecuteRequest(req).Code)
+	req, _ = http.NewRequest("POST", "/transfer/1/dispatch", nil)
+	CheckResponseCode(t, http.StatusConflict, ExecuteRequest(req).Code)
+	req, _ = http.NewRequest("POST", "/book/1/checkin", nil)
+	CheckResponseCode(t, http.StatusOK, ExecuteRequest(req).Code)
+
+	req, _ = http.NewRequest("POST", "/transfer/1/dispatch", bytes.NewBufferString(`{"note":"van 2"}`))
+	CheckResponseCode(t, http.StatusOK, ExecuteRequest(req).Code)
+	req, _ = http.NewRequest("POST", "/transfer/1/cancel", nil)
+	CheckResponseCode(t, http.StatusConflict, ExecuteRequest(req).Code)
+
+	req, _ = http.NewRequest("GET", "/book/1/items/1", nil)
+	var item Item
+	json.Unmarshal(ExecuteRequest(req).Body.Bytes(), &item)
+	if item.Status != InTransit || item.BranchID != 1 {
+		t.Errorf("Expected the copy in transit from branch 1. Got %+v", item)
+	}
+	req, _ = http.NewRequest("POST", "/book/1/checkout", checkoutPayload(1))
+	CheckResponseCode(t, http.StatusConflict, ExecuteRequest(req).Code)
+
+	// the received copy goes to the hold waiting for it at branch 2
+	req, _ = http.NewRequest("POST", "/book/1/holds", bytes.NewBufferString(`{"patronId":2,"pickupBranchId":2}`))
+	CheckResponseCode(t, http.StatusCreated, ExecuteRequest(req).Code)
+	req, _ = http.NewRequest("POST", "/transfer/1/receive", nil)
+	req.Header.Set("If-Match", ETag(2))
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+
+	json.Unmarshal(response.Body.Bytes(), &transfer)
+	statuses := []TransferStatus{}
+	for _, e := range transfer.Events {
+		statuses = append(statuses, e.Status)
+	}
+	if transfer.Status != TransferReceived || !reflect.DeepEqual(statuses, []TransferStatus{TransferRequested, TransferInTransit, TransferReceived}) ||
+		transfer.Events[1].Note != "van 2" {
+		t.Errorf("Expected every step on the trail. Got %+v", transfer)
+	}
+
+	req, _ = http.NewRequest("GET", "/book/1/holds/1", nil)
+	var h Hold
+	json.Unmarshal(ExecuteRequest(req).Body.Bytes(), &h)
+	if h.Status != HoldReady || h.ItemID == nil || *h.ItemID != 1 || h.PickupBranchID == nil || *h.PickupBranchID != 2 {
+		t.Errorf("Expected the copy set aside at branch 2. Got %+v", h)
+	}
+
+	req, _ = http.NewRequest("POST", "/transfer", transferPayload(1, 2))
+	CheckResponseCode(t, http.StatusConflict, ExecuteRequest(req).Code)
+	req, _ = http.NewRequest("POST", "/transfer", transferPayload(1, 1))
+	CheckResponseCode(t, http.StatusCreated, ExecuteRequest(req).Code)
+
+	req, _ = http.NewRequest("GET", "/transfers?branch_id=1&status=requested", nil)
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+	var transfers []Transfer
+	json.Unmarshal(response.Body.Bytes(), &transfers)
+	if len(transfers) != 1 || transfers[0].ID != 2 || transfers[0].Events != nil {
+		t.Errorf("Expected the open transfer back to branch 1 without its trail. Got %+v", transfers)
+	}
+	req, _ = http.NewRequest("GET", "/transfers?to_branch_id=2", nil)
+	json.Unmarshal(ExecuteRequest(req).Body.Bytes(), &transfers)
+	if len(transfers) != 1 || transfers[0].ID != 1 {
+		t.Errorf("Expected the transfer to branch 2. Got %+v", transfers)
+	}
+
+	req, _ = http.NewRequest("DELETE", "/branch/1", nil)
+	CheckResponseCode(t, http.StatusConflict, ExecuteRequest(req).Code)
+	req, _ = http.NewRequest("GET", "/transfer/9", nil)
+	CheckResponseCode(t, http.StatusNotFound, ExecuteRequest(req).Code)
+
+	// a branch only a cancelled transfer names keeps it and its trail
+	AddBranches(1)
+	req, _ = http.NewRequest("POST", "/transfer/2/cancel", nil)
+	CheckResponseCode(t, http.StatusOK, ExecuteRequest(req).Code)
+	req, _ = http.NewRequest("POST", "/transfer", transferPayload(1, 3))
+	CheckResponseCode(t, http.StatusCreated, ExecuteRequest(req).Code)
+	req, _ = http.NewRequest("POST", "/transfer/3/cancel", nil)
+	CheckResponseCode(t, http.StatusOK, ExecuteRequest(req).Code)
+
+	req, _ = http.NewRequest("DELETE", "/branch/3", nil)
+	CheckResponseCode(t, http.StatusConflict, ExecuteRequest(req).Code)
+	req, _ = http.NewRequest("GET", "/transfer/3", nil)
+	json.Unmarshal(ExecuteRequest(req).Body.Bytes(), &transfer)
+	if transfer.ToBranchID != 3 || len(transfer.Events) != 2 {
+		t.Errorf("Expected the cancelled transfer to keep its trail. Got %+v", transfer)
+	}
+}
+
+func TestBranchAvailability(t *testing.T) {
+	ClearTable()
+	AddBooks(2)
+	AddBranches(1)
+
+	payload := []byte(`{"barcode":"EAST-1","branchId":2}`)
+	req, _ := http.NewRequest("POST", "/book/2/items", bytes.NewBuffer(payload))
+	CheckResponseCode(t, http.StatusCreated, ExecuteRequest(req).Code)
+
+	req, _ = http.NewRequest("GET", "/books?branch_id=2", nil)
+	response := ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+	var books []Book
+	json.Unmarshal(response.Body.Bytes(), &books)
+	if len(books) != 1 || books[0].ID != 2 || books[0].Copies != 1 || books[0].Available != 1 {
+		t.Errorf("Expected only the book with a copy at branch 2, counting that copy. Got %+v", books)
+	}
+
+	req, _ = http.NewRequest("GET", "/books?branch_id=1&available=1&facets=available", nil)
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+	var page struct {
+		Items  []Book
+		Facets Facets
+	}
+	json.Unmarshal(response.Body.Bytes(), &page)
+	if len(page.Items) != 2 || page.Items[1].Copies != 1 {
+		t.Errorf("Expected both books at branch 1 with a copy each. Got %+v", page.Items)
+	}
+	if counts := page.Facets["available"]; len(counts) != 1 || counts[0].Value != "1" || counts[0].Count != 2 {
+		t.Errorf("Expected the facet to count branch 1's copies. Got %+v", page.Facets)
+	}
+
+	req, _ = http.NewRequest("GET", "/books?branch_id=9", nil)
+	response = ExecuteRequest(req)
+	CheckResponseCode(t, http.StatusOK, response.Code)
+	if body := response.Body.String(); body != "[]" {
+		t.Errorf("Expected no books at an unknown branch. Got %s", body)
+	}
+	req, _ = http.NewRequest("GET", "/books?branch_id=east", nil)
+	CheckResponseCode(t, http.StatusBadRequest, ExecuteRequest(req).Code)
+
+	// a copy on the shelf elsewhere doesn't stop a hold picked up here
+	AddPatrons(1)
+	req, _ = http.NewRequest("POST", "/book/2/holds", bytes.NewBufferString(`{"patronId":1,"pickupBranchId":2}`))
+	CheckResponseCode(t, http.StatusConflict, ExecuteRequest(req).Code)
+	req, _ = http.NewRequest("POST", "/book/1/holds", bytes.NewBufferString(`{"patronId":1,"pickupBranchId":2}`))
+	CheckResponseCode(t, http.StatusCreated, ExecuteRequest(req).Code)
+	req, _ = http.NewRequest("POST", "/book/1/holds", bytes.NewBufferString(`{"patronId":1,"pickupBranchId":9}`))
+	CheckResponseCode(t, http.StatusUnprocessableEntity, ExecuteRequest(req).Code)
+}
+
+func TestBranchListings(t *testing.T) {
+	ClearTable()
+	AddBooks(1)
+	AddBranches(1)
+	AddPatrons(3)
+	req, _ := http.NewRequest("POST", "/book/1/items", bytes.NewBufferString(`{"barcode":"EAST-1","branchId":2}`))
+	CheckResponseCode(t, http.StatusCreated, ExecuteRequest(req).Code)
+
+	// patron 1 is late with the copy at branch 1 and was late with the one
+	// at branch 2, which is now set aside for patron 2
+	for _, item := range []int{1, 2} {
+		if _, err := a.Store.Checkout(&Book{ID: 1}, &Item{ID: item}, &Patron{ID: 1}, -48*time.Hour, DefaultFineSchedule, DefaultMaxBalance); err != nil {
+			t.Fatalf("Expected copy %d to be checked out. Got %v", item, err)
+		}
+	}
+	for _, payload := range []string{`{"patronId":2,"pickupBranchId":2}`, `{"patronId":3,"pickupBranchId":1}`} {
+		req, _ = http.NewRequest("POST", "/book/1/holds", bytes.NewBufferString(payload))
+		CheckResponseCode(t, http.StatusCreated, ExecuteRequest(req).Code)
+	}
+	if _, err := a.Store.Checkin(&Book{ID: 1}, &Item{ID: 2}, DefaultFineSchedule); err != nil {
+		t.Fatalf("Expected copy 2 to be checked in. Got %v", err)
+	}
+
+	var loans []Loan
+	for _, path := range []string{"/book/1/loans?branch_id=2", "/patron/1/loans?branch_id=2"} {
+		req, _ = http.NewRequest("GET", path, nil)
+		json.Unmarshal(ExecuteRequest(req).Body.Bytes(), &loans)
+		if len(loans) != 1 || loans[0].ItemID != 2 {
+			t.Errorf("Expected only the loan of the copy at branch 2 from %s. Got %+v", path, loans)
+		}
+	}
+
+	var holds []Hold
+	for _, c := range []struct {
+		path   string
+		patron int
+	}{
+		{"/book/1/holds?branch_id=1", 3},
+		{"/book/1/holds?branch_id=2", 2},
+		{"/patron/2/holds?branch_id=2", 2},
+	} {
+		req, _ = http.NewRequest("GET", c.path, nil)
+		json.Unmarshal(ExecuteRequest(req).Body.Bytes(), &holds)
+		if len(holds) != 1 || holds[0].PatronID != c.patron {
+			t.Errorf("Expected only patron %d's hold from %s. Got %+v", c.patron, c.path, holds)
+		}
+	}
+	req, _ = http.NewRequest("GET", "/patron/2/holds?branch_id=1", nil)
+	if body := ExecuteRequest(req).Body.String(); body != "[]" {
+		t.Errorf("Expected no holds for patron 2 at branch 1. Got %s", body)
+	}
+
+	var account Account
+	req, _ = http.NewRequest("GET", "/patron/1/account?branch_id=1", nil)
+	json.Unmarshal(ExecuteRequest(req).Body.Bytes(), &account)
+	if account.Balance == 0 || len(account.Entries) != 0 || len(account.Overdue) != 1 || account.Overdue[0].ItemID != 1 {
+		t.Errorf("Expected only the overdue copy at branch 1 with the whole balance. Got %+v", account)
+	}
+	req, _ = http.NewRequest("GET", "/patron/1/account?branch_id=2", nil)
+	json.Unmarshal(ExecuteRequest(req).Body.Bytes(), &account)
+	if len(account.Entries) != 1 || len(account.Overdue) != 0 || account.Accruing != 0 {
+		t.Errorf("Expected only the charge for the copy at branch 2. Got %+v", account)
+	}
+
+	for _, path := range []string{"/book/1/loans", "/book/1/holds", "/patron/1/loans", "/patron/1/holds", "/patron/1/account"} {
+		req, _ = http.NewRequest("GET", path+"?branch_id=east", nil)
+		CheckResponseCode(t, http.StatusBadRequest, ExecuteRequest(req).Code)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// DefaultMatchThreshold similarity a record needs to match when the
+// request doesn't say
+const DefaultMatchThreshold = 0.5
+
+// Match fuzzy match of Text, tolerating misspellings. Threshold is the
+// share of Text's trigrams, between 0 and 1, a record must contain.
+type Match struct {
+	Text      string
+	Threshold float64
+}
+
+// Matched a record similar to a Match
+type Matched struct {
+	ID         int     `json:"id"`
+	Similarity float64 `json:"similarity"`
+}
+
+// ParseMatch reads the match and similarity query parameters, returning
+// nil when there is nothing to match
+func ParseMatch(text, similarity string) (*Match, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, nil
+	}
+
+	m := &Match{Text: text, Threshold: DefaultMatchThreshold}
+	if similarity != "" {
+		threshold, err := strconv.ParseFloat(similarity, 64)
+		if err != nil || threshold < 0 || threshold > 1 {
+			return nil, fmt.Errorf("Invalid similarity '%s', expected a number between 0 and 1", similarity)
+		}
+		m.Threshold = threshold
+	}
+
+	return m, nil
+}
+
+// Matcher fuzzy matches a table with pg_trgm word similarity. Expression
+// is the text matched against, and must be indexed with gin_trgm_ops for
+// matching to be fast.
+type Matcher struct {
+	Table      string
+	Expression string
+}
+
+var (
+	authorMatcher    = Matcher{Table: "authors", Expression: "(coalesce(first_name, '') || ' ' || coalesce(last_name, '') || ' ' || coalesce(pen_name, ''))"}
+	bookMatcher      = Matcher{Table: "books", Expression: "title"}
+	publisherMatcher = Matcher{Table: "publishers", Expression: "name"}
+)
+
+// begin starts a read transaction in which the <% operator uses m's
+// threshold
+func (mt Matcher) begin(db *sqlx.DB, m *Match) (*sqlx.Tx, error) {
+	tx, err := beginRead(db)
+	if err != nil {
+		return nil, err
+	}
+
+	threshold := strconv.FormatFloat(m.Threshold, 'f', -1, 64)
+	if _, err := tx.Exec("SELECT set_config('pg_trgm.word_similarity_threshold', $1, true)", threshold); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// Match returns up to limit records similar to m, most similar first. It
+// is meant for anything that needs to find likely duplicates, such as
+// imports.
+func (mt Matcher) Match(db *sqlx.DB, m *Match, limit int) ([]Matched, error) {
+	tx, err := mt.begin(db, m)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	matched := []Matched{}
+	err = tx.Select(&matched, fmt.Sprintf("SELECT id, word_similarity($1, %[1]s) AS similarity FROM %[2]s WHERE $1 <%% %[1]s ORDER BY similarity DESC, id LIMIT $2", mt.Expression, mt.Table), m.Text, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return matched, nil
+}
+
+// List selects a page of the records of source similar to opts.Match and
+// passing opts.Filters into dest, most similar first, and returns the total
+// number that match.
+// source must be the matcher's table or a subquery aliased to it.
+func (mt Matcher) List(db *sqlx.DB, dest interface{}, columns, source string, opts ListOptions) (int, error) {
+	tx, err := mt.begin(db, opts.Match)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	return mt.list(tx, dest, columns, source, opts)
+}
+
+// list is List within a transaction begun by begin
+func (mt Matcher) list(tx *sqlx.Tx, dest interface{}, columns, source string, opts ListOptions) (int, error) {
+	count, countArgs := mt.filterQuery("COUNT(*)", opts)
+
+	var total int
+	if err := tx.Get(&total, count, countArgs...); err != nil {
+		return 0, err
+	}
+
+	args := []interface{}{opts.Match.Text}
+	arg := placeholders(&args)
+	query := fmt.Sprintf("SELECT %s FROM %s%s ORDER BY word_similarity($1, %s) DESC, id LIMIT %s OFFSET %s",
+		columns, source, where(mt.conditions(opts, arg)), mt.Expression, arg(opts.Count), arg(opts.Start))
+	if err := tx.Select(dest, query, args...); err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
+
+// filterQuery selects columns from the records of the matcher's table
+// similar to opts.Match and passing opts.Filters
+func (mt Matcher) filterQuery(columns string, opts ListOptions) (string, []interface{}) {
+	args := []interface{}{opts.Match.Text}
+	conditions := mt.conditions(opts, placeholders(&args))
+
+	return "SELECT " + columns + " FROM " + mt.Table + where(conditions), args
+}
+
+// conditions SQL conditions for opts, with the match text as $1
+func (mt Matcher) conditions(opts ListOptions, arg func(v interface{}) string) []string {
+	return append([]string{"$1 <% " + mt.Expression}, filterConditions(opts.Filters, arg)...)
+}
+
+// Similarity share of the trigrams of query found among the trigrams of
+// text's words. It approximates pg_trgm's word_similarity for records held
+// in process.
+func Similarity(query, text string) float64 {
+	q := trigrams(query)
+	if len(q) == 0 {
+		return 0
+	}
+
+	t := trigrams(text)
+	shared := 0
+	for trigram := range q {
+		if t[trigram] {
+			shared++
+		}
+	}
+
+	return float64(shared) / float64(len(q))
+}
+
+// trigrams of each lower cased word of s, padded the way pg_trgm pads them
+func trigrams(s string) map[string]bool {
+	set := map[string]bool{}
+	for _, word := range searchWords(s) {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			set[string(padded[i:i+3])] = true
+		}
+	}
+
+	return set
+}
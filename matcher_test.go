@@ -0,0 +1,41 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSimilarity(t *testing.T) {
+	cases := []struct {
+		query, text string
+		similarity  float64
+	}{
+		{"Tolkien", "John Ronald Reuel Tolkien", 1},
+		{"tolkein", "John Tolkien", 0.5},
+		{"hobbit", "The Hobbit", 1},
+		{"austen", "Bob Smith", 0},
+		{"", "John Tolkien", 0},
+	}
+
+	for _, c := range cases {
+		if s := Similarity(c.query, c.text); math.Abs(s-c.similarity) > 0.001 {
+			t.Errorf("Expected '%s' to be %.2f similar to '%s'. Got %.2f", c.query, c.similarity, c.text, s)
+		}
+	}
+}
+
+func TestParseMatch(t *testing.T) {
+	if m, err := ParseMatch("  ", "0.9"); m != nil || err != nil {
+		t.Errorf("Expected no match for blank text. Got %v, %v", m, err)
+	}
+
+	if m, err := ParseMatch("tolkein", ""); err != nil || m.Threshold != DefaultMatchThreshold {
+		t.Errorf("Expected the default threshold. Got %v, %v", m, err)
+	}
+
+	for _, similarity := range []string{"high", "-0.1", "1.5"} {
+		if _, err := ParseMatch("tolkein", similarity); err == nil {
+			t.Errorf("Expected similarity '%s' to be rejected", similarity)
+		}
+	}
+}
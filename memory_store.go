@@ -0,0 +1,2134 @@
+package main
+
+import (
+	"database/sql"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// MemoryStore keeps everything in process, mostly useful for tests
+type MemoryStore struct {
+	mu sync.RWMutex
+
+	books      map[int]Book
+	authors    map[int]Author
+	publishers map[int]Publisher
+
+	// contributors by book id, in credit order
+	contributors map[int][]Contributor
+	editions     map[int]Edition
+	items        map[int]Item
+	branches     map[int]Branch
+	transfers    map[int]Transfer
+	loans        map[int]Loan
+	patrons      map[int]Patron
+	holds        map[int]Hold
+	entries      map[int]AccountEntry
+
+	nextBookID      int
+	nextAuthorID    int
+	nextPublisherID int
+	nextEditionID   int
+	nextItemID      int
+	nextBranchID    int
+	nextTransferID  int
+	nextEventID     int
+	nextLoanID      int
+	nextPatronID    int
+	nextHoldID      int
+	nextEntryID     int
+}
+
+// NewMemoryStore returns an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		books:           map[int]Book{},
+		authors:         map[int]Author{},
+		publishers:      map[int]Publisher{},
+		contributors:    map[int][]Contributor{},
+		editions:        map[int]Edition{},
+		items:           map[int]Item{},
+		branches:        map[int]Branch{},
+		transfers:       map[int]Transfer{},
+		loans:           map[int]Loan{},
+		patrons:         map[int]Patron{},
+		holds:           map[int]Hold{},
+		entries:         map[int]AccountEntry{},
+		nextBookID:      1,
+		nextAuthorID:    1,
+		nextPublisherID: 1,
+		nextEditionID:   1,
+		nextItemID:      1,
+		nextBranchID:    1,
+		nextTransferID:  1,
+		nextEventID:     1,
+		nextLoanID:      1,
+		nextPatronID:    1,
+		nextHoldID:      1,
+		nextEntryID:     1,
+	}
+}
+
+// GetBook returns a book
+func (s *MemoryStore) GetBook(b *Book, expand Expand, columns []string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	book, ok := s.books[b.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	*b = s.expandBook(book, expand)
+
+	return nil
+}
+
+// GetBooks returns a page of books, the total count and any facets in
+// opts.Facets. With opts.Branch set only books with copies at that branch
+// are listed, counting only those copies.
+func (s *MemoryStore) GetBooks(opts ListOptions) ([]Book, int, Facets, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	books := []Book{}
+	for _, b := range s.books {
+		if opts.Branch != 0 {
+			b.Copies, b.Available = 0, 0
+			for _, i := range s.items {
+				if i.BookID == b.ID && i.BranchID == opts.Branch {
+					b.Copies++
+					if i.Status == CheckedIn {
+						b.Available++
+					}
+				}
+			}
+			if b.Copies == 0 {
+				continue
+			}
+		}
+		b.Contributors = s.contributors[b.ID]
+		books = append(books, b)
+	}
+	item := func(i int) Sortable { return books[i] }
+	text := func(i int) string { return books[i].Title }
+	indexes, total := pageIndexes(len(books), item, text, opts)
+
+	page := []Book{}
+	for _, i := range indexes {
+		page = append(page, s.expandBook(stripBook(books[i]), opts.Expand))
+	}
+
+	counter := facetCounter{}
+	if len(opts.Facets) > 0 {
+		matched, _ := matchIndexes(len(books), item, text, opts)
+		for _, i := range matched {
+			s.countFacets(counter, books[i])
+		}
+	}
+
+	return page, total, counter.facets(opts.Facets), nil
+}
+
+// countFacets tallies every facet value of b
+func (s *MemoryStore) countFacets(counter facetCounter, b Book) {
+	counter.add("rating", strconv.Itoa(int(b.Rating)), "")
+	counter.add("status", b.Status().String(), "")
+	counter.add("available", strconv.Itoa(b.Available), "")
+	counter.add("decade", strconv.Itoa(b.PublishedDate.Year()/10*10), "")
+	if b.PublisherID != nil {
+		if p, ok := s.publishers[*b.PublisherID]; ok {
+			counter.add("publisher", strconv.Itoa(p.ID), p.Name)
+		}
+	}
+	credited := map[int]bool{}
+	for _, c := range s.contributors[b.ID] {
+		if a, ok := s.authors[c.AuthorID]; ok && !credited[a.ID] {
+			credited[a.ID] = true
+			counter.add("author", strconv.Itoa(a.ID), strings.TrimSpace(a.FirstName+" "+a.LastName))
+		}
+	}
+
+	languages := map[string]bool{}
+	for _, e := range s.editions {
+		if e.BookID == b.ID && !languages[e.Language] {
+			languages[e.Language] = true
+			counter.add("language", e.Language, "")
+		}
+	}
+}
+
+// CreateBook inserts a new book
+func (s *MemoryStore) CreateBook(b *Book) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b.ID = s.nextBookID
+	s.nextBookID++
+	b.Version = 1
+	b.Copies, b.Available = 0, 0
+	b.AuthorID = s.creditAuthor(b.ID, b.AuthorID)
+	s.books[b.ID] = stripBook(*b)
+	*b = s.books[b.ID]
+
+	return nil
+}
+
+// UpdateBook updates a book
+func (s *MemoryStore) UpdateBook(b *Book) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.books[b.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if b.Version != 0 && b.Version != stored.Version {
+		return ErrVersionMismatch
+	}
+	b.Version = stored.Version + 1
+	b.Copies, b.Available = stored.Copies, stored.Available
+	b.AuthorID = s.creditAuthor(b.ID, b.AuthorID)
+	s.books[b.ID] = stripBook(*b)
+	*b = s.books[b.ID]
+
+	return nil
+}
+
+// PatchBook updates only the given columns of a book
+func (s *MemoryStore) PatchBook(b *Book, columns []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.books[b.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if b.Version != 0 && b.Version != stored.Version {
+		return ErrVersionMismatch
+	}
+	copyColumns(&stored, b, columns)
+	for _, column := range columns {
+		if column == "author_id" {
+			stored.AuthorID = s.creditAuthor(b.ID, stored.AuthorID)
+		}
+	}
+	if len(columns) > 0 {
+		stored.Version++
+	}
+	s.books[b.ID] = stored
+	*b = stored
+
+	return nil
+}
+
+// creditAuthor credits authorID as the primary author of a book and
+// returns the author id that follows from its contributors, the caller
+// must hold s.mu for writing
+func (s *MemoryStore) creditAuthor(bookID int, authorID *int) *int {
+	s.contributors[bookID] = withPrimaryAuthor(bookID, s.contributors[bookID], authorID)
+	return primaryAuthor(s.contributors[bookID])
+}
+
+// DeleteBook removes a book
+func (s *MemoryStore) DeleteBook(b *Book) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.books[b.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if b.Version != 0 && b.Version != stored.Version {
+		return ErrVersionMismatch
+	}
+	s.deleteBook(b.ID)
+
+	return nil
+}
+
+// deleteBook removes a book along with its contributors, editions, copies,
+// transfers, loans and holds, the caller must hold s.mu for writing
+func (s *MemoryStore) deleteBook(id int) {
+	delete(s.books, id)
+	delete(s.contributors, id)
+	for editionID, e := range s.editions {
+		if e.BookID == id {
+			delete(s.editions, editionID)
+		}
+	}
+	for itemID, i := range s.items {
+		if i.BookID == id {
+			delete(s.items, itemID)
+		}
+	}
+	for transferID, t := range s.transfers {
+		if t.BookID == id {
+			delete(s.transfers, transferID)
+		}
+	}
+	for loanID, l := range s.loans {
+		if l.BookID == id {
+			delete(s.loans, loanID)
+		}
+	}
+	for holdID, h := range s.holds {
+		if h.BookID == id {
+			delete(s.holds, holdID)
+		}
+	}
+}
+
+// Checkout lends a copy of a book to a patron for period, the given copy
+// or else the one set aside for them or the first on the shelf, unless
+// they owe more than maxBalance counting what their overdue loans are
+// accruing under fines
+func (s *MemoryStore) Checkout(b *Book, item *Item, p *Patron, period time.Duration, fines FineSchedule, maxBalance int) (Loan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	patron, ok := s.patrons[p.ID]
+	if !ok {
+		return Loan{}, sql.ErrNoRows
+	}
+	now := time.Now()
+	if err := patron.CanBorrow(now, s.borrowed(p.ID)); err != nil {
+		return Loan{}, err
+	}
+	out := s.latestLoans(func(l Loan) bool { return l.PatronID != nil && *l.PatronID == p.ID && l.ReturnedAt == nil })
+	if s.balance(p.ID)+fines.Accruing(out, now) > maxBalance {
+		return Loan{}, ErrFinesOwed
+	}
+
+	if _, ok := s.books[b.ID]; !ok {
+		return Loan{}, sql.ErrNoRows
+	}
+	s.advanceHolds(b.ID, now)
+
+	lent, err := s.lendableItem(b.ID, item.ID, p.ID)
+	if err != nil {
+		return Loan{}, err
+	}
+	for id, h := range s.holds {
+		if h.BookID == b.ID && h.PatronID == p.ID && h.Active() {
+			h.Status = HoldFulfilled
+			s.holds[id] = h
+		}
+	}
+
+	patronID := p.ID
+	loan := Loan{ID: s.nextLoanID, BookID: b.ID, ItemID: lent.ID, PatronID: &patronID, CheckedOutAt: now, DueAt: now.Add(period), ItemType: lent.ItemType}
+	s.nextLoanID++
+	s.loans[loan.ID] = loan
+	s.advanceHolds(b.ID, now)
+
+	return loan, nil
+}
+
+// lendableItem the copy of a book a patron can take, itemID when it is
+// set. The caller must hold s.mu with the book's holds advanced.
+func (s *MemoryStore) lendableItem(bookID, itemID, patronID int) (Item, error) {
+	held := 0
+	for _, h := range s.holds {
+		if h.BookID == bookID && h.PatronID == patronID && h.Status == HoldReady && h.ItemID != nil {
+			held = *h.ItemID
+		}
+	}
+
+	if itemID != 0 {
+		item, ok := s.items[itemID]
+		switch {
+		case !ok || item.BookID != bookID:
+			return Item{}, sql.ErrNoRows
+		case item.Status == CheckedOut:
+			return Item{}, ErrCheckedOut
+		case item.Status == OnHoldShelf && held != itemID:
+			return Item{}, ErrOnHold
+		case item.Status == InTransit:
+			return Item{}, ErrItemInTransit
+		}
+		return item, nil
+	}
+
+	if held != 0 {
+		return s.items[held], nil
+	}
+
+	items := s.bookItems(bookID)
+	onHold := false
+	for _, i := range items {
+		if i.Status == CheckedIn {
+			return i, nil
+		}
+		onHold = onHold || i.Status == OnHoldShelf
+	}
+	switch {
+	case len(items) == 0:
+		return Item{}, ErrNoCopies
+	case onHold:
+		return Item{}, ErrOnHold
+	}
+
+	return Item{}, ErrCheckedOut
+}
+
+// Checkin takes a copy of a book back, the given one or else the only one
+// out, charging any fine it ran up
+func (s *MemoryStore) Checkin(b *Book, item *Item, fines FineSchedule) (Loan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.books[b.ID]; !ok {
+		return Loan{}, sql.ErrNoRows
+	}
+	open := []Loan{}
+	for _, l := range s.loans {
+		if l.BookID == b.ID && l.ReturnedAt == nil && (item.ID == 0 || l.ItemID == item.ID) {
+			open = append(open, l)
+		}
+	}
+	switch {
+	case len(open) == 0:
+		return Loan{}, ErrNotCheckedOut
+	case len(open) > 1:
+		return Loan{}, ErrWhichCopy
+	}
+
+	loan := open[0]
+	now := time.Now()
+	loan.ReturnedAt = &now
+	s.loans[loan.ID] = loan
+	if days, fine := fines.Fine(loan, now); fine > 0 && loan.PatronID != nil {
+		loanID := loan.ID
+		s.addEntry(AccountEntry{PatronID: *loan.PatronID, Kind: EntryCharge, Amount: fine, LoanID: &loanID, Note: overdueNote(days)})
+	}
+	s.advanceHolds(b.ID, now)
+
+	return loan, nil
+}
+
+// borrowed number of books a patron has out
+func (s *MemoryStore) borrowed(patronID int) int {
+	n := 0
+	for _, l := range s.loans {
+		if l.PatronID != nil && *l.PatronID == patronID && l.ReturnedAt == nil {
+			n++
+		}
+	}
+
+	return n
+}
+
+// bookItems a book's copies in the order they were added
+func (s *MemoryStore) bookItems(bookID int) []Item {
+	items := []Item{}
+	for _, i := range s.items {
+		if i.BookID == bookID {
+			items = append(items, i)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+
+	return items
+}
+
+// itemStatus derives a copy's status from its open loan, any ready hold
+// it is set aside for and any transfer it is on its way in
+func (s *MemoryStore) itemStatus(itemID int) Status {
+	for _, l := range s.loans {
+		if l.ItemID == itemID && l.ReturnedAt == nil {
+			return CheckedOut
+		}
+	}
+	for _, h := range s.holds {
+		if h.Status == HoldReady && h.ItemID != nil && *h.ItemID == itemID {
+			return OnHoldShelf
+		}
+	}
+	for _, t := range s.transfers {
+		if t.ItemID == itemID && t.Status == TransferInTransit {
+			return InTransit
+		}
+	}
+
+	return CheckedIn
+}
+
+// syncAvailability derives the status of a book's copies and the book's
+// counts of copies and available ones, moving each on to a new version
+// when it changes. The caller must hold s.mu for writing.
+func (s *MemoryStore) syncAvailability(bookID int) {
+	copies, available := 0, 0
+	for _, i := range s.bookItems(bookID) {
+		if status := s.itemStatus(i.ID); status != i.Status {
+			i.Status = status
+			i.Version++
+			s.items[i.ID] = i
+		}
+		copies++
+		if i.Status == CheckedIn {
+			available++
+		}
+	}
+
+	book, ok := s.books[bookID]
+	if ok && (book.Copies != copies || book.Available != available) {
+		book.Copies, book.Available = copies, available
+		book.Version++
+		s.books[bookID] = book
+	}
+}
+
+// GetLoans returns a book's loans, the latest first
+func (s *MemoryStore) GetLoans(b *Book, branchID int) ([]Loan, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.books[b.ID]; !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	return s.latestLoans(func(l Loan) bool { return l.BookID == b.ID && s.atBranch(l.ItemID, branchID) }), nil
+}
+
+// atBranch reports whether a copy is shelved at a branch, always true when
+// branchID is 0. The caller must hold s.mu.
+func (s *MemoryStore) atBranch(itemID, branchID int) bool {
+	return branchID == 0 || s.items[itemID].BranchID == branchID
+}
+
+// holdAtBranch reports whether a hold's copy is set aside at a branch, or
+// it is still waiting to be picked up there, always true when branchID is
+// 0. The caller must hold s.mu.
+func (s *MemoryStore) holdAtBranch(h Hold, branchID int) bool {
+	if h.ItemID != nil {
+		return s.atBranch(*h.ItemID, branchID)
+	}
+
+	return branchID == 0 || h.PickupBranchID != nil && *h.PickupBranchID == branchID
+}
+
+// latestLoans the loans matching include, the latest first
+func (s *MemoryStore) latestLoans(include func(l Loan) bool) []Loan {
+	loans := []Loan{}
+	for _, l := range s.loans {
+		if include(l) {
+			loans = append(loans, l)
+		}
+	}
+	sort.Slice(loans, func(i, j int) bool {
+		if !loans[i].CheckedOutAt.Equal(loans[j].CheckedOutAt) {
+			return loans[i].CheckedOutAt.After(loans[j].CheckedOutAt)
+		}
+		return loans[i].ID > loans[j].ID
+	})
+
+	return loans
+}
+
+// GetContributors returns the contributors of a book in credit order
+func (s *MemoryStore) GetContributors(b *Book, expand Expand) ([]Contributor, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	book, ok := s.books[b.ID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	*b = book
+
+	return s.bookContributors(b.ID, expand), nil
+}
+
+// SetContributors replaces the contributors of a book
+func (s *MemoryStore) SetContributors(b *Book, contributors []Contributor) ([]Contributor, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.books[b.ID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	if b.Version != 0 && b.Version != stored.Version {
+		return nil, ErrVersionMismatch
+	}
+
+	credits := []Contributor{}
+	for i, c := range contributors {
+		credits = append(credits, Contributor{BookID: b.ID, AuthorID: c.AuthorID, Role: c.Role, Position: i + 1})
+	}
+	s.contributors[b.ID] = credits
+
+	stored.AuthorID = primaryAuthor(credits)
+	stored.Version++
+	s.books[b.ID] = stored
+	*b = stored
+
+	return s.bookContributors(b.ID, Expand{}), nil
+}
+
+// bookContributors copies the contributors of a book, the caller must hold s.mu
+func (s *MemoryStore) bookContributors(id int, expand Expand) []Contributor {
+	contributors := []Contributor{}
+	for _, c := range s.contributors[id] {
+		if author, ok := s.authors[c.AuthorID]; ok && expand["author"] {
+			c.Author = &author
+		}
+		contributors = append(contributors, c)
+	}
+
+	return contributors
+}
+
+// GetEdition returns an edition of a book
+func (s *MemoryStore) GetEdition(e *Edition) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	edition, ok := s.editions[e.ID]
+	if !ok || edition.BookID != e.BookID {
+		return sql.ErrNoRows
+	}
+	*e = edition
+
+	return nil
+}
+
+// GetEditionByISBN returns the edition with an ISBN
+func (s *MemoryStore) GetEditionByISBN(e *Edition) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, edition := range s.editions {
+		if edition.ISBN13 == e.ISBN13 {
+			*e = edition
+			return nil
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+// GetEditions returns every edition of a book
+func (s *MemoryStore) GetEditions(b *Book) ([]Edition, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	book, ok := s.books[b.ID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	*b = book
+
+	editions := []Edition{}
+	for _, e := range s.editions {
+		if e.BookID == b.ID {
+			editions = append(editions, e)
+		}
+	}
+	sort.Slice(editions, func(i, j int) bool {
+		if !editions[i].PublishedDate.Equal(editions[j].PublishedDate) {
+			return editions[i].PublishedDate.Before(editions[j].PublishedDate)
+		}
+		return editions[i].ID < editions[j].ID
+	})
+
+	return editions, nil
+}
+
+// CreateEdition inserts a new edition
+func (s *MemoryStore) CreateEdition(e *Edition) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.isbnTaken(e) {
+		return ErrDuplicateISBN
+	}
+
+	e.ID = s.nextEditionID
+	s.nextEditionID++
+	e.Version = 1
+	e.ISBN10 = ISBN10(e.ISBN13)
+	e.Book = nil
+	s.editions[e.ID] = *e
+
+	return nil
+}
+
+// UpdateEdition updates an edition
+func (s *MemoryStore) UpdateEdition(e *Edition) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.editions[e.ID]
+	if !ok || stored.BookID != e.BookID {
+		return sql.ErrNoRows
+	}
+	if e.Version != 0 && e.Version != stored.Version {
+		return ErrVersionMismatch
+	}
+	if s.isbnTaken(e) {
+		return ErrDuplicateISBN
+	}
+
+	e.Version = stored.Version + 1
+	e.ISBN10 = ISBN10(e.ISBN13)
+	e.Book = nil
+	s.editions[e.ID] = *e
+
+	return nil
+}
+
+// DeleteEdition removes an edition
+func (s *MemoryStore) DeleteEdition(e *Edition) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.editions[e.ID]
+	if !ok || stored.BookID != e.BookID {
+		return sql.ErrNoRows
+	}
+	if e.Version != 0 && e.Version != stored.Version {
+		return ErrVersionMismatch
+	}
+	delete(s.editions, e.ID)
+
+	return nil
+}
+
+// isbnTaken reports whether another edition has e's ISBN, the caller must
+// hold s.mu
+func (s *MemoryStore) isbnTaken(e *Edition) bool {
+	for id, edition := range s.editions {
+		if id != e.ID && edition.ISBN13 == e.ISBN13 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetItem returns a copy of a book
+func (s *MemoryStore) GetItem(i *Item) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	item, ok := s.items[i.ID]
+	if !ok || item.BookID != i.BookID {
+		return sql.ErrNoRows
+	}
+	*i = item
+
+	return nil
+}
+
+// GetItemByBarcode returns the copy with a barcode
+func (s *MemoryStore) GetItemByBarcode(i *Item) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, item := range s.items {
+		if item.Barcode == i.Barcode {
+			*i = item
+			return nil
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+// GetItems returns every copy of a book
+func (s *MemoryStore) GetItems(b *Book) ([]Item, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	book, ok := s.books[b.ID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	*b = book
+
+	return s.bookItems(b.ID), nil
+}
+
+// CreateItem inserts a new copy, which may go straight to the first hold
+// in its book's queue
+func (s *MemoryStore) CreateItem(i *Item) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.books[i.BookID]; !ok {
+		return sql.ErrNoRows
+	}
+	if s.barcodeTaken(i) {
+		return ErrDuplicateBarcode
+	}
+
+	i.ID = s.nextItemID
+	s.nextItemID++
+	i.Version = 1
+	i.Status = CheckedIn
+	i.Book = nil
+	s.items[i.ID] = *i
+	s.advanceHolds(i.BookID, time.Now())
+	*i = s.items[i.ID]
+
+	return nil
+}
+
+// UpdateItem updates a copy, leaving its status to its loans and holds
+// and its branch to its transfers
+func (s *MemoryStore) UpdateItem(i *Item) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.items[i.ID]
+	if !ok || stored.BookID != i.BookID {
+		return sql.ErrNoRows
+	}
+	if i.Version != 0 && i.Version != stored.Version {
+		return ErrVersionMismatch
+	}
+	if s.barcodeTaken(i) {
+		return ErrDuplicateBarcode
+	}
+
+	i.Version = stored.Version + 1
+	i.BranchID = stored.BranchID
+	i.Status = stored.Status
+	i.Book = nil
+	s.items[i.ID] = *i
+
+	return nil
+}
+
+// DeleteItem removes a copy that has never been lent and is neither set
+// aside for a hold nor in transit, along with its transfers
+func (s *MemoryStore) DeleteItem(i *Item) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.items[i.ID]
+	if !ok || stored.BookID != i.BookID {
+		return sql.ErrNoRows
+	}
+	if i.Version != 0 && i.Version != stored.Version {
+		return ErrVersionMismatch
+	}
+	switch stored.Status {
+	case CheckedOut:
+		return ErrItemOnLoan
+	case OnHoldShelf:
+		return ErrItemOnHold
+	case InTransit:
+		return ErrItemInTransit
+	}
+	for _, l := range s.loans {
+		if l.ItemID == i.ID {
+			return ErrItemHasLoans
+		}
+	}
+	delete(s.items, i.ID)
+	for id, t := range s.transfers {
+		if t.ItemID == i.ID {
+			delete(s.transfers, id)
+		}
+	}
+	s.syncAvailability(i.BookID)
+
+	return nil
+}
+
+// barcodeTaken reports whether another copy has i's barcode, the caller
+// must hold s.mu
+func (s *MemoryStore) barcodeTaken(i *Item) bool {
+	for id, item := range s.items {
+		if id != i.ID && item.Barcode == i.Barcode {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetBranch returns a branch
+func (s *MemoryStore) GetBranch(b *Branch) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	branch, ok := s.branches[b.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	*b = branch
+
+	return nil
+}
+
+// GetBranches returns a page of branches and the total count
+func (s *MemoryStore) GetBranches(opts ListOptions) ([]Branch, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	branches := []Branch{}
+	for _, b := range s.branches {
+		branches = append(branches, b)
+	}
+	indexes, total := pageIndexes(len(branches), func(i int) Sortable { return branches[i] }, func(i int) string { return branches[i].Name }, opts)
+
+	page := []Branch{}
+	for _, i := range indexes {
+		page = append(page, branches[i])
+	}
+
+	return page, total, nil
+}
+
+// CreateBranch inserts a new branch
+func (s *MemoryStore) CreateBranch(b *Branch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.codeTaken(b) {
+		return ErrDuplicateBranchCode
+	}
+
+	b.ID = s.nextBranchID
+	s.nextBranchID++
+	b.Version = 1
+	s.branches[b.ID] = *b
+
+	return nil
+}
+
+// UpdateBranch updates a branch
+func (s *MemoryStore) UpdateBranch(b *Branch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.branches[b.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if b.Version != 0 && b.Version != stored.Version {
+		return ErrVersionMismatch
+	}
+	if s.codeTaken(b) {
+		return ErrDuplicateBranchCode
+	}
+
+	b.Version = stored.Version + 1
+	s.branches[b.ID] = *b
+
+	return nil
+}
+
+// DeleteBranch removes a branch nothing is shelved at, waiting to be
+// picked up at or on its way to, along with its past transfers
+func (s *MemoryStore) DeleteBranch(b *Branch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.branches[b.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if b.Version != 0 && b.Version != stored.Version {
+		return ErrVersionMismatch
+	}
+
+	for _, i := range s.items {
+		if i.BranchID == b.ID {
+			return ErrBranchInUse
+		}
+	}
+	for _, h := range s.holds {
+		if h.PickupBranchID != nil && *h.PickupBranchID == b.ID && h.Active() {
+			return ErrBranchInUse
+		}
+	}
+	for _, t := range s.transfers {
+		if t.FromBranchID == b.ID || t.ToBranchID == b.ID {
+			return ErrBranchInUse
+		}
+	}
+
+	for id, h := range s.holds {
+		if h.PickupBranchID != nil && *h.PickupBranchID == b.ID {
+			h.PickupBranchID = nil
+			s.holds[id] = h
+		}
+	}
+	delete(s.branches, b.ID)
+
+	return nil
+}
+
+// codeTaken reports whether another branch has b's code, the caller must
+// hold s.mu
+func (s *MemoryStore) codeTaken(b *Branch) bool {
+	for id, branch := range s.branches {
+		if id != b.ID && branch.Code == b.Code {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RequestTransfer asks for a copy to be sent from the branch it is at to
+// t.ToBranchID
+func (s *MemoryStore) RequestTransfer(t *Transfer, note string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, ok := s.items[t.ItemID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if item.BranchID == t.ToBranchID {
+		return ErrSameBranch
+	}
+	for _, open := range s.transfers {
+		if open.ItemID == t.ItemID && open.Open() {
+			return ErrTransferOpen
+		}
+	}
+
+	now := time.Now()
+	transfer := Transfer{ID: s.nextTransferID, ItemID: item.ID, BookID: item.BookID, FromBranchID: item.BranchID, ToBranchID: t.ToBranchID,
+		Status: TransferRequested, RequestedAt: now, UpdatedAt: now, Version: 1}
+	s.nextTransferID++
+	s.addTransferEvent(&transfer, note, now)
+	s.transfers[transfer.ID] = transfer
+
+	*t = copyTransfer(transfer)
+	return nil
+}
+
+// MoveTransfer moves a transfer on to status, shelving a received copy at
+// the branch it was sent to
+func (s *MemoryStore) MoveTransfer(t *Transfer, status TransferStatus, note string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	transfer, ok := s.transfers[t.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	now := time.Now()
+	s.advanceHolds(transfer.BookID, now)
+
+	if t.Version != 0 && t.Version != transfer.Version {
+		return ErrVersionMismatch
+	}
+	if err := transfer.CanMove(status); err != nil {
+		return err
+	}
+
+	if status == TransferInTransit {
+		switch s.itemStatus(transfer.ItemID) {
+		case CheckedOut:
+			return ErrItemOnLoan
+		case OnHoldShelf:
+			return ErrItemOnHold
+		}
+	}
+	if status == TransferReceived {
+		item := s.items[transfer.ItemID]
+		item.BranchID = transfer.ToBranchID
+		item.Version++
+		s.items[item.ID] = item
+	}
+
+	transfer.Status = status
+	transfer.UpdatedAt = now
+	transfer.Version++
+	s.addTransferEvent(&transfer, note, now)
+	s.transfers[transfer.ID] = transfer
+	s.advanceHolds(transfer.BookID, now)
+
+	*t = copyTransfer(transfer)
+	return nil
+}
+
+// addTransferEvent records t's status on its trail, the caller must hold
+// s.mu for writing
+func (s *MemoryStore) addTransferEvent(t *Transfer, note string, now time.Time) {
+	t.Events = append(t.Events, TransferEvent{ID: s.nextEventID, TransferID: t.ID, Status: t.Status, Note: note, CreatedAt: now})
+	s.nextEventID++
+}
+
+// copyTransfer t with a trail of its own, so later steps don't show
+// through
+func copyTransfer(t Transfer) Transfer {
+	t.Events = append([]TransferEvent{}, t.Events...)
+	return t
+}
+
+// GetTransfer returns a transfer along with its trail
+func (s *MemoryStore) GetTransfer(t *Transfer) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	transfer, ok := s.transfers[t.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	*t = copyTransfer(transfer)
+	return nil
+}
+
+// GetTransfers returns a page of transfers, without their trails, and the
+// total count. With opts.Branch set only transfers from or to that branch
+// are listed.
+func (s *MemoryStore) GetTransfers(opts ListOptions) ([]Transfer, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	transfers := []Transfer{}
+	for _, t := range s.transfers {
+		if opts.Branch != 0 && t.FromBranchID != opts.Branch && t.ToBranchID != opts.Branch {
+			continue
+		}
+		t.Events = nil
+		transfers = append(transfers, t)
+	}
+	indexes, total := pageIndexes(len(transfers), func(i int) Sortable { return transfers[i] }, func(i int) string { return "" }, opts)
+
+	page := []Transfer{}
+	for _, i := range indexes {
+		page = append(page, transfers[i])
+	}
+
+	return page, total, nil
+}
+
+// expandBook attaches the relations named in expand, the caller must hold s.mu
+func (s *MemoryStore) expandBook(b Book, expand Expand) Book {
+	if expand["author"] && b.AuthorID != nil {
+		if author, ok := s.authors[*b.AuthorID]; ok {
+			b.Author = &author
+		}
+	}
+	if expand["publisher"] && b.PublisherID != nil {
+		if publisher, ok := s.publishers[*b.PublisherID]; ok {
+			b.Publisher = &publisher
+		}
+	}
+	if expand["contributors"] {
+		b.Contributors = s.bookContributors(b.ID, Expand{})
+	}
+
+	return b
+}
+
+// stripBook drops nested relations so only their ids are stored, as in postgres
+func stripBook(b Book) Book {
+	b.Author, b.Publisher, b.Contributors = nil, nil, nil
+	return b
+}
+
+// GetAuthor returns an author
+func (s *MemoryStore) GetAuthor(author *Author, columns []string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	found, ok := s.authors[author.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	*author = found
+
+	return nil
+}
+
+// GetAuthors returns a page of authors and the total count
+func (s *MemoryStore) GetAuthors(opts ListOptions) ([]Author, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	authors := []Author{}
+	for _, author := range s.authors {
+		authors = append(authors, author)
+	}
+	indexes, total := pageIndexes(len(authors), func(i int) Sortable { return authors[i] }, func(i int) string { return authorText(authors[i]) }, opts)
+
+	page := []Author{}
+	for _, i := range indexes {
+		page = append(page, authors[i])
+	}
+
+	return page, total, nil
+}
+
+// CreateAuthor inserts a new author
+func (s *MemoryStore) CreateAuthor(author *Author) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	author.ID = s.nextAuthorID
+	s.nextAuthorID++
+	author.Version = 1
+	s.authors[author.ID] = *author
+
+	return nil
+}
+
+// UpdateAuthor updates an author
+func (s *MemoryStore) UpdateAuthor(author *Author) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.authors[author.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if author.Version != 0 && author.Version != stored.Version {
+		return ErrVersionMismatch
+	}
+	author.Version = stored.Version + 1
+	s.authors[author.ID] = *author
+	*author = s.authors[author.ID]
+
+	return nil
+}
+
+// PatchAuthor updates only the given columns of an author
+func (s *MemoryStore) PatchAuthor(author *Author, columns []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.authors[author.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if author.Version != 0 && author.Version != stored.Version {
+		return ErrVersionMismatch
+	}
+	copyColumns(&stored, author, columns)
+	if len(columns) > 0 {
+		stored.Version++
+	}
+	s.authors[author.ID] = stored
+	*author = stored
+
+	return nil
+}
+
+// DeleteAuthor removes an author
+func (s *MemoryStore) DeleteAuthor(author *Author, cascade Cascade) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.authors[author.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if author.Version != 0 && author.Version != stored.Version {
+		return ErrVersionMismatch
+	}
+
+	credited := func(b *Book) bool {
+		for _, c := range s.contributors[b.ID] {
+			if c.AuthorID == author.ID {
+				return true
+			}
+		}
+		return b.AuthorID != nil && *b.AuthorID == author.ID
+	}
+	detach := func(b *Book) {
+		if b.AuthorID != nil && *b.AuthorID == author.ID {
+			b.AuthorID = nil
+		}
+	}
+	if err := s.cascadeBooks(cascade, credited, detach); err != nil {
+		return err
+	}
+
+	for id, contributors := range s.contributors {
+		kept := []Contributor{}
+		for _, c := range contributors {
+			if c.AuthorID != author.ID {
+				kept = append(kept, c)
+			}
+		}
+		s.contributors[id] = kept
+	}
+	delete(s.authors, author.ID)
+
+	return nil
+}
+
+// GetPublisher returns a publisher
+func (s *MemoryStore) GetPublisher(p *Publisher, columns []string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	publisher, ok := s.publishers[p.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	*p = publisher
+
+	return nil
+}
+
+// GetPublishers returns a page of publishers and the total count
+func (s *MemoryStore) GetPublishers(opts ListOptions) ([]Publisher, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	publishers := []Publisher{}
+	for _, p := range s.publishers {
+		publishers = append(publishers, p)
+	}
+	indexes, total := pageIndexes(len(publishers), func(i int) Sortable { return publishers[i] }, func(i int) string { return publishers[i].Name }, opts)
+
+	page := []Publisher{}
+	for _, i := range indexes {
+		page = append(page, publishers[i])
+	}
+
+	return page, total, nil
+}
+
+// CreatePublisher inserts a new publisher
+func (s *MemoryStore) CreatePublisher(p *Publisher) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p.ID = s.nextPublisherID
+	s.nextPublisherID++
+	p.Version = 1
+	s.publishers[p.ID] = *p
+
+	return nil
+}
+
+// UpdatePublisher updates a publisher
+func (s *MemoryStore) UpdatePublisher(p *Publisher) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.publishers[p.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if p.Version != 0 && p.Version != stored.Version {
+		return ErrVersionMismatch
+	}
+	p.Version = stored.Version + 1
+	s.publishers[p.ID] = *p
+	*p = s.publishers[p.ID]
+
+	return nil
+}
+
+// PatchPublisher updates only the given columns of a publisher
+func (s *MemoryStore) PatchPublisher(p *Publisher, columns []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.publishers[p.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if p.Version != 0 && p.Version != stored.Version {
+		return ErrVersionMismatch
+	}
+	copyColumns(&stored, p, columns)
+	if len(columns) > 0 {
+		stored.Version++
+	}
+	s.publishers[p.ID] = stored
+	*p = stored
+
+	return nil
+}
+
+// DeletePublisher removes a publisher
+func (s *MemoryStore) DeletePublisher(p *Publisher, cascade Cascade) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.publishers[p.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if p.Version != 0 && p.Version != stored.Version {
+		return ErrVersionMismatch
+	}
+
+	err := s.cascadeBooks(cascade, func(b *Book) bool { return b.PublisherID != nil && *b.PublisherID == p.ID }, func(b *Book) { b.PublisherID = nil })
+	if err != nil {
+		return err
+	}
+
+	for id, e := range s.editions {
+		if e.PublisherID != nil && *e.PublisherID == p.ID {
+			e.PublisherID = nil
+			s.editions[id] = e
+		}
+	}
+	delete(s.publishers, p.ID)
+
+	return nil
+}
+
+// GetPatron returns a patron
+func (s *MemoryStore) GetPatron(p *Patron) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	patron, ok := s.patrons[p.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	*p = patron
+
+	return nil
+}
+
+// GetPatrons returns a page of patrons and the total count
+func (s *MemoryStore) GetPatrons(opts ListOptions) ([]Patron, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	patrons := []Patron{}
+	for _, p := range s.patrons {
+		patrons = append(patrons, p)
+	}
+	indexes, total := pageIndexes(len(patrons), func(i int) Sortable { return patrons[i] }, func(i int) string { return patrons[i].Name }, opts)
+
+	page := []Patron{}
+	for _, i := range indexes {
+		page = append(page, patrons[i])
+	}
+
+	return page, total, nil
+}
+
+// CreatePatron inserts a new patron
+func (s *MemoryStore) CreatePatron(p *Patron) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cardTaken(p) {
+		return ErrDuplicateCardNumber
+	}
+
+	p.ID = s.nextPatronID
+	s.nextPatronID++
+	p.Version = 1
+	s.patrons[p.ID] = *p
+
+	return nil
+}
+
+// UpdatePatron updates a patron
+func (s *MemoryStore) UpdatePatron(p *Patron) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.patrons[p.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if p.Version != 0 && p.Version != stored.Version {
+		return ErrVersionMismatch
+	}
+	if s.cardTaken(p) {
+		return ErrDuplicateCardNumber
+	}
+	p.Version = stored.Version + 1
+	s.patrons[p.ID] = *p
+
+	return nil
+}
+
+// PatchPatron updates only the given columns of a patron
+func (s *MemoryStore) PatchPatron(p *Patron, columns []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.patrons[p.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if p.Version != 0 && p.Version != stored.Version {
+		return ErrVersionMismatch
+	}
+	copyColumns(&stored, p, columns)
+	if s.cardTaken(&stored) {
+		return ErrDuplicateCardNumber
+	}
+	if len(columns) > 0 {
+		stored.Version++
+	}
+	s.patrons[p.ID] = stored
+	*p = stored
+
+	return nil
+}
+
+// DeletePatron removes a patron who has nothing checked out along with
+// their holds, keeping their past loans without saying whose they were
+func (s *MemoryStore) DeletePatron(p *Patron) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.patrons[p.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if p.Version != 0 && p.Version != stored.Version {
+		return ErrVersionMismatch
+	}
+	if s.borrowed(p.ID) > 0 {
+		return ErrPatronHasLoans
+	}
+	if s.balance(p.ID) != 0 {
+		return ErrPatronOwes
+	}
+	for _, e := range s.entries {
+		if e.PatronID == p.ID {
+			return ErrPatronHasLedger
+		}
+	}
+
+	for id, l := range s.loans {
+		if l.PatronID != nil && *l.PatronID == p.ID {
+			l.PatronID = nil
+			s.loans[id] = l
+		}
+	}
+	books := map[int]bool{}
+	for id, h := range s.holds {
+		if h.PatronID == p.ID {
+			if h.Active() {
+				books[h.BookID] = true
+			}
+			delete(s.holds, id)
+		}
+	}
+	now := time.Now()
+	for bookID := range books {
+		s.advanceHolds(bookID, now)
+	}
+	delete(s.patrons, p.ID)
+
+	return nil
+}
+
+// GetPatronLoans returns a patron's loans, the latest first
+func (s *MemoryStore) GetPatronLoans(p *Patron, branchID int) ([]Loan, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.patrons[p.ID]; !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	return s.latestLoans(func(l Loan) bool {
+		return l.PatronID != nil && *l.PatronID == p.ID && s.atBranch(l.ItemID, branchID)
+	}), nil
+}
+
+// cardTaken reports whether another patron has p's card number, the caller
+// must hold s.mu
+func (s *MemoryStore) cardTaken(p *Patron) bool {
+	for id, patron := range s.patrons {
+		if id != p.ID && patron.CardNumber == p.CardNumber {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cascadeBooks applies cascade to the books refers matches, detaching them
+// with detach. The caller must hold s.mu for writing.
+func (s *MemoryStore) cascadeBooks(cascade Cascade, refers func(b *Book) bool, detach func(b *Book)) error {
+	ids := []int{}
+	for id, b := range s.books {
+		if refers(&b) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+
+	switch cascade {
+	case CascadeDetach:
+		for _, id := range ids {
+			b := s.books[id]
+			detach(&b)
+			b.Version++
+			s.books[id] = b
+		}
+	case CascadeDelete:
+		for _, id := range ids {
+			s.deleteBook(id)
+		}
+	default:
+		if len(ids) > 0 {
+			refs := []BookRef{}
+			for _, id := range ids {
+				refs = append(refs, BookRef{ID: id, Title: s.books[id].Title})
+			}
+			return &ReferencedError{Books: refs}
+		}
+	}
+
+	return nil
+}
+
+// weightedText a field a record can be found by and how much a match in it
+// counts, in the spirit of postgres' A to D weights
+type weightedText struct {
+	text   string
+	weight float64
+}
+
+// Search returns a page of matching records and the total number of
+// matches. Every word of text must appear in a record, which is far
+// simpler than postgres' stemming but finds the same exact matches.
+func (s *MemoryStore) Search(text string, types []string, opts ListOptions) ([]SearchResult, int, Facets, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	terms := searchWords(text)
+	results := []SearchResult{}
+	match := func(t string, id int, title, body string, fields ...weightedText) {
+		if rank := searchRank(terms, fields); rank > 0 {
+			results = append(results, SearchResult{Type: t, ID: id, Title: title, Highlight: highlight(body, terms), Rank: rank})
+		}
+	}
+
+	for _, t := range types {
+		switch t {
+		case "book":
+			for _, b := range s.books {
+				names, publisher := []string{}, ""
+				for _, c := range s.contributors[b.ID] {
+					a := s.authors[c.AuthorID]
+					names = append(names, a.FirstName, a.LastName, a.PenName)
+				}
+				author := strings.Join(names, " ")
+				if b.PublisherID != nil {
+					publisher = s.publishers[*b.PublisherID].Name
+				}
+				match(t, b.ID, b.Title, b.Title, weightedText{b.Title, 1}, weightedText{author, 0.4}, weightedText{publisher, 0.2})
+			}
+		case "author":
+			for _, a := range s.authors {
+				name := strings.TrimSpace(a.FirstName + " " + a.LastName)
+				match(t, a.ID, name, strings.TrimSpace(name+" "+a.PenName), weightedText{a.PenName, 1}, weightedText{name, 1})
+			}
+		case "publisher":
+			for _, p := range s.publishers {
+				match(t, p.ID, p.Name, p.Name, weightedText{p.Name, 1})
+			}
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		x, y := results[i], results[j]
+		if x.Rank != y.Rank {
+			return x.Rank > y.Rank
+		}
+		if x.Type != y.Type {
+			return x.Type < y.Type
+		}
+		return x.ID < y.ID
+	})
+
+	counter := facetCounter{}
+	if len(opts.Facets) > 0 {
+		for _, r := range results {
+			if r.Type == "book" {
+				s.countFacets(counter, s.books[r.ID])
+			}
+		}
+	}
+
+	total := len(results)
+	start, end := opts.Start, opts.Start+opts.Count
+	if start > total {
+		start = total
+	}
+	if end > total {
+		end = total
+	}
+
+	return results[start:end], total, counter.facets(opts.Facets), nil
+}
+
+// Suggest returns up to limit records whose names start with prefix,
+// ordered like the postgres store's
+func (s *MemoryStore) Suggest(prefix string, types []string, limit int) ([]Suggestion, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	prefix = strings.ToLower(prefix)
+	starts := func(text string) bool {
+		return strings.HasPrefix(strings.ToLower(text), prefix)
+	}
+
+	// loans of each book, and of each author's and publisher's books
+	lent, authorLent, publisherLent := map[int]int{}, map[int]int{}, map[int]int{}
+	for _, l := range s.loans {
+		lent[l.BookID]++
+		b := s.books[l.BookID]
+		credited := map[int]bool{}
+		for _, c := range s.contributors[l.BookID] {
+			if !credited[c.AuthorID] {
+				credited[c.AuthorID] = true
+				authorLent[c.AuthorID]++
+			}
+		}
+		if b.PublisherID != nil {
+			publisherLent[*b.PublisherID]++
+		}
+	}
+
+	suggestions := []Suggestion{}
+	for _, t := range types {
+		switch t {
+		case "title":
+			for _, b := range s.books {
+				if starts(b.Title) {
+					suggestions = append(suggestions, Suggestion{Type: t, ID: b.ID, Text: b.Title, Popularity: lent[b.ID]})
+				}
+			}
+		case "author":
+			for _, a := range s.authors {
+				name := strings.TrimSpace(a.FirstName + " " + a.LastName)
+				if a.PenName != "" && starts(a.PenName) {
+					suggestions = append(suggestions, Suggestion{Type: t, ID: a.ID, Text: a.PenName, Popularity: authorLent[a.ID]})
+				} else if (a.FirstName != "" && starts(a.FirstName)) || (a.LastName != "" && starts(a.LastName)) || starts(a.FirstName+" "+a.LastName) {
+					suggestions = append(suggestions, Suggestion{Type: t, ID: a.ID, Text: name, Popularity: authorLent[a.ID]})
+				}
+			}
+		case "publisher":
+			for _, p := range s.publishers {
+				if starts(p.Name) {
+					suggestions = append(suggestions, Suggestion{Type: t, ID: p.ID, Text: p.Name, Popularity: publisherLent[p.ID]})
+				}
+			}
+		}
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		x, y := suggestions[i], suggestions[j]
+		if x.Popularity != y.Popularity {
+			return x.Popularity > y.Popularity
+		}
+		if lx, ly := len([]rune(x.Text)), len([]rune(y.Text)); lx != ly {
+			return lx < ly
+		}
+		if x.Text != y.Text {
+			return x.Text < y.Text
+		}
+		return x.ID < y.ID
+	})
+
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+
+	return suggestions, nil
+}
+
+// searchWords lower cased words of s
+func searchWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// searchRank averages the weight of the best field each term appears in,
+// or returns 0 when any term is missing
+func searchRank(terms []string, fields []weightedText) float64 {
+	if len(terms) == 0 {
+		return 0
+	}
+
+	rank := 0.0
+	for _, term := range terms {
+		best := 0.0
+		for _, f := range fields {
+			for _, word := range searchWords(f.text) {
+				if word == term && f.weight > best {
+					best = f.weight
+				}
+			}
+		}
+		if best == 0 {
+			return 0
+		}
+		rank += best
+	}
+
+	return rank / float64(len(terms))
+}
+
+// highlight wraps the words of s found in terms in <mark> tags
+func highlight(s string, terms []string) string {
+	found := map[string]bool{}
+	for _, term := range terms {
+		found[term] = true
+	}
+
+	var out strings.Builder
+	word := []rune{}
+	flush := func() {
+		if w := string(word); found[strings.ToLower(w)] {
+			out.WriteString("<mark>" + w + "</mark>")
+		} else {
+			out.WriteString(w)
+		}
+		word = word[:0]
+	}
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			word = append(word, r)
+			continue
+		}
+		flush()
+		out.WriteRune(r)
+	}
+	flush()
+
+	return out.String()
+}
+
+// MatchAuthors returns the authors most similar to m
+func (s *MemoryStore) MatchAuthors(m *Match, limit int) ([]Matched, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	matched := []Matched{}
+	for _, a := range s.authors {
+		matched = append(matched, Matched{ID: a.ID, Similarity: Similarity(m.Text, authorText(a))})
+	}
+
+	return bestMatches(matched, m, limit), nil
+}
+
+// MatchBooks returns the books whose titles are most similar to m
+func (s *MemoryStore) MatchBooks(m *Match, limit int) ([]Matched, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	matched := []Matched{}
+	for _, b := range s.books {
+		matched = append(matched, Matched{ID: b.ID, Similarity: Similarity(m.Text, b.Title)})
+	}
+
+	return bestMatches(matched, m, limit), nil
+}
+
+// authorText every name of an author, as authorMatcher matches them
+func authorText(a Author) string {
+	return a.FirstName + " " + a.LastName + " " + a.PenName
+}
+
+// bestMatches up to limit of matched at least m.Threshold similar, most
+// similar first
+func bestMatches(matched []Matched, m *Match, limit int) []Matched {
+	best := []Matched{}
+	for _, match := range matched {
+		if match.Similarity > 0 && match.Similarity >= m.Threshold {
+			best = append(best, match)
+		}
+	}
+
+	sort.Slice(best, func(i, j int) bool {
+		if best[i].Similarity != best[j].Similarity {
+			return best[i].Similarity > best[j].Similarity
+		}
+		return best[i].ID < best[j].ID
+	})
+	if len(best) > limit {
+		best = best[:limit]
+	}
+
+	return best
+}
+
+// PlaceHold queues a patron for a book without a copy on the shelf at the
+// branch it is to be picked up at, or at any branch when its ID is 0
+func (s *MemoryStore) PlaceHold(b *Book, p *Patron, branch *Branch, pickup time.Duration) (Hold, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	patron, ok := s.patrons[p.ID]
+	if !ok {
+		return Hold{}, sql.ErrNoRows
+	}
+	now := time.Now()
+	if err := patron.CanHold(now); err != nil {
+		return Hold{}, err
+	}
+
+	if _, ok := s.books[b.ID]; !ok {
+		return Hold{}, sql.ErrNoRows
+	}
+	s.advanceHolds(b.ID, now)
+
+	for _, i := range s.bookItems(b.ID) {
+		if i.Status == CheckedIn && (branch.ID == 0 || i.BranchID == branch.ID) {
+			return Hold{}, ErrBookAvailable
+		}
+	}
+	for _, l := range s.loans {
+		if l.BookID == b.ID && l.ReturnedAt == nil && l.PatronID != nil && *l.PatronID == p.ID {
+			return Hold{}, ErrAlreadyBorrowed
+		}
+	}
+	for _, h := range s.queue(b.ID, now) {
+		if h.PatronID == p.ID {
+			return Hold{}, ErrDuplicateHold
+		}
+	}
+
+	hold := Hold{ID: s.nextHoldID, BookID: b.ID, PatronID: p.ID, Status: HoldWaiting, PlacedAt: now, PickupSeconds: int(pickup.Seconds())}
+	if branch.ID != 0 {
+		branchID := branch.ID
+		hold.PickupBranchID = &branchID
+	}
+	s.nextHoldID++
+	s.holds[hold.ID] = hold
+
+	return s.positioned(hold, now), nil
+}
+
+// GetHolds returns a book's queue, the ready holds first, leaving the
+// queue as it is
+func (s *MemoryStore) GetHolds(b *Book, branchID int) ([]Hold, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.books[b.ID]; !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	holds := []Hold{}
+	for _, h := range s.queue(b.ID, time.Now()) {
+		if s.holdAtBranch(h, branchID) {
+			holds = append(holds, h)
+		}
+	}
+
+	return holds, nil
+}
+
+// GetHold returns a hold of a book along with its place in the queue
+func (s *MemoryStore) GetHold(h *Hold) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hold, ok := s.holds[h.ID]
+	if !ok || hold.BookID != h.BookID {
+		return sql.ErrNoRows
+	}
+
+	*h = s.positioned(hold, time.Now())
+	return nil
+}
+
+// CancelHold takes a hold out of its book's queue
+func (s *MemoryStore) CancelHold(h *Hold) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.books[h.BookID]; !ok {
+		return sql.ErrNoRows
+	}
+	now := time.Now()
+	s.advanceHolds(h.BookID, now)
+
+	hold, ok := s.holds[h.ID]
+	if !ok || hold.BookID != h.BookID {
+		return sql.ErrNoRows
+	}
+	if !hold.Active() {
+		return ErrHoldClosed
+	}
+
+	hold.Status = HoldCancelled
+	s.holds[hold.ID] = hold
+	s.advanceHolds(h.BookID, now)
+
+	*h = hold
+	return nil
+}
+
+// GetPatronHolds returns a patron's holds, the latest first
+func (s *MemoryStore) GetPatronHolds(p *Patron, branchID int) ([]Hold, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.patrons[p.ID]; !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	now := time.Now()
+	holds := []Hold{}
+	for _, h := range s.holds {
+		if h.PatronID == p.ID && s.holdAtBranch(h, branchID) {
+			holds = append(holds, s.positioned(h, now))
+		}
+	}
+	sort.Slice(holds, func(i, j int) bool {
+		if !holds[i].PlacedAt.Equal(holds[j].PlacedAt) {
+			return holds[i].PlacedAt.After(holds[j].PlacedAt)
+		}
+		return holds[i].ID > holds[j].ID
+	})
+
+	return holds, nil
+}
+
+// advanceHolds expires a book's ready holds once their pickup period is
+// over, and gives each copy that is neither out, set aside nor in transit
+// to the first waiting hold that can be picked up where it is shelved. The
+// caller must hold s.mu for writing.
+func (s *MemoryStore) advanceHolds(bookID int, now time.Time) {
+	for id, h := range s.holds {
+		if h.BookID == bookID && h.Status == HoldReady && !now.Before(*h.ExpiresAt) {
+			h.Status = HoldExpired
+			s.holds[id] = h
+		}
+	}
+
+	for {
+		holdID, itemID := 0, 0
+	queue:
+		for _, h := range s.queue(bookID, now) {
+			if h.Status != HoldWaiting {
+				continue
+			}
+			for _, i := range s.bookItems(bookID) {
+				if s.itemStatus(i.ID) == CheckedIn && (h.PickupBranchID == nil || *h.PickupBranchID == i.BranchID) {
+					holdID, itemID = h.ID, i.ID
+					break queue
+				}
+			}
+		}
+		if holdID == 0 {
+			break
+		}
+
+		next := s.holds[holdID]
+		expires := now.Add(time.Duration(next.PickupSeconds) * time.Second)
+		next.Status, next.ItemID, next.ReadyAt, next.ExpiresAt = HoldReady, &itemID, &now, &expires
+		s.holds[next.ID] = next
+	}
+
+	s.syncAvailability(bookID)
+}
+
+// queue a book's active holds as they stand at now numbered by their
+// place, the ready holds first and then the waiting ones, each as they
+// were placed
+func (s *MemoryStore) queue(bookID int, now time.Time) []Hold {
+	holds := []Hold{}
+	for _, h := range s.holds {
+		if h = h.asOf(now); h.BookID == bookID && h.Active() {
+			holds = append(holds, h)
+		}
+	}
+	sort.Slice(holds, func(i, j int) bool {
+		if (holds[i].Status == HoldReady) != (holds[j].Status == HoldReady) {
+			return holds[i].Status == HoldReady
+		}
+		if !holds[i].PlacedAt.Equal(holds[j].PlacedAt) {
+			return holds[i].PlacedAt.Before(holds[j].PlacedAt)
+		}
+		return holds[i].ID < holds[j].ID
+	})
+	for i := range holds {
+		holds[i].Position = i + 1
+	}
+
+	return holds
+}
+
+// positioned h as it stands at now with its place in its book's queue, if
+// it has one
+func (s *MemoryStore) positioned(h Hold, now time.Time) Hold {
+	for _, q := range s.queue(h.BookID, now) {
+		if q.ID == h.ID {
+			return q
+		}
+	}
+
+	return h.asOf(now)
+}
+
+// GetAccount returns a patron's ledger, the latest entry first
+func (s *MemoryStore) GetAccount(p *Patron, branchID int) (Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.patrons[p.ID]; !ok {
+		return Account{}, sql.ErrNoRows
+	}
+
+	account := Account{PatronID: p.ID, Balance: s.balance(p.ID), Entries: []AccountEntry{}}
+	for _, e := range s.entries {
+		if e.PatronID == p.ID && (branchID == 0 || e.LoanID != nil && s.atBranch(s.loans[*e.LoanID].ItemID, branchID)) {
+			account.Entries = append(account.Entries, e)
+		}
+	}
+	sort.Slice(account.Entries, func(i, j int) bool {
+		return account.Entries[i].ID > account.Entries[j].ID
+	})
+
+	return account, nil
+}
+
+// AddEntry settles part of a patron's balance, refusing to take it below
+// nothing
+func (s *MemoryStore) AddEntry(e *AccountEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.patrons[e.PatronID]; !ok {
+		return sql.ErrNoRows
+	}
+	if e.Amount > s.balance(e.PatronID) {
+		return ErrOverpayment
+	}
+
+	*e = s.addEntry(*e)
+	return nil
+}
+
+// addEntry appends e to the ledger, the caller must hold s.mu for writing
+func (s *MemoryStore) addEntry(e AccountEntry) AccountEntry {
+	e.ID = s.nextEntryID
+	e.CreatedAt = time.Now()
+	s.nextEntryID++
+	s.entries[e.ID] = e
+
+	return e
+}
+
+// balance what a patron owes
+func (s *MemoryStore) balance(patronID int) int {
+	balance := 0
+	for _, e := range s.entries {
+		if e.PatronID == patronID {
+			balance += e.signed()
+		}
+	}
+
+	return balance
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//go:embed db/migrate/*.sql
+var migrationFiles embed.FS
+
+// Migration a single versioned schema change
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus a migration and whether it has been applied
+type MigrationStatus struct {
+	Migration
+	Applied bool
+}
+
+// LoadMigrations reads <version>_<name>.up.sql and .down.sql pairs from dir.
+// A plain <version>_<name>.sql file is treated as an up script.
+func LoadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, e := range entries {
+		file := e.Name()
+		if e.IsDir() || !strings.HasSuffix(file, ".sql") {
+			continue
+		}
+
+		base := strings.TrimSuffix(file, ".sql")
+		down := strings.HasSuffix(base, ".down")
+		base = strings.TrimSuffix(strings.TrimSuffix(base, ".down"), ".up")
+
+		i := strings.Index(base, "_")
+		if i < 1 {
+			return nil, fmt.Errorf("migration %s: expected <version>_<name>.sql", file)
+		}
+		version, err := strconv.ParseInt(base[:i], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: invalid version %q", file, base[:i])
+		}
+
+		body, err := fs.ReadFile(fsys, path.Join(dir, file))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: base[i+1:]}
+			byVersion[version] = m
+		}
+
+		script := &m.Up
+		if down {
+			script = &m.Down
+		}
+		if *script != "" {
+			return nil, fmt.Errorf("migration %s: duplicate script for version %d", file, version)
+		}
+		*script = string(body)
+	}
+
+	migrations := []Migration{}
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s: missing up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// baselineVersion the last migration init_db.sh applied to the databases
+// it set up
+const baselineVersion int64 = 1523808410
+
+// createMigrationsTable keeps the version of each migration applied
+const createMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version BIGINT PRIMARY KEY,
+	applied_at TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT NOW()
+)`
+
+// Migrator applies embedded migrations and records them in schema_migrations
+type Migrator struct {
+	DB         *sqlx.DB
+	Migrations []Migration
+}
+
+// NewMigrator returns a migrator for the migrations built into the binary
+func NewMigrator(db *sqlx.DB) (*Migrator, error) {
+	migrations, err := LoadMigrations(migrationFiles, "db/migrate")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{DB: db, Migrations: migrations}, nil
+}
+
+// Status lists every known migration and whether it has been applied
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []MigrationStatus{}
+	for _, mig := range m.Migrations {
+		statuses = append(statuses, MigrationStatus{Migration: mig, Applied: applied[mig.Version]})
+	}
+
+	return statuses, nil
+}
+
+// Up applies every pending migration
+func (m *Migrator) Up() error {
+	if len(m.Migrations) == 0 {
+		return nil
+	}
+
+	return m.To(m.Migrations[len(m.Migrations)-1].Version)
+}
+
+// Down reverts the most recently applied migration
+func (m *Migrator) Down() error {
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+
+	for i := len(m.Migrations) - 1; i >= 0; i-- {
+		if applied[m.Migrations[i].Version] {
+			return m.revert(m.Migrations[i])
+		}
+	}
+
+	return nil
+}
+
+// To applies or reverts migrations until version is the latest one applied
+func (m *Migrator) To(version int64) error {
+	known := false
+	for _, mig := range m.Migrations {
+		if mig.Version == version {
+			known = true
+		}
+	}
+	if !known {
+		return fmt.Errorf("unknown migration version %d", version)
+	}
+
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+
+	for i := len(m.Migrations) - 1; i >= 0; i-- {
+		mig := m.Migrations[i]
+		if mig.Version > version && applied[mig.Version] {
+			if err := m.revert(mig); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, mig := range m.Migrations {
+		if mig.Version <= version && !applied[mig.Version] {
+			if err := m.apply(mig); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m *Migrator) applied() (map[int64]bool, error) {
+	if err := m.baseline(); err != nil {
+		return nil, err
+	}
+
+	if _, err := m.DB.Exec(createMigrationsTable); err != nil {
+		return nil, err
+	}
+
+	versions := []int64{}
+	if err := m.DB.Select(&versions, "SELECT version FROM schema_migrations"); err != nil {
+		return nil, err
+	}
+
+	applied := map[int64]bool{}
+	for _, v := range versions {
+		applied[v] = true
+	}
+
+	return applied, nil
+}
+
+// baseline brings a database the old scripts set up under the migrator.
+// pgmgr kept its own schema_migrations with text versions, which is
+// converted. init_db.sh kept none, so a database with a books table and no
+// schema_migrations has the migrations it applied recorded as applied.
+func (m *Migrator) baseline() error {
+	var tables struct {
+		Books       bool   `db:"books"`
+		Migrations  bool   `db:"migrations"`
+		VersionType string `db:"version_type"`
+	}
+	err := m.DB.Get(&tables, `SELECT to_regclass('books') IS NOT NULL AS books, to_regclass('schema_migrations') IS NOT NULL AS migrations,
+		coalesce((SELECT data_type FROM information_schema.columns
+			WHERE table_schema = current_schema() AND table_name = 'schema_migrations' AND column_name = 'version'), '') AS version_type`)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case tables.Migrations && tables.VersionType != "bigint":
+		return m.convertPgmgr()
+	case tables.Books && !tables.Migrations:
+		return m.baselineInitDB()
+	}
+
+	return nil
+}
+
+// convertPgmgr swaps pgmgr's schema_migrations for the migrator's, keeping
+// the versions pgmgr applied
+func (m *Migrator) convertPgmgr() error {
+	tx, err := m.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("ALTER TABLE schema_migrations RENAME TO pgmgr_schema_migrations"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(createMigrationsTable); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO schema_migrations (version) SELECT DISTINCT version::bigint FROM pgmgr_schema_migrations"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DROP TABLE pgmgr_schema_migrations"); err != nil {
+		return err
+	}
+	log.Printf("converted pgmgr's schema_migrations")
+
+	return tx.Commit()
+}
+
+// baselineInitDB records the migrations init_db.sh applied as applied
+func (m *Migrator) baselineInitDB() error {
+	tx, err := m.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(createMigrationsTable); err != nil {
+		return err
+	}
+	for _, mig := range baselineMigrations(m.Migrations) {
+		if _, err := tx.Exec("INSERT INTO schema_migrations (version) VALUES ($1) ON CONFLICT DO NOTHING", mig.Version); err != nil {
+			return err
+		}
+		log.Printf("baselined %d_%s", mig.Version, mig.Name)
+	}
+
+	return tx.Commit()
+}
+
+// baselineMigrations the migrations up to baselineVersion
+func baselineMigrations(migrations []Migration) []Migration {
+	baseline := []Migration{}
+	for _, mig := range migrations {
+		if mig.Version <= baselineVersion {
+			baseline = append(baseline, mig)
+		}
+	}
+
+	return baseline
+}
+
+func (m *Migrator) apply(mig Migration) error {
+	return m.run(mig, true)
+}
+
+func (m *Migrator) revert(mig Migration) error {
+	if mig.Down == "" {
+		return fmt.Errorf("migration %d_%s: no down script", mig.Version, mig.Name)
+	}
+
+	return m.run(mig, false)
+}
+
+// run executes one script and records it in the same transaction so a
+// failed migration leaves neither schema changes nor a version row behind.
+func (m *Migrator) run(mig Migration, up bool) error {
+	tx, err := m.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// serialize concurrent migrators, e.g. several replicas starting at once
+	if _, err := tx.Exec("LOCK TABLE schema_migrations IN EXCLUSIVE MODE"); err != nil {
+		return err
+	}
+
+	var applied bool
+	if err := tx.Get(&applied, "SELECT EXISTS(SELECT 1 FROM schema_migrations WHERE version=$1)", mig.Version); err != nil {
+		return err
+	}
+	if applied == up {
+		return nil
+	}
+
+	script, record, direction := mig.Up, "INSERT INTO schema_migrations (version) VALUES ($1)", "up"
+	if !up {
+		script, record, direction = mig.Down, "DELETE FROM schema_migrations WHERE version=$1", "down"
+	}
+
+	if _, err := tx.Exec(script); err != nil {
+		return fmt.Errorf("migration %d_%s %s: %v", mig.Version, mig.Name, direction, err)
+	}
+	if _, err := tx.Exec(record, mig.Version); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Printf("migrated %s %d_%s", direction, mig.Version, mig.Name)
+
+	return nil
+}
+
+// MigrateCommand runs `migrate up|down|status|to <version>`
+func MigrateCommand(m *Migrator, args []string, w io.Writer) error {
+	usage := fmt.Errorf("usage: migrate up|down|status|to <version>")
+	if len(args) == 0 {
+		return usage
+	}
+
+	switch args[0] {
+	case "up":
+		return m.Up()
+	case "down":
+		return m.Down()
+	case "to":
+		if len(args) != 2 {
+			return usage
+		}
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		return m.To(version)
+	case "status":
+		statuses, err := m.Status()
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tSTATUS")
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied {
+				state = "applied"
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\n", s.Version, s.Name, state)
+		}
+		return tw.Flush()
+	}
+
+	return usage
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := LoadMigrations(migrationFiles, "db/migrate")
+	if err != nil {
+		t.Fatalf("Expected embedded migrations to load. Got %v", err)
+	}
+
+	if len(migrations) == 0 {
+		t.Fatal("Expected at least one embedded migration")
+	}
+
+	for i, m := range migrations {
+		if i > 0 && m.Version <= migrations[i-1].Version {
+			t.Errorf("Expected migrations ordered by version. Got %d after %d", m.Version, migrations[i-1].Version)
+		}
+		if m.Down == "" {
+			t.Errorf("Expected migration %d_%s to have a down script", m.Version, m.Name)
+		}
+	}
+}
+
+func TestBaselineMigrations(t *testing.T) {
+	migrations, _ := LoadMigrations(migrationFiles, "db/migrate")
+
+	names := []string{}
+	for _, m := range baselineMigrations(migrations) {
+		names = append(names, m.Name)
+	}
+	if strings.Join(names, ",") != "CreateTables,alterBooks,AddPublisherReference,AddColumnsToBooks" {
+		t.Errorf("Expected the migrations the old scripts applied. Got %v", names)
+	}
+}
+
+func TestConvertPgmgrMigrations(t *testing.T) {
+	store, ok := a.Store.(*PostgresStore)
+	if !ok {
+		t.Skip("pgmgr's schema_migrations only lives in postgres")
+	}
+
+	// a database pgmgr set up, in a schema of its own
+	store.DB.MustExec("DROP SCHEMA IF EXISTS pgmgr_test CASCADE")
+	store.DB.MustExec("CREATE SCHEMA pgmgr_test")
+	defer store.DB.Exec("DROP SCHEMA pgmgr_test CASCADE")
+
+	url := os.Getenv("TEST_DATABASE_URL")
+	if strings.Contains(url, "?") {
+		url += "&search_path=pgmgr_test"
+	} else {
+		url += "?search_path=pgmgr_test"
+	}
+	db, err := sqlx.Connect("postgres", url)
+	if err != nil {
+		t.Fatalf("Expected to connect to the pgmgr schema. Got %v", err)
+	}
+	defer db.Close()
+
+	db.MustExec("CREATE TABLE books (id SERIAL PRIMARY KEY)")
+	db.MustExec("CREATE TABLE schema_migrations (version TEXT NOT NULL UNIQUE)")
+	db.MustExec("INSERT INTO schema_migrations (version) VALUES ('000000000'), ('152373965'), ('1523753980')")
+
+	m, err := NewMigrator(db)
+	if err != nil {
+		t.Fatalf("Expected a migrator. Got %v", err)
+	}
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatalf("Expected pgmgr's schema_migrations to be converted. Got %v", err)
+	}
+
+	applied := []string{}
+	for _, s := range statuses {
+		if s.Applied {
+			applied = append(applied, s.Name)
+		}
+	}
+	if strings.Join(applied, ",") != "CreateTables,alterBooks,AddPublisherReference" {
+		t.Errorf("Expected the versions pgmgr applied to be kept. Got %v", applied)
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"m/2_second.up.sql":   {Data: []byte("CREATE TABLE b ();")},
+		"m/2_second.down.sql": {Data: []byte("DROP TABLE b;")},
+		"m/10_third.up.sql":   {Data: []byte("CREATE TABLE c ();")},
+		"m/1_first.sql":       {Data: []byte("CREATE TABLE a ();")},
+		"m/README":            {Data: []byte("not a migration")},
+	}
+
+	migrations, err := LoadMigrations(fsys, "m")
+	if err != nil {
+		t.Fatalf("Expected migrations to load. Got %v", err)
+	}
+
+	if len(migrations) != 3 {
+		t.Fatalf("Expected 3 migrations. Got %d", len(migrations))
+	}
+
+	for i, version := range []int64{1, 2, 10} {
+		if migrations[i].Version != version {
+			t.Errorf("Expected migration %d to be version %d. Got %d", i, version, migrations[i].Version)
+		}
+	}
+
+	if migrations[1].Name != "second" || migrations[1].Down != "DROP TABLE b;" {
+		t.Errorf("Expected second migration with down script. Got %+v", migrations[1])
+	}
+}
+
+func TestLoadMigrationsErrors(t *testing.T) {
+	cases := map[string]fstest.MapFS{
+		"missing up":  {"m/1_first.down.sql": {Data: []byte("DROP TABLE a;")}},
+		"bad version": {"m/v1_first.up.sql": {Data: []byte("CREATE TABLE a ();")}},
+		"no name":     {"m/1.up.sql": {Data: []byte("CREATE TABLE a ();")}},
+		"duplicate": {
+			"m/1_first.sql":    {Data: []byte("CREATE TABLE a ();")},
+			"m/1_first.up.sql": {Data: []byte("CREATE TABLE a ();")},
+		},
+	}
+
+	for name, fsys := range cases {
+		if _, err := LoadMigrations(fsys, "m"); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
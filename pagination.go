@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMaxPageSize largest page served when App.MaxPageSize is unset
+const DefaultMaxPageSize = 10
+
+// Page optional envelope for list responses, requested with ?envelope=true
+// or implied by ?facets=
+type Page struct {
+	Items      interface{} `json:"items"`
+	Total      int         `json:"total"`
+	Next       *string     `json:"next"`
+	NextCursor *string     `json:"next_cursor"`
+	Facets     Facets      `json:"facets,omitempty"`
+}
+
+// SortField a column to order a list by
+type SortField struct {
+	Column string
+	Desc   bool
+}
+
+// ListOptions paging and ordering for list endpoints. When Cursor is set
+// the page continues after the cursor and Start is ignored. When Match is
+// set only similar records are listed, most similar first, and Sort and
+// Cursor don't apply. Columns narrows the columns selected, nil selects
+// them all. Facets names the facets to count the whole list by. Branch,
+// when set, narrows lists that can be to what is at that branch.
+type ListOptions struct {
+	Start   int
+	Count   int
+	Sort    []SortField
+	Cursor  *Cursor
+	Expand  Expand
+	Match   *Match
+	Filters []Filter
+	Columns []string
+	Facets  []string
+	Branch  int
+}
+
+// Cursor position in a keyset ordered list: the sort values and id of the
+// last row of the previous page
+type Cursor struct {
+	Sort   string        `json:"s"`
+	Values []interface{} `json:"v"`
+	ID     int           `json:"id"`
+}
+
+// Sortable is implemented by models list endpoints can order and page by
+type Sortable interface {
+	// SortKey returns the value of column, or nil when it is not sortable
+	SortKey(column string) interface{}
+}
+
+// sortString formats fields the way the sort parameter accepts them
+func sortString(fields []SortField) string {
+	parts := []string{}
+	for _, f := range fields {
+		if f.Desc {
+			parts = append(parts, "-"+f.Column)
+		} else {
+			parts = append(parts, f.Column)
+		}
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// ParseSort reads a comma separated list of columns, each optionally
+// prefixed with - for descending order, restricted to allowed
+func ParseSort(s string, allowed []string) ([]SortField, error) {
+	fields := []SortField{}
+	if s == "" {
+		return fields, nil
+	}
+
+	seen := map[string]bool{}
+	for _, part := range strings.Split(s, ",") {
+		f := SortField{Column: strings.TrimSpace(part)}
+		if strings.HasPrefix(f.Column, "-") {
+			f.Column, f.Desc = f.Column[1:], true
+		}
+
+		ok := false
+		for _, column := range allowed {
+			if column == f.Column {
+				ok = true
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("Invalid sort field '%s'", f.Column)
+		}
+		if seen[f.Column] {
+			return nil, fmt.Errorf("Duplicate sort field '%s'", f.Column)
+		}
+		seen[f.Column] = true
+
+		fields = append(fields, f)
+	}
+
+	return fields, nil
+}
+
+// EncodeCursor returns an opaque cursor pointing just after item
+func EncodeCursor(fields []SortField, item Sortable) string {
+	c := Cursor{Sort: sortString(fields), Values: []interface{}{}}
+	for _, f := range fields {
+		c.Values = append(c.Values, item.SortKey(f.Column))
+	}
+	c.ID, _ = item.SortKey("id").(int)
+
+	body, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(body)
+}
+
+// DecodeCursor parses a cursor and checks it was issued for fields
+func DecodeCursor(s string, fields []SortField) (*Cursor, error) {
+	invalid := errors.New("Invalid cursor")
+
+	body, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, invalid
+	}
+
+	var c Cursor
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&c); err != nil {
+		return nil, invalid
+	}
+
+	if c.Sort != sortString(fields) || len(c.Values) != len(fields) {
+		return nil, errors.New("Cursor does not match sort order")
+	}
+
+	return &c, nil
+}
+
+// listOptions reads start, count, sort, cursor, match and the filters in
+// filterable from the request, clamping count to the configured max page
+// size. Lists that can't be filtered pass nil filterable.
+func (a *App) listOptions(r *http.Request, sortable []string, filterable map[string]FilterField) (ListOptions, error) {
+	max := a.MaxPageSize
+	if max < 1 {
+		max = DefaultMaxPageSize
+	}
+
+	count, _ := strconv.Atoi(r.FormValue("count"))
+	start, _ := strconv.Atoi(r.FormValue("start"))
+
+	if count > max || count < 1 {
+		count = max
+	}
+	if start < 0 {
+		start = 0
+	}
+
+	fields, err := ParseSort(r.FormValue("sort"), sortable)
+	if err != nil {
+		return ListOptions{}, err
+	}
+
+	opts := ListOptions{Start: start, Count: count, Sort: fields}
+	if opts.Match, err = ParseMatch(r.FormValue("match"), r.FormValue("similarity")); err != nil {
+		return ListOptions{}, err
+	}
+	if opts.Match != nil && (len(fields) > 0 || r.FormValue("cursor") != "") {
+		return ListOptions{}, errors.New("match results are ordered by similarity, sort and cursor can't be used with match")
+	}
+
+	if filterable != nil {
+		if opts.Filters, err = ParseFilters(r.URL.Query(), filterable); err != nil {
+			return ListOptions{}, err
+		}
+	}
+
+	if c := r.FormValue("cursor"); c != "" {
+		opts.Start = 0
+		if opts.Cursor, err = DecodeCursor(c, fields); err != nil {
+			return ListOptions{}, err
+		}
+	}
+
+	return opts, nil
+}
+
+// lookahead asks a store for one extra row so a handler can tell whether
+// another page follows without relying on the total
+func (o ListOptions) lookahead() ListOptions {
+	o.Count++
+	return o
+}
+
+// RespondWithPage writes a page of results along with X-Total-Count,
+// X-Next-Cursor and RFC 5988 Link headers. nextCursor is empty on the
+// last page, or for lists that can only be paged by offset. facets are nil
+// unless some were asked for.
+func RespondWithPage(w http.ResponseWriter, r *http.Request, items interface{}, opts ListOptions, total int, nextCursor string, facets Facets) {
+	links := []string{}
+	link := func(rel string, q url.Values) string {
+		u := url.URL{Path: r.URL.Path, RawQuery: q.Encode()}
+		links = append(links, fmt.Sprintf(`<%s>; rel="%s"`, u.String(), rel))
+		return u.String()
+	}
+	query := func(set map[string]string) url.Values {
+		q := url.Values{}
+		for k, v := range r.URL.Query() {
+			q[k] = v
+		}
+		q.Del("start")
+		q.Del("cursor")
+		q.Set("count", strconv.Itoa(opts.Count))
+		for k, v := range set {
+			q.Set(k, v)
+		}
+		return q
+	}
+	offset := func(start int) url.Values {
+		return query(map[string]string{"start": strconv.Itoa(start)})
+	}
+
+	var next, cursor *string
+	if nextCursor != "" {
+		cursor = &nextCursor
+		w.Header().Set("X-Next-Cursor", nextCursor)
+	}
+
+	if opts.Cursor != nil {
+		// keyset pages have no stable position, so only first and next apply
+		link("first", query(nil))
+		if cursor != nil {
+			u := link("next", query(map[string]string{"cursor": nextCursor}))
+			next = &u
+		}
+	} else {
+		last := 0
+		if total > 0 {
+			last = (total - 1) / opts.Count * opts.Count
+		}
+
+		link("first", offset(0))
+		if opts.Start > 0 {
+			prev := opts.Start - opts.Count
+			if prev < 0 {
+				prev = 0
+			}
+			link("prev", offset(prev))
+		}
+		if cursor != nil || opts.Start+opts.Count < total {
+			u := link("next", offset(opts.Start+opts.Count))
+			next = &u
+		}
+		link("last", offset(last))
+	}
+
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	w.Header().Set("Link", strings.Join(links, ", "))
+
+	// a bare array has nowhere to put facets, so they always come enveloped
+	if envelope, _ := strconv.ParseBool(r.FormValue("envelope")); envelope || facets != nil {
+		RespondWithJSON(w, http.StatusOK, Page{Items: items, Total: total, Next: next, NextCursor: cursor, Facets: facets})
+		return
+	}
+
+	RespondWithJSON(w, http.StatusOK, items)
+}
+
+// pageIndexes filters and orders n items by opts and returns the indexes
+// making up the requested page along with the number of items that pass
+// the filters, for stores that sort in process. text is what opts.Match
+// is compared with.
+func pageIndexes(n int, item func(i int) Sortable, text func(i int) string, opts ListOptions) ([]int, int) {
+	indexes, similarity := matchIndexes(n, item, text, opts)
+	total := len(indexes)
+
+	if opts.Cursor != nil {
+		after := []int{}
+		for _, i := range indexes {
+			if afterCursor(item(i), opts.Sort, opts.Cursor) {
+				after = append(after, i)
+			}
+		}
+		indexes = after
+	}
+
+	sort.Slice(indexes, func(i, j int) bool {
+		if opts.Match != nil && similarity[indexes[i]] != similarity[indexes[j]] {
+			return similarity[indexes[i]] > similarity[indexes[j]]
+		}
+
+		x, y := item(indexes[i]), item(indexes[j])
+		for _, f := range opts.Sort {
+			if c := compareKeys(x.SortKey(f.Column), y.SortKey(f.Column)); c != 0 {
+				return (c < 0) != f.Desc
+			}
+		}
+		return compareKeys(x.SortKey("id"), y.SortKey("id")) < 0
+	})
+
+	start := opts.Start
+	if opts.Cursor != nil {
+		start = 0
+	}
+	if start > len(indexes) {
+		start = len(indexes)
+	}
+	end := start + opts.Count
+	if end > len(indexes) {
+		end = len(indexes)
+	}
+
+	return indexes[start:end], total
+}
+
+// matchIndexes the indexes of the n items passing opts.Filters and similar
+// to opts.Match, along with their similarity when matching
+func matchIndexes(n int, item func(i int) Sortable, text func(i int) string, opts ListOptions) ([]int, map[int]float64) {
+	indexes, similarity := []int{}, map[int]float64{}
+	for i := 0; i < n; i++ {
+		if !matchesFilters(item(i), opts.Filters) {
+			continue
+		}
+		if opts.Match != nil {
+			s := Similarity(opts.Match.Text, text(i))
+			if s == 0 || s < opts.Match.Threshold {
+				continue
+			}
+			similarity[i] = s
+		}
+		indexes = append(indexes, i)
+	}
+
+	return indexes, similarity
+}
+
+func afterCursor(x Sortable, fields []SortField, c *Cursor) bool {
+	for i, f := range fields {
+		if c := compareKeys(x.SortKey(f.Column), c.Values[i]); c != 0 {
+			return (c > 0) != f.Desc
+		}
+	}
+
+	return compareKeys(x.SortKey("id"), c.ID) > 0
+}
+
+// compareKeys compares a model's sort key with another key or with a value
+// decoded from a cursor
+func compareKeys(x, y interface{}) int {
+	switch xv := x.(type) {
+	case int:
+		var yv int64
+		switch v := y.(type) {
+		case int:
+			yv = int64(v)
+		case json.Number:
+			yv, _ = v.Int64()
+		}
+		switch {
+		case int64(xv) < yv:
+			return -1
+		case int64(xv) > yv:
+			return 1
+		}
+	case string:
+		yv, _ := y.(string)
+		return strings.Compare(xv, yv)
+	case time.Time:
+		var yv time.Time
+		switch v := y.(type) {
+		case time.Time:
+			yv = v
+		case string:
+			yv, _ = time.Parse(time.RFC3339Nano, v)
+		}
+		switch {
+		case xv.Before(yv):
+			return -1
+		case xv.After(yv):
+			return 1
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PATCH content types
+const (
+	MergePatchType = "application/merge-patch+json"
+	JSONPatchType  = "application/json-patch+json"
+)
+
+// errPatchTestFailed a JSON Patch test operation did not match
+var errPatchTestFailed = errors.New("test operation failed")
+
+// applyPatch patches the JSON form of current with the request body and
+// decodes the result into patched. fields maps the JSON fields a patch may
+// change to their columns, and the columns that changed are returned.
+func applyPatch(r *http.Request, current, patched interface{}, fields map[string]string) ([]string, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != MergePatchType && mediaType != JSONPatchType {
+		return nil, NewProblem(http.StatusUnsupportedMediaType, "Expected "+MergePatchType+" or "+JSONPatchType)
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, NewProblem(http.StatusBadRequest, "Invalid request payload")
+	}
+
+	original, err := patchDocument(current, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	// patch a copy so original can still be compared against the result
+	var doc interface{}
+	copied, _ := json.Marshal(original)
+	json.Unmarshal(copied, &doc)
+
+	if mediaType == MergePatchType {
+		var patch interface{}
+		if err := json.Unmarshal(body, &patch); err != nil {
+			return nil, NewProblem(http.StatusBadRequest, "Invalid merge patch")
+		}
+		doc = MergePatch(doc, patch)
+	} else {
+		var ops []PatchOperation
+		if err := json.Unmarshal(body, &ops); err != nil {
+			return nil, NewProblem(http.StatusBadRequest, "Invalid JSON patch")
+		}
+		if doc, err = JSONPatch(doc, ops); err != nil {
+			if err == errPatchTestFailed {
+				return nil, NewProblem(http.StatusConflict, "JSON patch test operation failed")
+			}
+			return nil, NewProblem(http.StatusBadRequest, "Invalid JSON patch: "+err.Error())
+		}
+	}
+
+	result, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, NewProblem(http.StatusBadRequest, "Patch result must be an object")
+	}
+
+	keys := map[string]bool{}
+	for k := range original {
+		keys[k] = true
+	}
+	for k := range result {
+		keys[k] = true
+	}
+
+	columns, errs := []string{}, ValidationErrors{}
+	for k := range keys {
+		if reflect.DeepEqual(original[k], result[k]) {
+			continue
+		}
+		if column, ok := fields[k]; ok {
+			columns = append(columns, column)
+		} else {
+			errs.add(k, CodeReadOnly, k+" cannot be changed")
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	sort.Strings(columns)
+
+	merged, _ := json.Marshal(result)
+	if err := json.Unmarshal(merged, patched); err != nil {
+		return nil, ValidationErrors{{Code: CodeInvalid, Message: err.Error()}}
+	}
+
+	return columns, nil
+}
+
+// patchDocument JSON form of v with every patchable field present, since
+// omitempty would otherwise hide zero values from JSON Patch paths
+func patchDocument(v interface{}, fields map[string]string) (map[string]interface{}, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := map[string]interface{}{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+
+	for k := range fields {
+		if _, ok := doc[k]; !ok {
+			doc[k] = nil
+		}
+	}
+
+	return doc, nil
+}
+
+// MergePatch applies an RFC 7396 JSON Merge Patch to target
+func MergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = MergePatch(t[k], v)
+		}
+	}
+
+	return t
+}
+
+// PatchOperation a single RFC 6902 JSON Patch operation
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// JSONPatch applies RFC 6902 JSON Patch operations to doc in order
+func JSONPatch(doc interface{}, ops []PatchOperation) (interface{}, error) {
+	for _, op := range ops {
+		path, err := jsonPointer(op.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		var value interface{}
+		switch op.Op {
+		case "add", "replace", "test":
+			if op.Value == nil {
+				return nil, fmt.Errorf("%s %s: missing value", op.Op, op.Path)
+			}
+			if err := json.Unmarshal(op.Value, &value); err != nil {
+				return nil, err
+			}
+		case "move", "copy":
+			from, err := jsonPointer(op.From)
+			if err != nil {
+				return nil, err
+			}
+			if value, err = pointerGet(doc, from); err != nil {
+				return nil, err
+			}
+			if op.Op == "move" {
+				if strings.HasPrefix(op.Path+"/", op.From+"/") && op.Path != op.From {
+					return nil, fmt.Errorf("move %s: cannot move into a child of itself", op.From)
+				}
+				if doc, err = pointerRemove(doc, from); err != nil {
+					return nil, err
+				}
+			} else {
+				copied, _ := json.Marshal(value)
+				json.Unmarshal(copied, &value)
+			}
+		}
+
+		switch op.Op {
+		case "add", "move", "copy":
+			doc, err = pointerSet(doc, path, value, true)
+		case "replace":
+			doc, err = pointerSet(doc, path, value, false)
+		case "remove":
+			doc, err = pointerRemove(doc, path)
+		case "test":
+			var current interface{}
+			if current, err = pointerGet(doc, path); err == nil && !reflect.DeepEqual(current, value) {
+				err = errPatchTestFailed
+			}
+		default:
+			err = fmt.Errorf("unknown op '%s'", op.Op)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return doc, nil
+}
+
+// jsonPointer splits an RFC 6901 JSON Pointer into reference tokens
+func jsonPointer(s string) ([]string, error) {
+	if s == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(s, "/") {
+		return nil, fmt.Errorf("invalid pointer '%s'", s)
+	}
+
+	tokens := strings.Split(s[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(t, "~1", "/", -1), "~0", "~", -1)
+	}
+
+	return tokens, nil
+}
+
+// arrayIndex parses an array index token, allowing - to mean the end
+// when appending
+func arrayIndex(token string, n int, appending bool) (int, error) {
+	if token == "-" && appending {
+		return n, nil
+	}
+
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index '%s'", token)
+	}
+
+	max := n - 1
+	if appending {
+		max = n
+	}
+	if i > max {
+		return 0, fmt.Errorf("array index %d out of range", i)
+	}
+
+	return i, nil
+}
+
+func pointerGet(doc interface{}, tokens []string) (interface{}, error) {
+	for _, t := range tokens {
+		switch d := doc.(type) {
+		case map[string]interface{}:
+			v, ok := d[t]
+			if !ok {
+				return nil, fmt.Errorf("path '%s' not found", t)
+			}
+			doc = v
+		case []interface{}:
+			i, err := arrayIndex(t, len(d), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = d[i]
+		default:
+			return nil, fmt.Errorf("path '%s' not found", t)
+		}
+	}
+
+	return doc, nil
+}
+
+// pointerSet adds or, when add is false, replaces the value at tokens
+func pointerSet(doc interface{}, tokens []string, value interface{}, add bool) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+
+	t, last := tokens[0], len(tokens) == 1
+	switch d := doc.(type) {
+	case map[string]interface{}:
+		child, ok := d[t]
+		if !ok && (!last || !add) {
+			return nil, fmt.Errorf("path '%s' not found", t)
+		}
+		if last {
+			d[t] = value
+			return d, nil
+		}
+
+		child, err := pointerSet(child, tokens[1:], value, add)
+		if err != nil {
+			return nil, err
+		}
+		d[t] = child
+		return d, nil
+	case []interface{}:
+		i, err := arrayIndex(t, len(d), last && add)
+		if err != nil {
+			return nil, err
+		}
+		if last && add {
+			d = append(d, nil)
+			copy(d[i+1:], d[i:])
+			d[i] = value
+			return d, nil
+		}
+		if last {
+			d[i] = value
+			return d, nil
+		}
+
+		child, err := pointerSet(d[i], tokens[1:], value, add)
+		if err != nil {
+			return nil, err
+		}
+		d[i] = child
+		return d, nil
+	}
+
+	return nil, fmt.Errorf("path '%s' not found", t)
+}
+
+func pointerRemove(doc interface{}, tokens []string) (interface{}, error) {
+	if len(tokens) == 0 {
+		return nil, errors.New("cannot remove the whole document")
+	}
+
+	t, last := tokens[0], len(tokens) == 1
+	switch d := doc.(type) {
+	case map[string]interface{}:
+		child, ok := d[t]
+		if !ok {
+			return nil, fmt.Errorf("path '%s' not found", t)
+		}
+		if last {
+			delete(d, t)
+			return d, nil
+		}
+
+		child, err := pointerRemove(child, tokens[1:])
+		if err != nil {
+			return nil, err
+		}
+		d[t] = child
+		return d, nil
+	case []interface{}:
+		i, err := arrayIndex(t, len(d), false)
+		if err != nil {
+			return nil, err
+		}
+		if last {
+			return append(d[:i], d[i+1:]...), nil
+		}
+
+		child, err := pointerRemove(d[i], tokens[1:])
+		if err != nil {
+			return nil, err
+		}
+		d[i] = child
+		return d, nil
+	}
+
+	return nil, fmt.Errorf("path '%s' not found", t)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestJSONPatch(t *testing.T) {
+	cases := []struct {
+		doc, patch, expected string
+	}{
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+		{`{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":"baz"}]`, `{"foo":["bar","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{`{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`, `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{`{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`},
+		{`{"foo":{"bar":1}}`, `[{"op":"copy","from":"/foo","path":"/baz"}]`, `{"baz":{"bar":1},"foo":{"bar":1}}`},
+		{`{"a/b":1,"m~n":2}`, `[{"op":"test","path":"/a~1b","value":1},{"op":"replace","path":"/m~0n","value":null}]`, `{"a/b":1,"m~n":null}`},
+	}
+
+	for _, c := range cases {
+		var doc, expected interface{}
+		var ops []PatchOperation
+		json.Unmarshal([]byte(c.doc), &doc)
+		json.Unmarshal([]byte(c.patch), &ops)
+		json.Unmarshal([]byte(c.expected), &expected)
+
+		result, err := JSONPatch(doc, ops)
+		if err != nil {
+			t.Errorf("Expected %s to apply. Got %v", c.patch, err)
+			continue
+		}
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("Expected %s. Got %v", c.expected, result)
+		}
+	}
+}
+
+func TestJSONPatchErrors(t *testing.T) {
+	cases := []struct {
+		doc, patch string
+	}{
+		{`{"foo":"bar"}`, `[{"op":"replace","path":"/baz","value":1}]`},
+		{`{"foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/5","value":1}]`},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/01","value":1}]`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz"}]`},
+		{`{"foo":"bar"}`, `[{"op":"launch","path":"/foo"}]`},
+		{`{"foo":{"bar":1}}`, `[{"op":"move","from":"/foo","path":"/foo/bar"}]`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"foo","value":1}]`},
+	}
+
+	for _, c := range cases {
+		var doc interface{}
+		var ops []PatchOperation
+		json.Unmarshal([]byte(c.doc), &doc)
+		json.Unmarshal([]byte(c.patch), &ops)
+
+		if _, err := JSONPatch(doc, ops); err == nil {
+			t.Errorf("Expected %s to fail on %s", c.patch, c.doc)
+		}
+	}
+
+	var doc interface{}
+	var ops []PatchOperation
+	json.Unmarshal([]byte(`{"foo":"bar"}`), &doc)
+	json.Unmarshal([]byte(`[{"op":"test","path":"/foo","value":"baz"}]`), &ops)
+	if _, err := JSONPatch(doc, ops); err != errPatchTestFailed {
+		t.Errorf("Expected a failed test operation. Got %v", err)
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	cases := []struct {
+		target, patch, expected string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+	}
+
+	for _, c := range cases {
+		var target, patch, expected interface{}
+		json.Unmarshal([]byte(c.target), &target)
+		json.Unmarshal([]byte(c.patch), &patch)
+		json.Unmarshal([]byte(c.expected), &expected)
+
+		if result := MergePatch(target, patch); !reflect.DeepEqual(result, expected) {
+			t.Errorf("Expected %s. Got %v", c.expected, result)
+		}
+	}
+}
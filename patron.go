@@ -0,0 +1,284 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// DefaultBorrowingLimit books a new patron may have out at once when the
+// request doesn't say
+const DefaultBorrowingLimit = 5
+
+// DefaultCardValidity how long a new library card lasts when the request
+// doesn't give an expiry date
+const DefaultCardValidity = 365 * 24 * time.Hour
+
+var (
+	// ErrDuplicateCardNumber returned when a card number is already issued
+	ErrDuplicateCardNumber = errors.New("card number is already issued")
+
+	// ErrPatronSuspended returned when a suspended patron borrows
+	ErrPatronSuspended = errors.New("patron is suspended")
+
+	// ErrPatronExpired returned when a patron whose card expired borrows
+	ErrPatronExpired = errors.New("patron's card has expired")
+
+	// ErrLoanLimit returned when a patron borrows past their limit
+	ErrLoanLimit = errors.New("patron has reached their borrowing limit")
+
+	// ErrPatronHasLoans returned when deleting a patron with books out
+	ErrPatronHasLoans = errors.New("patron still has books checked out")
+)
+
+// Patron a library member, identified by the number on their card
+type Patron struct {
+	ID             int          `json:"id,omitempty"`
+	CardNumber     string       `json:"cardNumber,omitempty" db:"card_number"`
+	Name           string       `json:"name,omitempty"`
+	Email          string       `json:"email,omitempty"`
+	Phone          string       `json:"phone,omitempty"`
+	Status         PatronStatus `json:"status,omitempty"`
+	ExpiresAt      time.Time    `json:"expiresAt" db:"expires_at"`
+	BorrowingLimit int          `json:"borrowingLimit" db:"borrowing_limit"`
+	Version        int          `json:"-"`
+}
+
+// PatronStatus whether a patron may borrow
+type PatronStatus string
+
+// valid patron statuses
+const (
+	PatronActive    PatronStatus = "active"
+	PatronSuspended PatronStatus = "suspended"
+	PatronExpired   PatronStatus = "expired"
+)
+
+// PatronStatuses every valid patron status
+var PatronStatuses = []PatronStatus{PatronActive, PatronSuspended, PatronExpired}
+
+const patronColumns = "id, card_number, name, email, phone, status, expires_at, borrowing_limit, version"
+
+// patronFields json fields a patch may change and the columns behind them
+var patronFields = map[string]string{
+	"cardNumber":     "card_number",
+	"name":           "name",
+	"email":          "email",
+	"phone":          "phone",
+	"status":         "status",
+	"expiresAt":      "expires_at",
+	"borrowingLimit": "borrowing_limit",
+}
+
+// patronSortColumns columns patrons can be sorted and paged by
+var patronSortColumns = []string{"id", "name", "card_number", "expires_at"}
+
+// SortKey value of a sortable column
+func (p Patron) SortKey(column string) interface{} {
+	switch column {
+	case "id":
+		return p.ID
+	case "name":
+		return p.Name
+	case "card_number":
+		return p.CardNumber
+	case "expires_at":
+		return p.ExpiresAt
+	}
+
+	return nil
+}
+
+// Defaults fills in what a new patron may leave out
+func (p *Patron) Defaults(now time.Time) {
+	if p.Status == "" {
+		p.Status = PatronActive
+	}
+	if p.ExpiresAt.IsZero() {
+		p.ExpiresAt = now.Add(DefaultCardValidity)
+	}
+	if p.BorrowingLimit == 0 {
+		p.BorrowingLimit = DefaultBorrowingLimit
+	}
+}
+
+// CanBorrow reports why p may not borrow at now, given the number of books
+// they already have out
+func (p Patron) CanBorrow(now time.Time, open int) error {
+	if err := p.CanHold(now); err != nil {
+		return err
+	}
+	if open >= p.BorrowingLimit {
+		return ErrLoanLimit
+	}
+
+	return nil
+}
+
+// CanHold reports why p may not get in line for a book at now. Patrons at
+// their borrowing limit may still queue.
+func (p Patron) CanHold(now time.Time) error {
+	switch {
+	case p.Status == PatronSuspended:
+		return ErrPatronSuspended
+	case p.Status == PatronExpired || !now.Before(p.ExpiresAt):
+		return ErrPatronExpired
+	}
+
+	return nil
+}
+
+// uniqueCardNumber reports a duplicate card_number as ErrDuplicateCardNumber
+func uniqueCardNumber(err error) error {
+	if e, ok := err.(*pq.Error); ok && e.Code == pqUniqueViolation {
+		return ErrDuplicateCardNumber
+	}
+
+	return err
+}
+
+// GetPatron returns a patron
+func (p *Patron) GetPatron(db *sqlx.DB) error {
+	patron := Patron{}
+	if err := db.Get(&patron, "SELECT "+patronColumns+" FROM patrons WHERE id=$1", p.ID); err != nil {
+		return err
+	}
+
+	*p = patron
+	return nil
+}
+
+// UpdatePatron updates a patron
+func (p *Patron) UpdatePatron(db *sqlx.DB) error {
+	patron := Patron{}
+	err := db.Get(&patron, "UPDATE patrons SET card_number=$1, name=$2, email=$3, phone=$4, status=$5, expires_at=$6, borrowing_limit=$7, version=version+1 WHERE id=$8 AND ($9=0 OR version=$9) RETURNING "+patronColumns,
+		p.CardNumber, p.Name, p.Email, p.Phone, p.Status, p.ExpiresAt, p.BorrowingLimit, p.ID, p.Version)
+	if err == sql.ErrNoRows {
+		return missingOrStale(db, "patrons", p.ID)
+	}
+	if err != nil {
+		return uniqueCardNumber(err)
+	}
+
+	*p = patron
+	return nil
+}
+
+// PatchPatron updates only the given columns of a patron
+func (p *Patron) PatchPatron(db *sqlx.DB, columns []string) error {
+	patron := Patron{}
+	if err := patchRow(db, &patron, "patrons", patronColumns, p.ID, p.Version, columns, p); err != nil {
+		return uniqueCardNumber(err)
+	}
+
+	*p = patron
+	return nil
+}
+
+// DeletePatron removes a patron who has nothing checked out and no ledger,
+// keeping their past loans without saying whose they were
+func (p *Patron) DeletePatron(db *sqlx.DB) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var version int
+	if err := tx.Get(&version, "SELECT version FROM patrons WHERE id=$1 FOR NO KEY UPDATE", p.ID); err != nil {
+		return err
+	}
+	if p.Version != 0 && p.Version != version {
+		return ErrVersionMismatch
+	}
+
+	var open int
+	if err := tx.Get(&open, "SELECT COUNT(*) FROM loans WHERE patron_id=$1 AND returned_at IS NULL", p.ID); err != nil {
+		return err
+	}
+	if open > 0 {
+		return ErrPatronHasLoans
+	}
+	var balance int
+	if err := tx.Get(&balance, balanceQuery, p.ID); err != nil {
+		return err
+	}
+	if balance != 0 {
+		return ErrPatronOwes
+	}
+	var ledger bool
+	if err := tx.Get(&ledger, "SELECT EXISTS (SELECT 1 FROM account_entries WHERE patron_id=$1)", p.ID); err != nil {
+		return err
+	}
+	if ledger {
+		return ErrPatronHasLedger
+	}
+
+	books := []int{}
+	if err := tx.Select(&books, "SELECT DISTINCT book_id FROM holds WHERE patron_id=$1 AND status IN ('waiting', 'ready') ORDER BY book_id", p.ID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM patrons WHERE id=$1", p.ID); err != nil {
+		return err
+	}
+
+	// copies set aside for the patron's holds go to the next in line
+	for _, id := range books {
+		if err := lockBook(tx, id); err != nil {
+			return err
+		}
+		if err := advanceHolds(tx, id); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// CreatePatron inserts a new patron
+func (p *Patron) CreatePatron(db *sqlx.DB) error {
+	patron := Patron{}
+	err := db.Get(&patron, "INSERT INTO patrons (card_number, name, email, phone, status, expires_at, borrowing_limit) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING "+patronColumns,
+		p.CardNumber, p.Name, p.Email, p.Phone, p.Status, p.ExpiresAt, p.BorrowingLimit)
+	if err != nil {
+		return uniqueCardNumber(err)
+	}
+
+	*p = patron
+	return nil
+}
+
+// GetPatrons returns a page of patrons and the total count
+func GetPatrons(db *sqlx.DB, opts ListOptions) ([]Patron, int, error) {
+	var total int
+	count, countArgs := countQuery("patrons", opts)
+	if err := db.Get(&total, count, countArgs...); err != nil {
+		return nil, 0, err
+	}
+
+	patrons := []Patron{}
+	query, args := listQuery(patronColumns, "patrons", opts)
+	if err := db.Select(&patrons, query, args...); err != nil {
+		return nil, 0, err
+	}
+
+	return patrons, total, nil
+}
+
+// GetPatronLoans returns every loan of p, the latest first, only those of
+// copies shelved at branchID when it isn't 0
+func GetPatronLoans(db *sqlx.DB, p *Patron, branchID int) ([]Loan, error) {
+	if err := p.GetPatron(db); err != nil {
+		return nil, err
+	}
+
+	loans := []Loan{}
+	if err := db.Select(&loans, "SELECT "+loanColumns+" FROM loans WHERE patron_id=$1 AND "+atBranch("item_id", branchID)+" ORDER BY checked_out_at DESC, id DESC", p.ID); err != nil {
+		return nil, err
+	}
+
+	return loans, nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCanBorrow(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	p := Patron{}
+	p.Defaults(now)
+	if p.Status != PatronActive || !p.ExpiresAt.Equal(now.Add(DefaultCardValidity)) || p.BorrowingLimit != DefaultBorrowingLimit {
+		t.Fatalf("Expected an active patron with the default card and limit. Got %+v", p)
+	}
+
+	cases := []struct {
+		status  PatronStatus
+		expires time.Time
+		open    int
+		err     error
+	}{
+		{PatronActive, now.Add(time.Hour), 0, nil},
+		{PatronActive, now.Add(time.Hour), DefaultBorrowingLimit - 1, nil},
+		{PatronActive, now.Add(time.Hour), DefaultBorrowingLimit, ErrLoanLimit},
+		{PatronActive, now, 0, ErrPatronExpired},
+		{PatronExpired, now.Add(time.Hour), 0, ErrPatronExpired},
+		{PatronSuspended, now.Add(time.Hour), 0, ErrPatronSuspended},
+	}
+
+	for _, c := range cases {
+		p := Patron{Status: c.status, ExpiresAt: c.expires, BorrowingLimit: DefaultBorrowingLimit}
+		if err := p.CanBorrow(now, c.open); err != c.err {
+			t.Errorf("Expected %+v with %d out to give %v. Got %v", p, c.open, c.err, err)
+		}
+	}
+}
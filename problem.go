@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// problem types beyond the plain HTTP status, see RFC 7807
+const (
+	ProblemTypeBlank            = "about:blank"
+	ProblemTypeValidation       = "/problems/validation-failed"
+	ProblemTypeUniqueViolation  = "/problems/unique-violation"
+	ProblemTypeForeignKey       = "/problems/foreign-key-violation"
+	ProblemTypeNotNullViolation = "/problems/not-null-violation"
+	ProblemTypeCheckViolation   = "/problems/check-violation"
+	ProblemTypeCirculation      = "/problems/circulation-refused"
+)
+
+// postgres error codes mapped to problems
+const (
+	pqNotNullViolation    = "23502"
+	pqForeignKeyViolation = "23503"
+	pqUniqueViolation     = "23505"
+	pqCheckViolation      = "23514"
+)
+
+// RequestIDHeader carries the request id to and from clients
+const RequestIDHeader = "X-Request-ID"
+
+type contextKey string
+
+const requestIDKey contextKey = "requestID"
+
+// Problem RFC 7807 error response
+type Problem struct {
+	Type      string           `json:"type"`
+	Title     string           `json:"title"`
+	Status    int              `json:"status"`
+	Detail    string           `json:"detail,omitempty"`
+	Instance  string           `json:"instance,omitempty"`
+	RequestID string           `json:"requestId,omitempty"`
+	Errors    ValidationErrors `json:"errors,omitempty"`
+
+	// ReferencedBy books blocking a delete
+	ReferencedBy []BookRef `json:"referencedBy,omitempty"`
+}
+
+// NewProblem a problem for a plain HTTP status
+func NewProblem(status int, detail string) *Problem {
+	return &Problem{Type: ProblemTypeBlank, Title: http.StatusText(status), Status: status, Detail: detail}
+}
+
+// Error lets handlers and stores return a Problem as an error
+func (p *Problem) Error() string {
+	if p.Detail != "" {
+		return p.Detail
+	}
+
+	return p.Title
+}
+
+// checkConstraints the field each check constraint guards, by the name
+// postgres gives it, so violations are reported without the database's
+// own wording
+var checkConstraints = map[string]FieldError{
+	"book_contributors_role_check":  {Field: "role", Code: CodeInvalid, Message: "role is not a known role"},
+	"editions_isbn13_check":         {Field: "isbn13", Code: CodeInvalid, Message: "isbn13 is not a valid ISBN"},
+	"loans_check":                   {Field: "dueAt", Code: CodeOutOfRange, Message: "dueAt must be after checkedOutAt"},
+	"loans_check1":                  {Field: "returnedAt", Code: CodeOutOfRange, Message: "returnedAt must not be before checkedOutAt"},
+	"patrons_status_check":          {Field: "status", Code: CodeInvalid, Message: "status is not a known patron status"},
+	"patrons_borrowing_limit_check": {Field: "borrowingLimit", Code: CodeOutOfRange, Message: "borrowingLimit must not be negative"},
+	"holds_status_check":            {Field: "status", Code: CodeInvalid, Message: "status is not a known hold status"},
+	"holds_check":                   {Field: "expiresAt", Code: CodeRequired, Message: "expiresAt is required once a hold is ready"},
+	"account_entries_kind_check":    {Field: "kind", Code: CodeInvalid, Message: "kind is not a known entry kind"},
+	"account_entries_amount_check":  {Field: "amount", Code: CodeOutOfRange, Message: "amount must be positive"},
+	"items_condition_check":         {Field: "condition", Code: CodeInvalid, Message: "condition is not a known condition"},
+	"transfers_status_check":        {Field: "status", Code: CodeInvalid, Message: "status is not a known transfer status"},
+	"transfers_check":               {Field: "toBranchId", Code: CodeInvalid, Message: "toBranchId must differ from fromBranchId"},
+	"transfer_events_status_check":  {Field: "status", Code: CodeInvalid, Message: "status is not a known transfer status"},
+}
+
+// circulationRefusals errors for checkouts, checkins, holds, transfers and
+// deletions that circulation rules forbid
+var circulationRefusals = []error{ErrCheckedOut, ErrNotCheckedOut, ErrPatronSuspended, ErrPatronExpired, ErrLoanLimit, ErrPatronHasLoans,
+	ErrBookAvailable, ErrDuplicateHold, ErrAlreadyBorrowed, ErrOnHold, ErrHoldClosed, ErrFinesOwed, ErrPatronOwes, ErrPatronHasLedger,
+	ErrItemOnLoan, ErrItemOnHold, ErrItemHasLoans, ErrNoCopies, ErrItemInTransit, ErrTransferOpen, ErrSameBranch, ErrTransferState}
+
+// ProblemFor maps err to the problem reported to clients. Errors that
+// aren't recognized become a 500 without any detail.
+func ProblemFor(err error) *Problem {
+	if err == ErrVersionMismatch {
+		return NewProblem(http.StatusPreconditionFailed, "The record has changed since it was read")
+	}
+	if err == ErrDuplicateISBN {
+		return &Problem{Type: ProblemTypeUniqueViolation, Title: "Duplicate record", Status: http.StatusConflict, Detail: "Another edition already has this ISBN"}
+	}
+	if err == ErrDuplicateCardNumber {
+		return &Problem{Type: ProblemTypeUniqueViolation, Title: "Duplicate record", Status: http.StatusConflict, Detail: "Another patron already has this card number"}
+	}
+	if err == ErrDuplicateBarcode {
+		return &Problem{Type: ProblemTypeUniqueViolation, Title: "Duplicate record", Status: http.StatusConflict, Detail: "Another copy already has this barcode"}
+	}
+	if err == ErrDuplicateBranchCode {
+		return &Problem{Type: ProblemTypeUniqueViolation, Title: "Duplicate record", Status: http.StatusConflict, Detail: "Another branch already has this code"}
+	}
+	if err == ErrBranchInUse {
+		return &Problem{Type: ProblemTypeForeignKey, Title: "Record is still referenced", Status: http.StatusConflict, Detail: "Copies, holds or transfers still refer to this branch"}
+	}
+	for _, refusal := range circulationRefusals {
+		if err == refusal {
+			return &Problem{Type: ProblemTypeCirculation, Title: "Circulation refused", Status: http.StatusConflict, Detail: "The " + err.Error()}
+		}
+	}
+
+	switch e := err.(type) {
+	case *Problem:
+		p := *e
+		return &p
+	case ValidationErrors:
+		return &Problem{
+			Type:   ProblemTypeValidation,
+			Title:  "Validation failed",
+			Status: http.StatusUnprocessableEntity,
+			Detail: "The request payload has invalid fields",
+			Errors: e,
+		}
+	case *ReferencedError:
+		return &Problem{
+			Type:         ProblemTypeForeignKey,
+			Title:        "Record is still referenced",
+			Status:       http.StatusConflict,
+			Detail:       "Books still refer to this record, delete with cascade=detach or cascade=delete",
+			ReferencedBy: e.Books,
+		}
+	case *pq.Error:
+		switch e.Code {
+		case pqUniqueViolation:
+			return &Problem{Type: ProblemTypeUniqueViolation, Title: "Duplicate record", Status: http.StatusConflict, Detail: e.Detail}
+		case pqForeignKeyViolation:
+			// deleting a referenced row conflicts with its referrers, while
+			// writing a reference to a missing row is a bad payload
+			if strings.Contains(e.Detail, "still referenced") {
+				return &Problem{Type: ProblemTypeForeignKey, Title: "Record is still referenced", Status: http.StatusConflict, Detail: e.Detail}
+			}
+			return &Problem{Type: ProblemTypeForeignKey, Title: "Referenced record not found", Status: http.StatusUnprocessableEntity, Detail: e.Detail}
+		case pqNotNullViolation:
+			return &Problem{Type: ProblemTypeNotNullViolation, Title: "Missing required field", Status: http.StatusUnprocessableEntity, Detail: "Column " + e.Column + " is required"}
+		case pqCheckViolation:
+			p := &Problem{Type: ProblemTypeCheckViolation, Title: "Invalid field", Status: http.StatusUnprocessableEntity, Detail: "The request payload has an invalid field"}
+			if field, ok := checkConstraints[e.Constraint]; ok {
+				p.Detail = field.Message
+				p.Errors = ValidationErrors{field}
+			}
+			return p
+		}
+	}
+
+	return NewProblem(http.StatusInternalServerError, "")
+}
+
+// RespondWithProblem application/problem+json response for err. Server
+// errors and check violations, whose database error isn't sent to clients,
+// are logged with the request id.
+func RespondWithProblem(w http.ResponseWriter, r *http.Request, err error) {
+	p := ProblemFor(err)
+	p.Instance = r.URL.Path
+	p.RequestID = RequestID(r)
+
+	if p.Status >= http.StatusInternalServerError || p.Type == ProblemTypeCheckViolation {
+		log.Printf("request %s %s %s: %v", p.RequestID, r.Method, r.URL.Path, err)
+	}
+
+	response, _ := json.Marshal(p)
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	w.Write(response)
+}
+
+// RequestID returns the id assigned to r by RequestIDMiddleware
+func RequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey).(string)
+	return id
+}
+
+// RequestIDMiddleware tags each request with the client's X-Request-ID or
+// a random one, and echoes it in the response
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if id == "" {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+	})
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestProblemFor(t *testing.T) {
+	cases := []struct {
+		err    error
+		status int
+		detail string
+	}{
+		{&pq.Error{Code: pqUniqueViolation, Detail: "Key (name)=(Penguin) already exists."}, http.StatusConflict, "Key (name)=(Penguin) already exists."},
+		{&pq.Error{Code: pqForeignKeyViolation, Detail: `Key (id)=(1) is still referenced from table "books".`}, http.StatusConflict, `Key (id)=(1) is still referenced from table "books".`},
+		{&pq.Error{Code: pqForeignKeyViolation, Detail: `Key (author_id)=(9) is not present in table "authors".`}, http.StatusUnprocessableEntity, `Key (author_id)=(9) is not present in table "authors".`},
+		{&pq.Error{Code: pqNotNullViolation, Column: "title"}, http.StatusUnprocessableEntity, "Column title is required"},
+		{&pq.Error{Code: pqCheckViolation, Constraint: "items_condition_check", Message: `new row for relation "items" violates check constraint "items_condition_check"`},
+			http.StatusUnprocessableEntity, "condition is not a known condition"},
+		{&pq.Error{Code: pqCheckViolation, Constraint: "books_rating_check", Message: `new row for relation "books" violates check constraint "books_rating_check"`},
+			http.StatusUnprocessableEntity, "The request payload has an invalid field"},
+		{ValidationErrors{{Field: "title", Code: CodeRequired}}, http.StatusUnprocessableEntity, "The request payload has invalid fields"},
+		{errors.New(`pq: relation "books" does not exist`), http.StatusInternalServerError, ""},
+	}
+
+	for _, c := range cases {
+		p := ProblemFor(c.err)
+		if p.Status != c.status || p.Detail != c.detail {
+			t.Errorf("Expected %d '%s' for %v. Got %d '%s'", c.status, c.detail, c.err, p.Status, p.Detail)
+		}
+	}
+}
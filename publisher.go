@@ -1,51 +1,123 @@
 package main
 
 import (
+	"database/sql"
+
 	"github.com/jmoiron/sqlx"
 )
 
 // Publisher model
 type Publisher struct {
-	ID   int    `json:"id,omitempty"`
-	Name string `json:"name,omitempty"`
+	ID      int    `json:"id,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Version int    `json:"-"`
+}
+
+const publisherColumns = "id, name, version"
+
+// publisherFields json fields a patch may change and the columns behind them
+var publisherFields = map[string]string{
+	"name": "name",
+}
+
+// publisherSortColumns columns publishers can be sorted and paged by
+var publisherSortColumns = []string{"id", "name"}
+
+// SortKey value of a sortable column
+func (p Publisher) SortKey(column string) interface{} {
+	switch column {
+	case "id":
+		return p.ID
+	case "name":
+		return p.Name
+	}
+
+	return nil
 }
 
-// GetPublisher returns a publisher
-func (p *Publisher) GetPublisher(db *sqlx.DB) error {
+// GetPublisher returns a publisher, reading only columns of it unless they
+// are nil
+func (p *Publisher) GetPublisher(db *sqlx.DB, columns []string) error {
 	publisher := Publisher{}
-	err := db.Get(&publisher, "SELECT * FROM publishers WHERE id=$1", p.ID)
+	err := db.Get(&publisher, "SELECT "+projection(publisherColumns, columns)+" FROM publishers WHERE id=$1", p.ID)
+	if err != nil {
+		return err
+	}
 
-	return err
+	*p = publisher
+	return nil
 }
 
 // UpdatePublisher updates a publisher record
 func (p *Publisher) UpdatePublisher(db *sqlx.DB) error {
-	_, err := db.Exec("UPDATE publishers set name=$1 WHERE id=$2", p.Name, p.ID)
+	publisher := Publisher{}
+	err := db.Get(&publisher, "UPDATE publishers set name=$1, version=version+1 WHERE id=$2 AND ($3=0 OR version=$3) RETURNING "+publisherColumns, p.Name, p.ID, p.Version)
+	if err == sql.ErrNoRows {
+		return missingOrStale(db, "publishers", p.ID)
+	}
+	if err != nil {
+		return err
+	}
 
-	return err
+	*p = publisher
+	return nil
 }
 
-// DeletePublisher removes a publisher record
-func (p *Publisher) DeletePublisher(db *sqlx.DB) error {
-	_, err := db.Exec("DELETE FROM publishers WHERE id=$1", p.ID)
+// PatchPublisher updates only the given columns of a publisher record
+func (p *Publisher) PatchPublisher(db *sqlx.DB, columns []string) error {
+	publisher := Publisher{}
+	if err := patchRow(db, &publisher, "publishers", publisherColumns, p.ID, p.Version, columns, p); err != nil {
+		return err
+	}
+
+	*p = publisher
+	return nil
+}
 
-	return err
+// DeletePublisher removes a publisher record
+func (p *Publisher) DeletePublisher(db *sqlx.DB, cascade Cascade) error {
+	return deleteReferenced(db, "publishers", "publisher_id", "publisher_id=$1", p.ID, p.Version, cascade)
 }
 
 // CreatePublisher inserts a new pusblisher into db
 func (p *Publisher) CreatePublisher(db *sqlx.DB) error {
-	_, err := db.Exec("INSERT INTO publishers (name) VALUES ($1)", p.Name)
-	return err
+	publisher := Publisher{}
+	err := db.Get(&publisher, "INSERT INTO publishers (name) VALUES ($1) RETURNING "+publisherColumns, p.Name)
+	if err != nil {
+		return err
+	}
+
+	*p = publisher
+	return nil
 }
 
-// GetPublishers returns all publishers
-func GetPublishers(db *sqlx.DB, start, count int) ([]Publisher, error) {
+// GetPublishers returns a page of publishers and the total count
+func GetPublishers(db *sqlx.DB, opts ListOptions) ([]Publisher, int, error) {
+	columns := projection(publisherColumns, opts.Columns)
+
+	if opts.Match != nil {
+		publishers := []Publisher{}
+		total, err := publisherMatcher.List(db, &publishers, columns, "publishers", opts)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		return publishers, total, nil
+	}
+
+	var total int
+	count, countArgs := countQuery("publishers", opts)
+	if err := db.Get(&total, count, countArgs...); err != nil {
+		return nil, 0, err
+	}
+
 	publishers := []Publisher{}
-	err := db.Select(&publishers, "SELECT id, name FROM publishers")
+	query, args := listQuery(columns, "publishers", opts)
+	err := db.Select(&publishers, query, args...)
 
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
-	return publishers, nil
+	return publishers, total, nil
 }
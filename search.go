@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// SearchResult a book, author or publisher matching a search. Highlight is
+// the matched text with the matching words wrapped in <mark> tags.
+type SearchResult struct {
+	Type      string  `json:"type"`
+	ID        int     `json:"id"`
+	Title     string  `json:"title"`
+	Highlight string  `json:"highlight"`
+	Rank      float64 `json:"rank"`
+}
+
+// searchTypes types of record a search can return
+var searchTypes = []string{"book", "author", "publisher"}
+
+// ParseSearchTypes reads a comma separated list of types restricted to
+// searchTypes, all of them when s is empty
+func ParseSearchTypes(s string) ([]string, error) {
+	return parseTypes(s, searchTypes, "search type")
+}
+
+// parseTypes reads a comma separated list of types restricted to allowed,
+// all of them when s is empty. kind names the types in errors.
+func parseTypes(s string, allowed []string, kind string) ([]string, error) {
+	if s == "" {
+		return allowed, nil
+	}
+
+	types := []string{}
+	for _, part := range strings.Split(s, ",") {
+		t := strings.TrimSpace(part)
+
+		ok := false
+		for _, name := range allowed {
+			if name == t {
+				ok = true
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("Invalid %s '%s'", kind, t)
+		}
+
+		types = append(types, t)
+	}
+
+	return types, nil
+}
+
+// searchSources the query over each type's tsvector, all taking the
+// tsquery as q
+var searchSources = map[string]string{
+	"book": `SELECT 'book' AS type, id, title, title AS body, ts_rank(search, q) AS rank
+		FROM books, q WHERE search @@ q`,
+	"author": `SELECT 'author' AS type, id, concat_ws(' ', first_name, last_name) AS title,
+		concat_ws(' ', first_name, last_name, pen_name) AS body, ts_rank(search, q) AS rank
+		FROM authors, q WHERE search @@ q`,
+	"publisher": `SELECT 'publisher' AS type, id, name AS title, name AS body, ts_rank(search, q) AS rank
+		FROM publishers, q WHERE search @@ q`,
+}
+
+// searchQuery the matches of every type in types, with the query text as $1
+func searchQuery(types []string) string {
+	sources := []string{}
+	for _, t := range types {
+		sources = append(sources, searchSources[t])
+	}
+
+	return "WITH q AS (SELECT websearch_to_tsquery('english', $1) AS q) SELECT %s FROM (" + strings.Join(sources, " UNION ALL ") + ") matches"
+}
+
+// Search returns a page of the records of types matching the web search
+// style query text, best first, the total number of matches and the
+// matching books counted by each of opts.Facets, all read in one
+// transaction
+func Search(db *sqlx.DB, text string, types []string, opts ListOptions) ([]SearchResult, int, Facets, error) {
+	tx, err := beginRead(db)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	defer tx.Rollback()
+
+	query := searchQuery(types)
+
+	var total int
+	if err := tx.Get(&total, fmt.Sprintf(query, "COUNT(*)"), text); err != nil {
+		return nil, 0, nil, err
+	}
+
+	results := []SearchResult{}
+	columns := "type, id, title, ts_headline('english', body, (SELECT q FROM q), 'StartSel=<mark>, StopSel=</mark>, HighlightAll=true') AS highlight, rank"
+	err = tx.Select(&results, fmt.Sprintf(query, columns)+" ORDER BY rank DESC, type, id LIMIT $2 OFFSET $3", text, opts.Count, opts.Start)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	// only books have facets, so other types count nothing
+	facets := facetCounter{}.facets(opts.Facets)
+	if searchesBooks(types) {
+		matches := "SELECT books.* FROM books WHERE search @@ websearch_to_tsquery('english', $1)"
+		if facets, err = bookFacetCounts(tx, opts.Facets, matches, []interface{}{text}); err != nil {
+			return nil, 0, nil, err
+		}
+	}
+
+	return results, total, facets, nil
+}
+
+// searchesBooks reports whether types includes books
+func searchesBooks(types []string) bool {
+	for _, t := range types {
+		if t == "book" {
+			return true
+		}
+	}
+
+	return false
+}
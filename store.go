@@ -0,0 +1,749 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/reflectx"
+	_ "github.com/lib/pq"
+)
+
+// BookStore persists books
+type BookStore interface {
+	GetBook(b *Book, expand Expand, columns []string) error
+	GetBooks(opts ListOptions) ([]Book, int, Facets, error)
+	CreateBook(b *Book) error
+	UpdateBook(b *Book) error
+	PatchBook(b *Book, columns []string) error
+	DeleteBook(b *Book) error
+}
+
+// ContributorStore persists the authors credited on each book
+type ContributorStore interface {
+	GetContributors(b *Book, expand Expand) ([]Contributor, error)
+	SetContributors(b *Book, contributors []Contributor) ([]Contributor, error)
+}
+
+// EditionStore persists the editions of each book
+type EditionStore interface {
+	GetEdition(e *Edition) error
+	GetEditionByISBN(e *Edition) error
+	GetEditions(b *Book) ([]Edition, error)
+	CreateEdition(e *Edition) error
+	UpdateEdition(e *Edition) error
+	DeleteEdition(e *Edition) error
+}
+
+// ItemStore persists the copies of each book
+type ItemStore interface {
+	GetItem(i *Item) error
+	GetItemByBarcode(i *Item) error
+	GetItems(b *Book) ([]Item, error)
+	CreateItem(i *Item) error
+	UpdateItem(i *Item) error
+	DeleteItem(i *Item) error
+}
+
+// BranchStore persists the branches copies are shelved at
+type BranchStore interface {
+	GetBranch(b *Branch) error
+	GetBranches(opts ListOptions) ([]Branch, int, error)
+	CreateBranch(b *Branch) error
+	UpdateBranch(b *Branch) error
+	DeleteBranch(b *Branch) error
+}
+
+// TransferStore moves copies between branches, keeping a trail of each
+// step
+type TransferStore interface {
+	RequestTransfer(t *Transfer, note string) error
+	MoveTransfer(t *Transfer, status TransferStatus, note string) error
+	GetTransfer(t *Transfer) error
+	GetTransfers(opts ListOptions) ([]Transfer, int, error)
+}
+
+// SearchStore finds books, authors and publishers by text
+type SearchStore interface {
+	Search(text string, types []string, opts ListOptions) ([]SearchResult, int, Facets, error)
+}
+
+// LoanStore lends copies of books out and takes them back, an item with
+// no ID leaves the copy to the store. Listings given a branchID only
+// include copies shelved there.
+type LoanStore interface {
+	Checkout(b *Book, item *Item, p *Patron, period time.Duration, fines FineSchedule, maxBalance int) (Loan, error)
+	Checkin(b *Book, item *Item, fines FineSchedule) (Loan, error)
+	GetLoans(b *Book, branchID int) ([]Loan, error)
+}
+
+// HoldStore queues patrons for books that are out, a branch with no ID
+// lets a hold be picked up anywhere. Listings given a branchID only include
+// holds with a copy set aside there or waiting to be picked up there.
+type HoldStore interface {
+	PlaceHold(b *Book, p *Patron, branch *Branch, pickup time.Duration) (Hold, error)
+	GetHolds(b *Book, branchID int) ([]Hold, error)
+	GetHold(h *Hold) error
+	CancelHold(h *Hold) error
+	GetPatronHolds(p *Patron, branchID int) ([]Hold, error)
+}
+
+// AccountStore keeps patrons' ledgers of fines and what settled them
+type AccountStore interface {
+	GetAccount(p *Patron, branchID int) (Account, error)
+	AddEntry(e *AccountEntry) error
+}
+
+// SuggestStore completes names as they are typed
+type SuggestStore interface {
+	Suggest(prefix string, types []string, limit int) ([]Suggestion, error)
+}
+
+// MatchStore finds records similar to a text despite misspellings
+type MatchStore interface {
+	MatchAuthors(m *Match, limit int) ([]Matched, error)
+	MatchBooks(m *Match, limit int) ([]Matched, error)
+}
+
+// AuthorStore persists authors
+type AuthorStore interface {
+	GetAuthor(author *Author, columns []string) error
+	GetAuthors(opts ListOptions) ([]Author, int, error)
+	CreateAuthor(author *Author) error
+	UpdateAuthor(author *Author) error
+	PatchAuthor(author *Author, columns []string) error
+	DeleteAuthor(author *Author, cascade Cascade) error
+}
+
+// PublisherStore persists publishers
+type PublisherStore interface {
+	GetPublisher(p *Publisher, columns []string) error
+	GetPublishers(opts ListOptions) ([]Publisher, int, error)
+	CreatePublisher(p *Publisher) error
+	UpdatePublisher(p *Publisher) error
+	PatchPublisher(p *Publisher, columns []string) error
+	DeletePublisher(p *Publisher, cascade Cascade) error
+}
+
+// PatronStore persists patrons
+type PatronStore interface {
+	GetPatron(p *Patron) error
+	GetPatrons(opts ListOptions) ([]Patron, int, error)
+	CreatePatron(p *Patron) error
+	UpdatePatron(p *Patron) error
+	PatchPatron(p *Patron, columns []string) error
+	DeletePatron(p *Patron) error
+	GetPatronLoans(p *Patron, branchID int) ([]Loan, error)
+}
+
+// Cascade what deleting an author or publisher does to books that refer to it
+type Cascade string
+
+// valid cascades, by default deletion is refused while books refer to the record
+const (
+	CascadeNone   Cascade = ""
+	CascadeDetach Cascade = "detach"
+	CascadeDelete Cascade = "delete"
+)
+
+// ParseCascade reads the cascade query parameter
+func ParseCascade(s string) (Cascade, error) {
+	switch c := Cascade(s); c {
+	case CascadeNone, CascadeDetach, CascadeDelete:
+		return c, nil
+	}
+
+	return CascadeNone, fmt.Errorf("Invalid cascade '%s', expected detach or delete", s)
+}
+
+// BookRef identifies a book in error reports
+type BookRef struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+}
+
+// ReferencedError returned when deleting a record books still refer to
+type ReferencedError struct {
+	Books []BookRef
+}
+
+func (e *ReferencedError) Error() string {
+	return fmt.Sprintf("still referenced by %d books", len(e.Books))
+}
+
+// ErrDuplicateISBN another edition already has the ISBN
+var ErrDuplicateISBN = errors.New("duplicate isbn")
+
+// ErrVersionMismatch a conditional write found the record at another version
+var ErrVersionMismatch = errors.New("version mismatch")
+
+// Store everything the handlers need to persist
+type Store interface {
+	BookStore
+	ContributorStore
+	EditionStore
+	ItemStore
+	BranchStore
+	TransferStore
+	AuthorStore
+	PublisherStore
+	PatronStore
+	LoanStore
+	HoldStore
+	AccountStore
+	SearchStore
+	SuggestStore
+	MatchStore
+}
+
+// Migrater is implemented by stores that manage their own schema
+type Migrater interface {
+	Migrate() error
+}
+
+// PostgresStore backs the api with a postgres database
+type PostgresStore struct {
+	DB *sqlx.DB
+}
+
+// NewPostgresStore connects to the database at dataSourceName
+func NewPostgresStore(dataSourceName string) (*PostgresStore, error) {
+	db, err := sqlx.Connect("postgres", dataSourceName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PostgresStore{DB: db}, nil
+}
+
+// Migrate applies any pending schema migrations
+func (s *PostgresStore) Migrate() error {
+	m, err := NewMigrator(s.DB)
+	if err != nil {
+		return err
+	}
+
+	return m.Up()
+}
+
+// GetBook returns a book
+func (s *PostgresStore) GetBook(b *Book, expand Expand, columns []string) error {
+	return b.GetBook(s.DB, expand, columns)
+}
+
+// GetBooks returns a page of books and the total number of books
+func (s *PostgresStore) GetBooks(opts ListOptions) ([]Book, int, Facets, error) {
+	return GetBooks(s.DB, opts)
+}
+
+// CreateBook inserts a new book
+func (s *PostgresStore) CreateBook(b *Book) error {
+	return b.CreateBook(s.DB)
+}
+
+// UpdateBook updates a book
+func (s *PostgresStore) UpdateBook(b *Book) error {
+	return b.UpdateBook(s.DB)
+}
+
+// PatchBook updates only the given columns of a book
+func (s *PostgresStore) PatchBook(b *Book, columns []string) error {
+	return b.PatchBook(s.DB, columns)
+}
+
+// DeleteBook removes a book
+func (s *PostgresStore) DeleteBook(b *Book) error {
+	return b.DeleteBook(s.DB)
+}
+
+// GetContributors returns the contributors of a book in credit order
+func (s *PostgresStore) GetContributors(b *Book, expand Expand) ([]Contributor, error) {
+	if err := b.GetBook(s.DB, Expand{}, nil); err != nil {
+		return nil, err
+	}
+
+	contributors, err := GetContributors(s.DB, []int{b.ID}, expand)
+	if err != nil {
+		return nil, err
+	}
+
+	return contributorList(contributors[b.ID]), nil
+}
+
+// SetContributors replaces the contributors of a book
+func (s *PostgresStore) SetContributors(b *Book, contributors []Contributor) ([]Contributor, error) {
+	return b.SetContributors(s.DB, contributors)
+}
+
+// GetEdition returns an edition of a book
+func (s *PostgresStore) GetEdition(e *Edition) error {
+	return e.GetEdition(s.DB)
+}
+
+// GetEditionByISBN returns the edition with an ISBN
+func (s *PostgresStore) GetEditionByISBN(e *Edition) error {
+	return e.GetEditionByISBN(s.DB)
+}
+
+// GetEditions returns every edition of a book
+func (s *PostgresStore) GetEditions(b *Book) ([]Edition, error) {
+	return GetEditions(s.DB, b)
+}
+
+// CreateEdition inserts a new edition
+func (s *PostgresStore) CreateEdition(e *Edition) error {
+	return e.CreateEdition(s.DB)
+}
+
+// UpdateEdition updates an edition
+func (s *PostgresStore) UpdateEdition(e *Edition) error {
+	return e.UpdateEdition(s.DB)
+}
+
+// DeleteEdition removes an edition
+func (s *PostgresStore) DeleteEdition(e *Edition) error {
+	return e.DeleteEdition(s.DB)
+}
+
+// GetItem returns a copy of a book
+func (s *PostgresStore) GetItem(i *Item) error {
+	return i.GetItem(s.DB)
+}
+
+// GetItemByBarcode returns the copy with a barcode
+func (s *PostgresStore) GetItemByBarcode(i *Item) error {
+	return i.GetItemByBarcode(s.DB)
+}
+
+// GetItems returns every copy of a book
+func (s *PostgresStore) GetItems(b *Book) ([]Item, error) {
+	return GetItems(s.DB, b)
+}
+
+// CreateItem inserts a new copy
+func (s *PostgresStore) CreateItem(i *Item) error {
+	return i.CreateItem(s.DB)
+}
+
+// UpdateItem updates a copy
+func (s *PostgresStore) UpdateItem(i *Item) error {
+	return i.UpdateItem(s.DB)
+}
+
+// DeleteItem removes a copy
+func (s *PostgresStore) DeleteItem(i *Item) error {
+	return i.DeleteItem(s.DB)
+}
+
+// GetBranch returns a branch
+func (s *PostgresStore) GetBranch(b *Branch) error {
+	return b.GetBranch(s.DB)
+}
+
+// GetBranches returns a page of branches
+func (s *PostgresStore) GetBranches(opts ListOptions) ([]Branch, int, error) {
+	return GetBranches(s.DB, opts)
+}
+
+// CreateBranch inserts a new branch
+func (s *PostgresStore) CreateBranch(b *Branch) error {
+	return b.CreateBranch(s.DB)
+}
+
+// UpdateBranch updates a branch
+func (s *PostgresStore) UpdateBranch(b *Branch) error {
+	return b.UpdateBranch(s.DB)
+}
+
+// DeleteBranch removes a branch
+func (s *PostgresStore) DeleteBranch(b *Branch) error {
+	return b.DeleteBranch(s.DB)
+}
+
+// RequestTransfer asks for a copy to be sent to another branch
+func (s *PostgresStore) RequestTransfer(t *Transfer, note string) error {
+	return t.RequestTransfer(s.DB, note)
+}
+
+// MoveTransfer moves a transfer on to status
+func (s *PostgresStore) MoveTransfer(t *Transfer, status TransferStatus, note string) error {
+	return t.MoveTransfer(s.DB, status, note)
+}
+
+// GetTransfer returns a transfer and its trail
+func (s *PostgresStore) GetTransfer(t *Transfer) error {
+	return t.GetTransfer(s.DB)
+}
+
+// GetTransfers returns a page of transfers
+func (s *PostgresStore) GetTransfers(opts ListOptions) ([]Transfer, int, error) {
+	return GetTransfers(s.DB, opts)
+}
+
+// Checkout lends a copy of a book to a patron for period
+func (s *PostgresStore) Checkout(b *Book, item *Item, p *Patron, period time.Duration, fines FineSchedule, maxBalance int) (Loan, error) {
+	return b.Checkout(s.DB, item, p, period, fines, maxBalance)
+}
+
+// Checkin takes a copy of a book back
+func (s *PostgresStore) Checkin(b *Book, item *Item, fines FineSchedule) (Loan, error) {
+	return b.Checkin(s.DB, item, fines)
+}
+
+// GetLoans returns a book's loans
+func (s *PostgresStore) GetLoans(b *Book, branchID int) ([]Loan, error) {
+	return GetLoans(s.DB, b, branchID)
+}
+
+// PlaceHold queues a patron for a book
+func (s *PostgresStore) PlaceHold(b *Book, p *Patron, branch *Branch, pickup time.Duration) (Hold, error) {
+	return b.PlaceHold(s.DB, p, branch, pickup)
+}
+
+// GetHolds returns a book's queue
+func (s *PostgresStore) GetHolds(b *Book, branchID int) ([]Hold, error) {
+	return GetHolds(s.DB, b, branchID)
+}
+
+// GetHold returns a hold
+func (s *PostgresStore) GetHold(h *Hold) error {
+	return h.GetHold(s.DB)
+}
+
+// CancelHold takes a hold out of its queue
+func (s *PostgresStore) CancelHold(h *Hold) error {
+	return h.CancelHold(s.DB)
+}
+
+// GetPatronHolds returns a patron's holds
+func (s *PostgresStore) GetPatronHolds(p *Patron, branchID int) ([]Hold, error) {
+	return GetPatronHolds(s.DB, p, branchID)
+}
+
+// GetAccount returns a patron's ledger
+func (s *PostgresStore) GetAccount(p *Patron, branchID int) (Account, error) {
+	return GetAccount(s.DB, p, branchID)
+}
+
+// AddEntry settles part of a patron's balance
+func (s *PostgresStore) AddEntry(e *AccountEntry) error {
+	return e.AddEntry(s.DB)
+}
+
+// Search returns a page of matching records and the total number of matches
+func (s *PostgresStore) Search(text string, types []string, opts ListOptions) ([]SearchResult, int, Facets, error) {
+	return Search(s.DB, text, types, opts)
+}
+
+// Suggest returns the records whose names start with prefix
+func (s *PostgresStore) Suggest(prefix string, types []string, limit int) ([]Suggestion, error) {
+	return Suggest(s.DB, prefix, types, limit)
+}
+
+// MatchAuthors returns the authors most similar to m
+func (s *PostgresStore) MatchAuthors(m *Match, limit int) ([]Matched, error) {
+	return authorMatcher.Match(s.DB, m, limit)
+}
+
+// MatchBooks returns the books whose titles are most similar to m
+func (s *PostgresStore) MatchBooks(m *Match, limit int) ([]Matched, error) {
+	return bookMatcher.Match(s.DB, m, limit)
+}
+
+// GetAuthor returns an author
+func (s *PostgresStore) GetAuthor(author *Author, columns []string) error {
+	return author.GetAuthor(s.DB, columns)
+}
+
+// GetAuthors returns a page of authors and the total number of authors
+func (s *PostgresStore) GetAuthors(opts ListOptions) ([]Author, int, error) {
+	return GetAuthors(s.DB, opts)
+}
+
+// CreateAuthor inserts a new author
+func (s *PostgresStore) CreateAuthor(author *Author) error {
+	return author.CreateAuthor(s.DB)
+}
+
+// UpdateAuthor updates an author
+func (s *PostgresStore) UpdateAuthor(author *Author) error {
+	return author.UpdateAuthor(s.DB)
+}
+
+// PatchAuthor updates only the given columns of an author
+func (s *PostgresStore) PatchAuthor(author *Author, columns []string) error {
+	return author.PatchAuthor(s.DB, columns)
+}
+
+// DeleteAuthor removes an author
+func (s *PostgresStore) DeleteAuthor(author *Author, cascade Cascade) error {
+	return author.DeleteAuthor(s.DB, cascade)
+}
+
+// GetPublisher returns a publisher
+func (s *PostgresStore) GetPublisher(p *Publisher, columns []string) error {
+	return p.GetPublisher(s.DB, columns)
+}
+
+// GetPublishers returns a page of publishers and the total number of publishers
+func (s *PostgresStore) GetPublishers(opts ListOptions) ([]Publisher, int, error) {
+	return GetPublishers(s.DB, opts)
+}
+
+// CreatePublisher inserts a new publisher
+func (s *PostgresStore) CreatePublisher(p *Publisher) error {
+	return p.CreatePublisher(s.DB)
+}
+
+// UpdatePublisher updates a publisher
+func (s *PostgresStore) UpdatePublisher(p *Publisher) error {
+	return p.UpdatePublisher(s.DB)
+}
+
+// PatchPublisher updates only the given columns of a publisher
+func (s *PostgresStore) PatchPublisher(p *Publisher, columns []string) error {
+	return p.PatchPublisher(s.DB, columns)
+}
+
+// DeletePublisher removes a publisher
+func (s *PostgresStore) DeletePublisher(p *Publisher, cascade Cascade) error {
+	return p.DeletePublisher(s.DB, cascade)
+}
+
+// GetPatron returns a patron
+func (s *PostgresStore) GetPatron(p *Patron) error {
+	return p.GetPatron(s.DB)
+}
+
+// GetPatrons returns a page of patrons and the total count
+func (s *PostgresStore) GetPatrons(opts ListOptions) ([]Patron, int, error) {
+	return GetPatrons(s.DB, opts)
+}
+
+// CreatePatron inserts a new patron
+func (s *PostgresStore) CreatePatron(p *Patron) error {
+	return p.CreatePatron(s.DB)
+}
+
+// UpdatePatron updates a patron
+func (s *PostgresStore) UpdatePatron(p *Patron) error {
+	return p.UpdatePatron(s.DB)
+}
+
+// PatchPatron updates only the given columns of a patron
+func (s *PostgresStore) PatchPatron(p *Patron, columns []string) error {
+	return p.PatchPatron(s.DB, columns)
+}
+
+// DeletePatron removes a patron
+func (s *PostgresStore) DeletePatron(p *Patron) error {
+	return p.DeletePatron(s.DB)
+}
+
+// GetPatronLoans returns a patron's loans
+func (s *PostgresStore) GetPatronLoans(p *Patron, branchID int) ([]Loan, error) {
+	return GetPatronLoans(s.DB, p, branchID)
+}
+
+// placeholders returns a function that adds a value to args and returns
+// its placeholder
+func placeholders(args *[]interface{}) func(v interface{}) string {
+	return func(v interface{}) string {
+		*args = append(*args, v)
+		return "$" + strconv.Itoa(len(*args))
+	}
+}
+
+// where joins conditions into a WHERE clause, or nothing when there are none
+func where(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+
+	return " WHERE " + strings.Join(conditions, " AND ")
+}
+
+// countQuery counts the rows of table that pass opts.Filters
+func countQuery(table string, opts ListOptions) (string, []interface{}) {
+	return filterQuery("COUNT(*)", table, opts)
+}
+
+// filterQuery selects columns from the rows of table that pass opts.Filters
+func filterQuery(columns, table string, opts ListOptions) (string, []interface{}) {
+	args := []interface{}{}
+	conditions := filterConditions(opts.Filters, placeholders(&args))
+
+	return "SELECT " + columns + " FROM " + table + where(conditions), args
+}
+
+// beginRead starts a read only transaction that sees a single snapshot, so
+// a page, its total and its facets agree even while others write
+func beginRead(db *sqlx.DB) (*sqlx.Tx, error) {
+	return db.BeginTxx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+}
+
+// listQuery builds a SELECT over table filtered by opts.Filters and
+// ordered by opts.Sort with id as the tie breaker. With a cursor it seeks
+// past the cursor row instead of using OFFSET, so columns in opts.Sort must
+// already be whitelisted.
+func listQuery(columns, table string, opts ListOptions) (string, []interface{}) {
+	args := []interface{}{}
+	arg := placeholders(&args)
+
+	conditions := filterConditions(opts.Filters, arg)
+	if c := opts.Cursor; c != nil {
+		// (a > $1) OR (a = $1 AND b < $2) OR (a = $1 AND b = $2 AND id > $3)
+		ors := []string{}
+		eqs := []string{}
+		for i, f := range opts.Sort {
+			op := ">"
+			if f.Desc {
+				op = "<"
+			}
+			v := arg(c.Values[i])
+			ors = append(ors, "("+strings.Join(append(eqs[:len(eqs):len(eqs)], f.Column+" "+op+" "+v), " AND ")+")")
+			eqs = append(eqs, f.Column+" = "+v)
+		}
+		ors = append(ors, "("+strings.Join(append(eqs, "id > "+arg(c.ID)), " AND ")+")")
+		seek := strings.Join(ors, " OR ")
+		if len(conditions) > 0 {
+			seek = "(" + seek + ")"
+		}
+		conditions = append(conditions, seek)
+	}
+
+	order := []string{}
+	for _, f := range opts.Sort {
+		if f.Desc {
+			order = append(order, f.Column+" DESC")
+		} else {
+			order = append(order, f.Column)
+		}
+	}
+	order = append(order, "id")
+
+	query := fmt.Sprintf("SELECT %s FROM %s%s ORDER BY %s LIMIT %s", columns, table, where(conditions), strings.Join(order, ", "), arg(opts.Count))
+	if opts.Cursor == nil {
+		query += " OFFSET " + arg(opts.Start)
+	}
+
+	return query, args
+}
+
+// deleteReferenced removes row id from table when it is at version, or at
+// any version when version is 0, first dealing with the books refers
+// matches as cascade asks. refers is a condition on books with id as $1,
+// and detaching clears column. The row is locked so no new references can
+// be added between the check and the delete.
+func deleteReferenced(db *sqlx.DB, table, column, refers string, id, version int, cascade Cascade) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current int
+	if err := tx.Get(&current, "SELECT version FROM "+table+" WHERE id=$1 FOR UPDATE", id); err != nil {
+		return err
+	}
+	if version != 0 && version != current {
+		return ErrVersionMismatch
+	}
+
+	switch cascade {
+	case CascadeDetach:
+		_, err = tx.Exec("UPDATE books SET "+column+"=NULLIF("+column+", $1), version=version+1 WHERE "+refers, id)
+	case CascadeDelete:
+		err = deleteBooks(tx, refers, id)
+	default:
+		books := []BookRef{}
+		if err := tx.Select(&books, "SELECT id, title FROM books WHERE "+refers+" ORDER BY id", id); err != nil {
+			return err
+		}
+		if len(books) > 0 {
+			return &ReferencedError{Books: books}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM "+table+" WHERE id=$1", id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// columnMapper finds model fields by column name the same way sqlx does
+var columnMapper = reflectx.NewMapperFunc("db", sqlx.NameMapper)
+
+// columnValue value of the field of model stored in column
+func columnValue(model interface{}, column string) interface{} {
+	return columnField(reflect.Indirect(reflect.ValueOf(model)), column).Interface()
+}
+
+// copyColumns copies the fields stored in columns from src to dst
+func copyColumns(dst, src interface{}, columns []string) {
+	d, s := reflect.Indirect(reflect.ValueOf(dst)), reflect.Indirect(reflect.ValueOf(src))
+	for _, column := range columns {
+		columnField(d, column).Set(columnField(s, column))
+	}
+}
+
+// columnField the field of struct v stored in column. Unlike the mapper's
+// FieldByName it leaves a nil pointer nil rather than allocating a zero
+// value, so a column patched to null stays null.
+func columnField(v reflect.Value, column string) reflect.Value {
+	for _, i := range columnMapper.TypeMap(v.Type()).GetByPath(column).Index {
+		v = reflect.Indirect(v).Field(i)
+	}
+
+	return v
+}
+
+// patchRow updates only columns of row id in table with the values held by
+// src and scans the stored row back into dest. A non-zero version must
+// match the stored one.
+func patchRow(db sqlx.Queryer, dest interface{}, table, returning string, id, version int, columns []string, src interface{}) error {
+	sets, args := []string{}, []interface{}{}
+	for _, column := range columns {
+		args = append(args, columnValue(src, column))
+		sets = append(sets, fmt.Sprintf("%s=$%d", column, len(args)))
+	}
+	args = append(args, id, version)
+
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE id=$%d AND ($%d=0 OR version=$%d) RETURNING %s",
+		table, strings.Join(append(sets, "version=version+1"), ", "), len(args)-1, len(args), len(args), returning)
+	if len(columns) == 0 {
+		query = fmt.Sprintf("SELECT %s FROM %s WHERE id=$1 AND ($2=0 OR version=$2)", returning, table)
+	}
+
+	err := sqlx.Get(db, dest, query, args...)
+	if err == sql.ErrNoRows {
+		return missingOrStale(db, table, id)
+	}
+
+	return err
+}
+
+// missingOrStale explains why a conditional write to row id of table
+// matched nothing
+func missingOrStale(db sqlx.Queryer, table string, id int) error {
+	var exists bool
+	if err := sqlx.Get(db, &exists, "SELECT EXISTS(SELECT 1 FROM "+table+" WHERE id=$1)", id); err != nil {
+		return err
+	}
+
+	if exists {
+		return ErrVersionMismatch
+	}
+
+	return sql.ErrNoRows
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Suggestion a book title, author or publisher whose name starts with the
+// text typed so far. Popularity is how many loans its books have had, an
+// author's books being those they are credited on.
+type Suggestion struct {
+	Type       string `json:"type"`
+	ID         int    `json:"id"`
+	Text       string `json:"text"`
+	Popularity int    `json:"popularity"`
+}
+
+// suggestTypes types of record a suggestion can complete
+var suggestTypes = []string{"title", "author", "publisher"}
+
+// ParseSuggestTypes reads a comma separated list of types restricted to
+// suggestTypes, all of them when s is empty
+func ParseSuggestTypes(s string) ([]string, error) {
+	return parseTypes(s, suggestTypes, "suggestion type")
+}
+
+// authorFullName the author's name as indexed for prefix lookups
+const authorFullName = "lower(coalesce(first_name, '') || ' ' || coalesce(last_name, ''))"
+
+// suggestSources the query for each type, all taking the lower cased LIKE
+// pattern as $1. Every condition is backed by a text_pattern_ops index.
+var suggestSources = map[string]string{
+	"title": `SELECT 'title' AS type, id, title AS text,
+		(SELECT COUNT(*) FROM loans WHERE loans.book_id = books.id) AS popularity
+		FROM books WHERE lower(title) LIKE $1`,
+	"author": `SELECT 'author' AS type, id,
+		CASE WHEN lower(pen_name) LIKE $1 THEN pen_name ELSE concat_ws(' ', first_name, last_name) END AS text,
+		(SELECT COUNT(*) FROM loans WHERE loans.book_id IN (SELECT book_id FROM book_contributors WHERE book_contributors.author_id = authors.id)) AS popularity
+		FROM authors WHERE lower(first_name) LIKE $1 OR lower(last_name) LIKE $1 OR lower(pen_name) LIKE $1 OR ` + authorFullName + ` LIKE $1`,
+	"publisher": `SELECT 'publisher' AS type, id, name AS text,
+		(SELECT COUNT(*) FROM loans JOIN books ON books.id = loans.book_id WHERE books.publisher_id = publishers.id) AS popularity
+		FROM publishers WHERE lower(name) LIKE $1`,
+}
+
+// prefixPattern a LIKE pattern matching text starting with prefix, ignoring
+// case
+func prefixPattern(prefix string) string {
+	escape := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return escape.Replace(strings.ToLower(prefix)) + "%"
+}
+
+// Suggest returns up to limit records of types starting with prefix, the
+// most popular first and then the shortest, so exact matches lead
+func Suggest(db *sqlx.DB, prefix string, types []string, limit int) ([]Suggestion, error) {
+	sources := []string{}
+	for _, t := range types {
+		sources = append(sources, suggestSources[t])
+	}
+
+	suggestions := []Suggestion{}
+	query := fmt.Sprintf("SELECT type, id, text, popularity FROM (%s) suggestions ORDER BY popularity DESC, length(text), text, id LIMIT $2",
+		strings.Join(sources, " UNION ALL "))
+	if err := db.Select(&suggestions, query, prefixPattern(prefix), limit); err != nil {
+		return nil, err
+	}
+
+	return suggestions, nil
+}
@@ -0,0 +1,18 @@
+package main
+
+import "testing"
+
+func TestPrefixPattern(t *testing.T) {
+	cases := map[string]string{
+		"Tol":     "tol%",
+		"100%":    `100\%%`,
+		"a_b":     `a\_b%`,
+		`back\sl`: `back\\sl%`,
+	}
+
+	for prefix, expected := range cases {
+		if p := prefixPattern(prefix); p != expected {
+			t.Errorf("Expected '%s' to become '%s'. Got '%s'", prefix, expected, p)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrItemInTransit returned when lending, deleting or transferring a
+	// copy that is on its way between branches
+	ErrItemInTransit = errors.New("copy is in transit between branches")
+
+	// ErrTransferOpen returned when transferring a copy that already has a
+	// transfer under way
+	ErrTransferOpen = errors.New("copy already has a transfer under way")
+
+	// ErrSameBranch returned when transferring a copy to the branch it is
+	// shelved at
+	ErrSameBranch = errors.New("copy is already at this branch")
+
+	// ErrTransferState returned when a transfer can't move on to the
+	// status asked for from the one it is in
+	ErrTransferState = errors.New("transfer can't move on to this status")
+)
+
+// Transfer a copy's move from one branch to another. It is requested, sent
+// on its way and received, or cancelled before it leaves, and each step is
+// kept in its Events. The copy stays at FromBranchID until it is received.
+type Transfer struct {
+	ID           int             `json:"id"`
+	ItemID       int             `json:"itemId" db:"item_id"`
+	BookID       int             `json:"bookId" db:"book_id"`
+	FromBranchID int             `json:"fromBranchId" db:"from_branch_id"`
+	ToBranchID   int             `json:"toBranchId" db:"to_branch_id"`
+	Status       TransferStatus  `json:"status"`
+	RequestedAt  time.Time       `json:"requestedAt" db:"requested_at"`
+	UpdatedAt    time.Time       `json:"updatedAt" db:"updated_at"`
+	Events       []TransferEvent `json:"events,omitempty" db:"-"`
+	Version      int             `json:"-"`
+}
+
+// TransferEvent a step in a transfer's life, never changed once recorded
+type TransferEvent struct {
+	ID         int            `json:"id"`
+	TransferID int            `json:"-" db:"transfer_id"`
+	Status     TransferStatus `json:"status"`
+	Note       string         `json:"note,omitempty"`
+	CreatedAt  time.Time      `json:"createdAt" db:"created_at"`
+}
+
+// TransferStatus where a transfer is in its life
+type TransferStatus string
+
+// valid transfer statuses, requested and in transit transfers are open
+const (
+	TransferRequested TransferStatus = "requested"
+	TransferInTransit TransferStatus = "in_transit"
+	TransferReceived  TransferStatus = "received"
+	TransferCancelled TransferStatus = "cancelled"
+)
+
+// TransferStatuses every valid transfer status
+var TransferStatuses = []TransferStatus{TransferRequested, TransferInTransit, TransferReceived, TransferCancelled}
+
+// transferMoves the statuses a transfer can move on to from each status
+var transferMoves = map[TransferStatus][]TransferStatus{
+	TransferRequested: {TransferInTransit, TransferCancelled},
+	TransferInTransit: {TransferReceived},
+}
+
+// Open whether the transfer is still under way
+func (t Transfer) Open() bool {
+	return t.Status == TransferRequested || t.Status == TransferInTransit
+}
+
+// CanMove reports why t may not move on to status
+func (t Transfer) CanMove(status TransferStatus) error {
+	for _, next := range transferMoves[t.Status] {
+		if next == status {
+			return nil
+		}
+	}
+
+	return ErrTransferState
+}
+
+const transferColumns = "id, item_id, book_id, from_branch_id, to_branch_id, status, requested_at, updated_at, version"
+
+const transferEventColumns = "id, transfer_id, status, note, created_at"
+
+// transferSortColumns columns transfers can be sorted and paged by
+var transferSortColumns = []string{"id", "requested_at", "updated_at"}
+
+// SortKey value of a sortable column
+func (t Transfer) SortKey(column string) interface{} {
+	switch column {
+	case "id":
+		return t.ID
+	case "requested_at":
+		return t.RequestedAt
+	case "updated_at":
+		return t.UpdatedAt
+	}
+
+	return nil
+}
+
+// branchTransfers transfers from or to a branch, as a source aliased to
+// transfers
+func branchTransfers(branchID int) string {
+	return fmt.Sprintf("(SELECT * FROM transfers WHERE from_branch_id = %d OR to_branch_id = %d) transfers", branchID, branchID)
+}
+
+// RequestTransfer asks for t.ItemID to be sent from the branch it is at
+// to t.ToBranchID, noting why. A copy that is out or on the hold shelf may
+// be asked for, it is only sent once it is back on the shelf.
+func (t *Transfer) RequestTransfer(db *sqlx.DB, note string) error {
+	var bookID int
+	if err := db.Get(&bookID, "SELECT book_id FROM items WHERE id=$1", t.ItemID); err != nil {
+		return err
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockBook(tx, bookID); err != nil {
+		return err
+	}
+
+	var from int
+	if err := tx.Get(&from, "SELECT branch_id FROM items WHERE id=$1 AND book_id=$2", t.ItemID, bookID); err != nil {
+		return err
+	}
+	if from == t.ToBranchID {
+		return ErrSameBranch
+	}
+
+	var id int
+	err = tx.Get(&id, "INSERT INTO transfers (item_id, book_id, from_branch_id, to_branch_id) VALUES ($1, $2, $3, $4) RETURNING id",
+		t.ItemID, bookID, from, t.ToBranchID)
+	if e, ok := err.(*pq.Error); ok && e.Code == pqUniqueViolation {
+		return ErrTransferOpen
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO transfer_events (transfer_id, status, note) VALUES ($1, $2, $3)", id, TransferRequested, note); err != nil {
+		return err
+	}
+
+	transfer, err := getTransfer(tx, id)
+	if err != nil {
+		return err
+	}
+
+	*t = transfer
+	return tx.Commit()
+}
+
+// MoveTransfer moves t on to status, noting why. A copy is only sent on
+// its way from the shelf, and once received it is shelved at the branch it
+// was sent to, where it may go straight to a hold waiting to be picked up
+// there.
+func (t *Transfer) MoveTransfer(db *sqlx.DB, status TransferStatus, note string) error {
+	var bookID int
+	if err := db.Get(&bookID, "SELECT book_id FROM transfers WHERE id=$1", t.ID); err != nil {
+		return err
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockBook(tx, bookID); err != nil {
+		return err
+	}
+	if err := advanceHolds(tx, bookID); err != nil {
+		return err
+	}
+
+	transfer := Transfer{}
+	if err := tx.Get(&transfer, "SELECT "+transferColumns+" FROM transfers WHERE id=$1", t.ID); err != nil {
+		return err
+	}
+	if t.Version != 0 && t.Version != transfer.Version {
+		return ErrVersionMismatch
+	}
+	if err := transfer.CanMove(status); err != nil {
+		return err
+	}
+
+	if status == TransferInTransit {
+		var item Status
+		if err := tx.Get(&item, "SELECT status FROM items WHERE id=$1", transfer.ItemID); err != nil {
+			return err
+		}
+		switch item {
+		case CheckedOut:
+			return ErrItemOnLoan
+		case OnHoldShelf:
+			return ErrItemOnHold
+		}
+	}
+	if status == TransferReceived {
+		if _, err := tx.Exec("UPDATE items SET branch_id=$1, version=version+1 WHERE id=$2", transfer.ToBranchID, transfer.ItemID); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec("UPDATE transfers SET status=$1, updated_at=now(), version=version+1 WHERE id=$2", status, t.ID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO transfer_events (transfer_id, status, note) VALUES ($1, $2, $3)", t.ID, status, note); err != nil {
+		return err
+	}
+	if err := advanceHolds(tx, bookID); err != nil {
+		return err
+	}
+
+	if transfer, err = getTransfer(tx, t.ID); err != nil {
+		return err
+	}
+
+	*t = transfer
+	return tx.Commit()
+}
+
+// GetTransfer returns a transfer along with its events
+func (t *Transfer) GetTransfer(db *sqlx.DB) error {
+	tx, err := beginRead(db)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	transfer, err := getTransfer(tx, t.ID)
+	if err != nil {
+		return err
+	}
+
+	*t = transfer
+	return nil
+}
+
+// getTransfer reads a transfer and its events, the oldest first
+func getTransfer(tx *sqlx.Tx, id int) (Transfer, error) {
+	transfer := Transfer{}
+	if err := tx.Get(&transfer, "SELECT "+transferColumns+" FROM transfers WHERE id=$1", id); err != nil {
+		return Transfer{}, err
+	}
+	if err := tx.Select(&transfer.Events, "SELECT "+transferEventColumns+" FROM transfer_events WHERE transfer_id=$1 ORDER BY created_at, id", id); err != nil {
+		return Transfer{}, err
+	}
+
+	return transfer, nil
+}
+
+// GetTransfers returns a page of transfers, without their events, and the
+// total count. With opts.Branch set only transfers from or to that branch
+// are listed.
+func GetTransfers(db *sqlx.DB, opts ListOptions) ([]Transfer, int, error) {
+	source := "transfers"
+	if opts.Branch != 0 {
+		source = branchTransfers(opts.Branch)
+	}
+
+	var total int
+	count, countArgs := countQuery(source, opts)
+	if err := db.Get(&total, count, countArgs...); err != nil {
+		return nil, 0, err
+	}
+
+	transfers := []Transfer{}
+	query, args := listQuery(transferColumns, source, opts)
+	if err := db.Select(&transfers, query, args...); err != nil {
+		return nil, 0, err
+	}
+
+	return transfers, total, nil
+}
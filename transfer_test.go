@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestTransferCanMove(t *testing.T) {
+	cases := []struct {
+		from, to TransferStatus
+		allowed  bool
+	}{
+		{TransferRequested, TransferInTransit, true},
+		{TransferRequested, TransferCancelled, true},
+		{TransferRequested, TransferReceived, false},
+		{TransferInTransit, TransferReceived, true},
+		{TransferInTransit, TransferCancelled, false},
+		{TransferReceived, TransferInTransit, false},
+		{TransferCancelled, TransferInTransit, false},
+	}
+
+	for _, c := range cases {
+		err := Transfer{Status: c.from}.CanMove(c.to)
+		if c.allowed && err != nil {
+			t.Errorf("Expected %s to move on to %s. Got %v", c.from, c.to, err)
+		}
+		if !c.allowed && err != ErrTransferState {
+			t.Errorf("Expected %s not to move on to %s. Got %v", c.from, c.to, err)
+		}
+	}
+}
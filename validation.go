@@ -0,0 +1,332 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// MaxNameLength longest title or name accepted
+const MaxNameLength = 255
+
+// validation error codes
+const (
+	CodeRequired   = "required"
+	CodeTooLong    = "too_long"
+	CodeOutOfRange = "out_of_range"
+	CodeReadOnly   = "read_only"
+	CodeInvalid    = "invalid"
+	CodeDuplicate  = "duplicate"
+)
+
+// FieldError a single invalid field
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors every problem found with a model
+type ValidationErrors []FieldError
+
+// Error joins the messages so ValidationErrors can be returned as an error
+func (v ValidationErrors) Error() string {
+	messages := []string{}
+	for _, e := range v {
+		messages = append(messages, e.Message)
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+func (v *ValidationErrors) add(field, code, message string) {
+	*v = append(*v, FieldError{Field: field, Code: code, Message: message})
+}
+
+func (v *ValidationErrors) required(field, value string) {
+	if strings.TrimSpace(value) == "" {
+		v.add(field, CodeRequired, field+" is required")
+	}
+}
+
+func (v *ValidationErrors) maxLength(field, value string, max int) {
+	if utf8.RuneCountInString(value) > max {
+		v.add(field, CodeTooLong, fmt.Sprintf("%s must be at most %d characters", field, max))
+	}
+}
+
+// MaxBorrowingLimit most books a patron may be allowed out at once
+const MaxBorrowingLimit = 50
+
+var (
+	cardNumber = regexp.MustCompile(`^[A-Za-z0-9-]{4,32}$`)
+	barcode    = regexp.MustCompile(`^[A-Za-z0-9-]{4,32}$`)
+	branchCode = regexp.MustCompile(`^[A-Za-z0-9-]{2,16}$`)
+	email      = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+	phone      = regexp.MustCompile(`^\+?[0-9 ().-]{5,20}$`)
+)
+
+// MaxPageCount longest edition accepted
+const MaxPageCount = 100000
+
+// languageTag a two or three letter ISO 639 code with an optional region
+var languageTag = regexp.MustCompile(`^[a-z]{2,3}(-[A-Z]{2})?$`)
+
+// publishedDateBounds earliest and latest accepted publication dates, books
+// may be announced a few years before they are published
+func publishedDateBounds() (time.Time, time.Time) {
+	return time.Date(1000, 1, 1, 0, 0, 0, 0, time.UTC), time.Now().AddDate(5, 0, 0)
+}
+
+func (v *ValidationErrors) publishedDate(date time.Time) {
+	if date.IsZero() {
+		return
+	}
+
+	min, max := publishedDateBounds()
+	if date.Before(min) || date.After(max) {
+		v.add("publishedDate", CodeOutOfRange, fmt.Sprintf("publishedDate must be between %s and %s", min.Format("2006-01-02"), max.Format("2006-01-02")))
+	}
+}
+
+// Validate checks a book before it is stored
+func (b *Book) Validate() ValidationErrors {
+	errs := ValidationErrors{}
+
+	errs.required("title", b.Title)
+	errs.maxLength("title", b.Title, MaxNameLength)
+
+	if b.Rating != 0 && (b.Rating < OneStar || b.Rating > ThreeStars) {
+		errs.add("rating", CodeOutOfRange, fmt.Sprintf("rating must be between %d and %d", OneStar, ThreeStars))
+	}
+
+	errs.publishedDate(b.PublishedDate)
+
+	return errs
+}
+
+// Validate checks an author before it is stored
+func (b *Author) Validate() ValidationErrors {
+	errs := ValidationErrors{}
+
+	if strings.TrimSpace(b.FirstName+b.LastName+b.PenName) == "" {
+		errs.add("name", CodeRequired, "one of firstName, lastName or penName is required")
+	}
+	errs.maxLength("firstName", b.FirstName, MaxNameLength)
+	errs.maxLength("lastName", b.LastName, MaxNameLength)
+	errs.maxLength("penName", b.PenName, MaxNameLength)
+
+	return errs
+}
+
+// Validate checks a publisher before it is stored
+func (p *Publisher) Validate() ValidationErrors {
+	errs := ValidationErrors{}
+
+	errs.required("name", p.Name)
+	errs.maxLength("name", p.Name, MaxNameLength)
+
+	return errs
+}
+
+// ValidateContributors checks a book's contributor list before it is stored
+func ValidateContributors(contributors []Contributor) ValidationErrors {
+	errs := ValidationErrors{}
+
+	seen := map[Contributor]bool{}
+	for i, c := range contributors {
+		field := fmt.Sprintf("[%d]", i)
+		if c.AuthorID < 1 {
+			errs.add(field+".authorId", CodeRequired, field+".authorId is required")
+		}
+
+		valid := false
+		for _, role := range Roles {
+			if role == c.Role {
+				valid = true
+			}
+		}
+		if !valid {
+			errs.add(field+".role", CodeOutOfRange, fmt.Sprintf("%s.role must be one of %v", field, Roles))
+		}
+
+		key := Contributor{AuthorID: c.AuthorID, Role: c.Role}
+		if seen[key] {
+			errs.add(field, CodeDuplicate, fmt.Sprintf("author %d is already credited as %s", c.AuthorID, c.Role))
+		}
+		seen[key] = true
+	}
+
+	return errs
+}
+
+// Validate checks an edition before it is stored, either ISBN form is
+// accepted and both must agree when given
+func (e *Edition) Validate() ValidationErrors {
+	errs := ValidationErrors{}
+
+	isbn13, err13 := NormalizeISBN(e.ISBN13)
+	isbn10, err10 := NormalizeISBN(e.ISBN10)
+	switch {
+	case e.ISBN13 == "" && e.ISBN10 == "":
+		errs.add("isbn13", CodeRequired, "isbn13 or isbn10 is required")
+	case e.ISBN13 != "" && err13 != nil:
+		errs.add("isbn13", CodeInvalid, "isbn13 is not a valid ISBN")
+	case e.ISBN10 != "" && err10 != nil:
+		errs.add("isbn10", CodeInvalid, "isbn10 is not a valid ISBN")
+	case e.ISBN13 != "" && e.ISBN10 != "" && isbn13 != isbn10:
+		errs.add("isbn10", CodeInvalid, "isbn10 and isbn13 are different ISBNs")
+	}
+
+	if e.Format != "" {
+		valid := false
+		for _, format := range Formats {
+			if format == e.Format {
+				valid = true
+			}
+		}
+		if !valid {
+			errs.add("format", CodeOutOfRange, fmt.Sprintf("format must be one of %v", Formats))
+		}
+	}
+
+	if e.PageCount < 0 || e.PageCount > MaxPageCount {
+		errs.add("pageCount", CodeOutOfRange, fmt.Sprintf("pageCount must be between 0 and %d", MaxPageCount))
+	}
+
+	if e.Language != "" && !languageTag.MatchString(e.Language) {
+		errs.add("language", CodeInvalid, "language must be an ISO 639 code such as en or en-GB")
+	}
+
+	errs.publishedDate(e.PublishedDate)
+
+	return errs
+}
+
+// Validate checks a payment or waiver before it is added to a ledger
+func (e *AccountEntry) Validate() ValidationErrors {
+	errs := ValidationErrors{}
+
+	if e.Amount <= 0 {
+		errs.add("amount", CodeOutOfRange, "amount must be a positive number of cents")
+	}
+	if e.Kind == EntryWaiver {
+		errs.required("note", e.Note)
+	}
+	errs.maxLength("note", e.Note, MaxNameLength)
+
+	return errs
+}
+
+// Validate checks a patron before it is stored
+func (p *Patron) Validate() ValidationErrors {
+	errs := ValidationErrors{}
+
+	if p.CardNumber == "" {
+		errs.add("cardNumber", CodeRequired, "cardNumber is required")
+	} else if !cardNumber.MatchString(p.CardNumber) {
+		errs.add("cardNumber", CodeInvalid, "cardNumber must be 4 to 32 letters, digits or hyphens")
+	}
+
+	errs.required("name", p.Name)
+	errs.maxLength("name", p.Name, MaxNameLength)
+
+	if p.Email != "" && !email.MatchString(p.Email) {
+		errs.add("email", CodeInvalid, "email is not a valid address")
+	}
+	if p.Phone != "" && !phone.MatchString(p.Phone) {
+		errs.add("phone", CodeInvalid, "phone is not a valid number")
+	}
+
+	valid := false
+	for _, status := range PatronStatuses {
+		if status == p.Status {
+			valid = true
+		}
+	}
+	if !valid {
+		errs.add("status", CodeOutOfRange, fmt.Sprintf("status must be one of %v", PatronStatuses))
+	}
+
+	if p.ExpiresAt.IsZero() {
+		errs.add("expiresAt", CodeRequired, "expiresAt is required")
+	}
+
+	if p.BorrowingLimit < 0 || p.BorrowingLimit > MaxBorrowingLimit {
+		errs.add("borrowingLimit", CodeOutOfRange, fmt.Sprintf("borrowingLimit must be between 0 and %d", MaxBorrowingLimit))
+	}
+
+	return errs
+}
+
+// Validate checks a copy before it is stored
+func (i *Item) Validate() ValidationErrors {
+	errs := ValidationErrors{}
+
+	if i.Barcode == "" {
+		errs.add("barcode", CodeRequired, "barcode is required")
+	} else if !barcode.MatchString(i.Barcode) {
+		errs.add("barcode", CodeInvalid, "barcode must be 4 to 32 letters, digits or hyphens")
+	}
+
+	errs.maxLength("callNumber", i.CallNumber, MaxNameLength)
+	errs.maxLength("location", i.Location, MaxNameLength)
+
+	valid := false
+	for _, condition := range Conditions {
+		if condition == i.Condition {
+			valid = true
+		}
+	}
+	if !valid {
+		errs.add("condition", CodeOutOfRange, fmt.Sprintf("condition must be one of %v", Conditions))
+	}
+
+	errs.required("itemType", i.ItemType)
+	errs.maxLength("itemType", i.ItemType, MaxNameLength)
+
+	return errs
+}
+
+// Validate checks a branch before it is stored
+func (b *Branch) Validate() ValidationErrors {
+	errs := ValidationErrors{}
+
+	if b.Code == "" {
+		errs.add("code", CodeRequired, "code is required")
+	} else if !branchCode.MatchString(b.Code) {
+		errs.add("code", CodeInvalid, "code must be 2 to 16 letters, digits or hyphens")
+	}
+
+	errs.required("name", b.Name)
+	errs.maxLength("name", b.Name, MaxNameLength)
+	errs.maxLength("address", b.Address, MaxNameLength)
+
+	return errs
+}
+
+// Validate checks a request to transfer a copy
+func (t *transferRequest) Validate() ValidationErrors {
+	errs := ValidationErrors{}
+
+	if t.ItemID < 1 {
+		errs.add("itemId", CodeRequired, "itemId is required")
+	}
+	if t.ToBranchID < 1 {
+		errs.add("toBranchId", CodeRequired, "toBranchId is required")
+	}
+	errs.maxLength("note", t.Note, MaxNameLength)
+
+	return errs
+}
+
+// Validate checks a step in a transfer
+func (t *transferStep) Validate() ValidationErrors {
+	errs := ValidationErrors{}
+
+	errs.maxLength("note", t.Note, MaxNameLength)
+
+	return errs
+}
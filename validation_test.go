@@ -0,0 +1,214 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateBook(t *testing.T) {
+	cases := []struct {
+		book  Book
+		field string
+		code  string
+	}{
+		{Book{Title: "The Hobbit"}, "", ""},
+		{Book{Title: "The Hobbit", Rating: ThreeStars, Copies: 2, Available: 1}, "", ""},
+		{Book{Title: "  "}, "title", CodeRequired},
+		{Book{Title: strings.Repeat("a", MaxNameLength+1)}, "title", CodeTooLong},
+		{Book{Title: "The Hobbit", Rating: 42}, "rating", CodeOutOfRange},
+		{Book{Title: "The Hobbit", PublishedDate: time.Date(999, 1, 1, 0, 0, 0, 0, time.UTC)}, "publishedDate", CodeOutOfRange},
+		{Book{Title: "The Hobbit", PublishedDate: time.Now().AddDate(50, 0, 0)}, "publishedDate", CodeOutOfRange},
+	}
+
+	for _, c := range cases {
+		errs := c.book.Validate()
+		if c.field == "" {
+			if len(errs) != 0 {
+				t.Errorf("Expected %+v to be valid. Got %v", c.book, errs)
+			}
+			continue
+		}
+
+		if len(errs) != 1 || errs[0].Field != c.field || errs[0].Code != c.code {
+			t.Errorf("Expected a single %s error on %s. Got %+v", c.code, c.field, errs)
+		}
+	}
+}
+
+func TestValidateAuthorAndPublisher(t *testing.T) {
+	if errs := (&Author{PenName: "George Eliot"}).Validate(); len(errs) != 0 {
+		t.Errorf("Expected an author with only a pen name to be valid. Got %v", errs)
+	}
+
+	if errs := (&Author{}).Validate(); len(errs) != 1 || errs[0].Code != CodeRequired {
+		t.Errorf("Expected an author with no names to be invalid. Got %v", errs)
+	}
+
+	if errs := (&Publisher{}).Validate(); len(errs) != 1 || errs[0].Field != "name" {
+		t.Errorf("Expected a publisher without a name to be invalid. Got %v", errs)
+	}
+}
+
+func TestValidateEdition(t *testing.T) {
+	cases := []struct {
+		edition Edition
+		field   string
+		code    string
+	}{
+		{Edition{ISBN13: "978-0-261-10320-7"}, "", ""},
+		{Edition{ISBN10: "0261103202", ISBN13: "9780261103207", Format: FormatEbook, Language: "en-GB"}, "", ""},
+		{Edition{}, "isbn13", CodeRequired},
+		{Edition{ISBN13: "9780261103208"}, "isbn13", CodeInvalid},
+		{Edition{ISBN10: "0261103203"}, "isbn10", CodeInvalid},
+		{Edition{ISBN10: "080442957X", ISBN13: "9780261103207"}, "isbn10", CodeInvalid},
+		{Edition{ISBN13: "9780261103207", Format: "scroll"}, "format", CodeOutOfRange},
+		{Edition{ISBN13: "9780261103207", PageCount: -1}, "pageCount", CodeOutOfRange},
+		{Edition{ISBN13: "9780261103207", Language: "English"}, "language", CodeInvalid},
+	}
+
+	for _, c := range cases {
+		errs := c.edition.Validate()
+		if c.field == "" {
+			if len(errs) != 0 {
+				t.Errorf("Expected %+v to be valid. Got %v", c.edition, errs)
+			}
+			continue
+		}
+
+		if len(errs) != 1 || errs[0].Field != c.field || errs[0].Code != c.code {
+			t.Errorf("Expected a single %s error on %s. Got %+v", c.code, c.field, errs)
+		}
+	}
+}
+
+func TestValidatePatron(t *testing.T) {
+	valid := Patron{CardNumber: "A-1001", Name: "Ada Lovelace", Status: PatronActive, ExpiresAt: time.Now(), BorrowingLimit: 5}
+
+	cases := []struct {
+		change func(p *Patron)
+		field  string
+		code   string
+	}{
+		{func(p *Patron) {}, "", ""},
+		{func(p *Patron) { p.Email, p.Phone = "ada@example.com", "+44 (20) 7946-0000" }, "", ""},
+		{func(p *Patron) { p.CardNumber = "" }, "cardNumber", CodeRequired},
+		{func(p *Patron) { p.CardNumber = "A 1" }, "cardNumber", CodeInvalid},
+		{func(p *Patron) { p.Name = " " }, "name", CodeRequired},
+		{func(p *Patron) { p.Email = "ada@example" }, "email", CodeInvalid},
+		{func(p *Patron) { p.Phone = "call me" }, "phone", CodeInvalid},
+		{func(p *Patron) { p.Status = "banned" }, "status", CodeOutOfRange},
+		{func(p *Patron) { p.ExpiresAt = time.Time{} }, "expiresAt", CodeRequired},
+		{func(p *Patron) { p.BorrowingLimit = MaxBorrowingLimit + 1 }, "borrowingLimit", CodeOutOfRange},
+	}
+
+	for _, c := range cases {
+		p := valid
+		c.change(&p)
+		errs := p.Validate()
+		if c.field == "" {
+			if len(errs) != 0 {
+				t.Errorf("Expected %+v to be valid. Got %v", p, errs)
+			}
+			continue
+		}
+
+		if len(errs) != 1 || errs[0].Field != c.field || errs[0].Code != c.code {
+			t.Errorf("Expected a single %s error on %s. Got %+v", c.code, c.field, errs)
+		}
+	}
+}
+
+func TestValidateAccountEntry(t *testing.T) {
+	cases := []struct {
+		entry AccountEntry
+		field string
+		code  string
+	}{
+		{AccountEntry{Kind: EntryPayment, Amount: 250}, "", ""},
+		{AccountEntry{Kind: EntryWaiver, Amount: 250, Note: "lost in the post"}, "", ""},
+		{AccountEntry{Kind: EntryPayment, Amount: 0}, "amount", CodeOutOfRange},
+		{AccountEntry{Kind: EntryPayment, Amount: -5}, "amount", CodeOutOfRange},
+		{AccountEntry{Kind: EntryWaiver, Amount: 250}, "note", CodeRequired},
+		{AccountEntry{Kind: EntryPayment, Amount: 250, Note: strings.Repeat("a", MaxNameLength+1)}, "note", CodeTooLong},
+	}
+
+	for _, c := range cases {
+		errs := c.entry.Validate()
+		if c.field == "" {
+			if len(errs) != 0 {
+				t.Errorf("Expected %+v to be valid. Got %v", c.entry, errs)
+			}
+			continue
+		}
+
+		if len(errs) != 1 || errs[0].Field != c.field || errs[0].Code != c.code {
+			t.Errorf("Expected a single %s error on %s. Got %+v", c.code, c.field, errs)
+		}
+	}
+}
+
+func TestValidateItem(t *testing.T) {
+	valid := Item{Barcode: "C-0001", CallNumber: "823.912 TOL", Location: "Stacks", Condition: ConditionGood, ItemType: ItemTypeBook}
+
+	cases := []struct {
+		change func(i *Item)
+		field  string
+		code   string
+	}{
+		{func(i *Item) {}, "", ""},
+		{func(i *Item) { i.Barcode = "" }, "barcode", CodeRequired},
+		{func(i *Item) { i.Barcode = "C 1" }, "barcode", CodeInvalid},
+		{func(i *Item) { i.Location = strings.Repeat("a", MaxNameLength+1) }, "location", CodeTooLong},
+		{func(i *Item) { i.Condition = "mint" }, "condition", CodeOutOfRange},
+		{func(i *Item) { i.ItemType = " " }, "itemType", CodeRequired},
+	}
+
+	for _, c := range cases {
+		i := valid
+		c.change(&i)
+		errs := i.Validate()
+		if c.field == "" {
+			if len(errs) != 0 {
+				t.Errorf("Expected %+v to be valid. Got %v", i, errs)
+			}
+			continue
+		}
+
+		if len(errs) != 1 || errs[0].Field != c.field || errs[0].Code != c.code {
+			t.Errorf("Expected a single %s error on %s. Got %+v", c.code, c.field, errs)
+		}
+	}
+}
+
+func TestValidateBranch(t *testing.T) {
+	valid := Branch{Code: "EAST", Name: "East branch", Address: "1 Harbour Road"}
+
+	cases := []struct {
+		change func(b *Branch)
+		field  string
+		code   string
+	}{
+		{func(b *Branch) {}, "", ""},
+		{func(b *Branch) { b.Code = "" }, "code", CodeRequired},
+		{func(b *Branch) { b.Code = "E" }, "code", CodeInvalid},
+		{func(b *Branch) { b.Name = " " }, "name", CodeRequired},
+		{func(b *Branch) { b.Address = strings.Repeat("a", MaxNameLength+1) }, "address", CodeTooLong},
+	}
+
+	for _, c := range cases {
+		b := valid
+		c.change(&b)
+		errs := b.Validate()
+		if c.field == "" {
+			if len(errs) != 0 {
+				t.Errorf("Expected %+v to be valid. Got %v", b, errs)
+			}
+			continue
+		}
+
+		if len(errs) != 1 || errs[0].Field != c.field || errs[0].Code != c.code {
+			t.Errorf("Expected a single %s error on %s. Got %+v", c.code, c.field, errs)
+		}
+	}
+}